@@ -0,0 +1,159 @@
+package tsf
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"github.com/hisonsoft/tsf-go/naming"
+	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+const (
+	statusUp   = "up"
+	statusDown = "down"
+
+	adminServiceName = "tsf.admin.v1.Instance"
+)
+
+// MarkDown takes the running application instance out of rotation: consumers
+// stop routing new requests to it, while the process keeps running and
+// serving in-flight requests, e.g. for debugging or profiling.
+func MarkDown(ctx context.Context) error {
+	return appRegistrar().SetStatus(ctx, naming.StatusDown)
+}
+
+// MarkUp puts the application instance back into rotation
+func MarkUp(ctx context.Context) error {
+	return appRegistrar().SetStatus(ctx, naming.StatusUp)
+}
+
+// InstanceStatus returns "down" if the application instance is marked down,
+// otherwise "up"
+func InstanceStatus() string {
+	for _, ins := range appRegistrar().Instances() {
+		if ins.Status == naming.StatusDown {
+			return statusDown
+		}
+	}
+	return statusUp
+}
+
+// adminAuthorized 校验管理接口的token，未配置tsf_admin_token时拒绝所有请求
+func adminAuthorized(auth string) error {
+	token := env.AdminToken()
+	if token == "" {
+		return errors.Forbidden("AdminDisabled", "admin api is disabled, tsf_admin_token is not set")
+	}
+	if len(auth) <= 7 || !strings.EqualFold(auth[:7], "Bearer ") ||
+		subtle.ConstantTimeCompare([]byte(strings.TrimSpace(auth[7:])), []byte(token)) != 1 {
+		return errors.Unauthorized("AdminUnauthorized", "invalid admin token")
+	}
+	return nil
+}
+
+// AdminHandler returns a http handler switching the instance status:
+// GET returns the current status, PUT/POST with ?status=down|up changes it.
+// The requests must carry "Authorization: Bearer <tsf_admin_token>", it
+// should also only be served on an internal port.
+func AdminHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := adminAuthorized(r.Header.Get("Authorization")); err != nil {
+			se := errors.FromError(err)
+			http.Error(w, se.Message, int(se.Code))
+			return
+		}
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPut, http.MethodPost:
+			var err error
+			switch r.URL.Query().Get("status") {
+			case statusDown:
+				err = MarkDown(r.Context())
+			case statusUp:
+				err = MarkUp(r.Context())
+			default:
+				http.Error(w, "status must be up or down", http.StatusBadRequest)
+				return
+			}
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		default:
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]string{"status": InstanceStatus()})
+	})
+}
+
+// RegisterAdminServer registers the tsf.admin.v1.Instance grpc service with
+// the methods MarkDown, MarkUp and Status, all of them take a
+// google.protobuf.Empty and return the current status as a
+// google.protobuf.StringValue. The calls must carry the metadata
+// "authorization: Bearer <tsf_admin_token>", it should be registered on a
+// server listening on an internal port rather than the business server.
+func RegisterAdminServer(s grpc.ServiceRegistrar) {
+	s.RegisterService(&adminServiceDesc, struct{}{})
+}
+
+type adminServer interface{}
+
+var adminServiceDesc = grpc.ServiceDesc{
+	ServiceName: adminServiceName,
+	HandlerType: (*adminServer)(nil),
+	Methods: []grpc.MethodDesc{
+		adminMethod("MarkDown", MarkDown),
+		adminMethod("MarkUp", MarkUp),
+		adminMethod("Status", nil),
+	},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "tsf/admin/v1/admin.proto",
+}
+
+func adminMethod(name string, f func(context.Context) error) grpc.MethodDesc {
+	return grpc.MethodDesc{
+		MethodName: name,
+		Handler: func(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+			in := new(emptypb.Empty)
+			if err := dec(in); err != nil {
+				return nil, err
+			}
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				var auth string
+				if md, ok := metadata.FromIncomingContext(ctx); ok {
+					if v := md.Get("authorization"); len(v) > 0 {
+						auth = v[0]
+					}
+				}
+				if err := adminAuthorized(auth); err != nil {
+					return nil, err
+				}
+				if f != nil {
+					if err := f(ctx); err != nil {
+						return nil, err
+					}
+				}
+				return wrapperspb.String(InstanceStatus()), nil
+			}
+			if interceptor == nil {
+				return handler(ctx, in)
+			}
+			info := &grpc.UnaryServerInfo{
+				Server:     srv,
+				FullMethod: "/" + adminServiceName + "/" + name,
+			}
+			return interceptor(ctx, in, info, handler)
+		},
+	}
+}
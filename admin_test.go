@@ -0,0 +1,132 @@
+package tsf
+
+import (
+	"context"
+	"flag"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/naming"
+	"github.com/hisonsoft/tsf-go/naming/consul"
+	"github.com/hisonsoft/tsf-go/pkg/consultest"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/registry"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestAdminAuthorized(t *testing.T) {
+	defer flag.Set("tsf_admin_token", "")
+	// 未配置token时管理接口不可用
+	req := httptest.NewRequest(http.MethodPut, "/admin/status?status=down", nil)
+	w := httptest.NewRecorder()
+	AdminHandler().ServeHTTP(w, req)
+	if w.Code != http.StatusForbidden {
+		t.Fatalf("expect 403 without admin token configured, got %d", w.Code)
+	}
+
+	flag.Set("tsf_admin_token", "secret")
+	for _, auth := range []string{"", "secret", "Bearer other", "Basic secret"} {
+		req = httptest.NewRequest(http.MethodPut, "/admin/status?status=down", nil)
+		req.Header.Set("Authorization", auth)
+		w = httptest.NewRecorder()
+		AdminHandler().ServeHTTP(w, req)
+		if w.Code != http.StatusUnauthorized {
+			t.Fatalf("%q: expect 401, got %d", auth, w.Code)
+		}
+	}
+	if err := adminAuthorized("Bearer secret"); err != nil {
+		t.Fatalf("expect authorized, got %v", err)
+	}
+	if err := adminAuthorized("bearer other"); !errors.IsUnauthorized(err) {
+		t.Fatalf("expect unauthorized, got %v", err)
+	}
+}
+
+func TestAdminStatus(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	r := consul.New(&consul.Config{Address: []string{srv.Addr()}})
+	ki := &registry.ServiceInstance{ID: "admin-1", Name: "provider", Endpoints: []string{"grpc://127.0.0.1:9000"}}
+	if err := r.Register(context.Background(), ki); err != nil {
+		t.Fatalf("register failed!err:=%v", err)
+	}
+	defer r.Deregister(context.Background(), ki)
+	regMu.Lock()
+	registrar = r
+	regMu.Unlock()
+	defer func() {
+		regMu.Lock()
+		registrar = nil
+		regMu.Unlock()
+	}()
+	flag.Set("tsf_admin_token", "secret")
+	defer flag.Set("tsf_admin_token", "")
+
+	// 注册中心中实例的tsf_status
+	expectStatus := func(expect string) {
+		t.Helper()
+		inss := srv.Instances("", "provider_grpc")
+		if len(inss) != 1 || inss[0].Meta[naming.StatusKey] != expect {
+			t.Fatalf("expect tsf_status %s, got %+v", expect, inss)
+		}
+	}
+
+	for _, tc := range []struct {
+		status string
+		meta   string
+	}{{statusDown, "1"}, {statusUp, "0"}} {
+		req := httptest.NewRequest(http.MethodPut, "/admin/status?status="+tc.status, nil)
+		req.Header.Set("Authorization", "Bearer secret")
+		w := httptest.NewRecorder()
+		AdminHandler().ServeHTTP(w, req)
+		if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), `"status":"`+tc.status+`"`) {
+			t.Fatalf("expect status %s, got %d %s", tc.status, w.Code, w.Body.String())
+		}
+		expectStatus(tc.meta)
+	}
+
+	gsrv := grpc.NewServer()
+	RegisterAdminServer(gsrv)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go gsrv.Serve(lis)
+	defer gsrv.Stop()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	invoke := func(ctx context.Context, method string) (string, error) {
+		out := new(wrapperspb.StringValue)
+		err := conn.Invoke(ctx, "/"+adminServiceName+"/"+method, new(emptypb.Empty), out)
+		return out.GetValue(), err
+	}
+	if _, err := invoke(ctx, "MarkDown"); !errors.IsUnauthorized(errors.FromError(err)) {
+		t.Fatalf("expect unauthorized without token, got %v", err)
+	}
+	expectStatus("0")
+	actx := metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer secret")
+	for _, tc := range []struct {
+		method string
+		status string
+		meta   string
+	}{{"MarkDown", statusDown, "1"}, {"Status", statusDown, "1"}, {"MarkUp", statusUp, "0"}} {
+		status, err := invoke(actx, tc.method)
+		if err != nil || status != tc.status {
+			t.Fatalf("%s: expect %s, got %s %v", tc.method, tc.status, status, err)
+		}
+		expectStatus(tc.meta)
+	}
+}
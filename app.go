@@ -1,8 +1,14 @@
 package tsf
 
 import (
+	"context"
+	"sync"
+
+	"github.com/hisonsoft/tsf-go/health"
 	"github.com/hisonsoft/tsf-go/naming/consul"
+	tsfHttp "github.com/hisonsoft/tsf-go/pkg/http"
 	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+	"github.com/hisonsoft/tsf-go/pkg/sys/metrics"
 	"github.com/hisonsoft/tsf-go/pkg/version"
 
 	"github.com/go-kratos/kratos/v2"
@@ -39,12 +45,29 @@ func Medata(metadata map[string]string) Option {
 	}
 }
 
+// HealthChecker sets the checker deciding the status sent by registry heartbeats
+func HealthChecker(c *health.Checker) Option {
+	return func(a *appOptions) {
+		a.health = c
+	}
+}
+
+// HealthCheckURL makes consul probe url (e.g. served by health.Checker.Handler)
+// instead of relying on TTL heartbeats
+func HealthCheckURL(url string) Option {
+	return func(a *appOptions) {
+		a.healthCheckURL = url
+	}
+}
+
 type appOptions struct {
 	protoService   string
 	srv            *grpc.Server
 	apiMeta        bool
 	enableReigstry bool
 	metadata       map[string]string
+	health         *health.Checker
+	healthCheckURL string
 }
 
 func APIMeta(enable bool) Option {
@@ -101,7 +124,53 @@ func ID(optFuncs ...Option) kratos.Option {
 	return kratos.ID(env.InstanceId())
 }
 func Registrar(optFuncs ...Option) kratos.Option {
-	return kratos.Registrar(consul.DefaultConsul())
+	var opts appOptions
+	for _, o := range optFuncs {
+		o(&opts)
+	}
+	r := consul.DefaultConsul()
+	if opts.health != nil || opts.healthCheckURL != "" {
+		r = consul.New(&consul.Config{
+			Address:   env.ConsulAddressList(),
+			TokenFunc: env.ConsulToken,
+			TLS:       tsfHttp.ConsulTLSConfig(),
+			Health:    opts.health,
+			HTTPCheck: opts.healthCheckURL,
+		})
+	}
+	regMu.Lock()
+	registrar = r
+	regMu.Unlock()
+	if r != consul.DefaultConsul() {
+		metrics.RegisterDiscovery("registry", r)
+	}
+	return kratos.Registrar(r)
+}
+
+var (
+	regMu     sync.Mutex
+	registrar *consul.Consul
+)
+
+func appRegistrar() *consul.Consul {
+	regMu.Lock()
+	defer regMu.Unlock()
+	if registrar == nil {
+		return consul.DefaultConsul()
+	}
+	return registrar
+}
+
+// SetMetadata updates the metadata of the running application instance
+// without restart, e.g. a weight or a maintenance flag; an empty value
+// deletes the key. Consumers see the change through their watchers.
+func SetMetadata(ctx context.Context, md map[string]string) error {
+	return appRegistrar().SetMetadata(ctx, md)
+}
+
+// SetTags replaces the tags of the running application instance
+func SetTags(ctx context.Context, tags []string) error {
+	return appRegistrar().SetTags(ctx, tags)
 }
 
 func AppOptions(opts ...Option) []kratos.Option {
@@ -116,5 +185,7 @@ func AppOptions(opts ...Option) []kratos.Option {
 	if o.enableReigstry {
 		kopts = append(kopts, Registrar(opts...))
 	}
+	// 设置了 tsf_prometheus_port 时单独提供 /metrics
+	metrics.StartPrometheus()
 	return kopts
 }
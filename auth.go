@@ -2,15 +2,22 @@ package tsf
 
 import (
 	"context"
+	"fmt"
 	"sync"
 
 	"github.com/go-kratos/kratos/v2"
+	"github.com/go-kratos/kratos/v2/errors"
 	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	tsfNaming "github.com/hisonsoft/tsf-go/naming"
 	"github.com/hisonsoft/tsf-go/pkg/auth"
 	"github.com/hisonsoft/tsf-go/pkg/auth/authenticator"
-	"github.com/hisonsoft/tsf-go/pkg/config/consul"
+	"github.com/hisonsoft/tsf-go/pkg/config/source"
+	"github.com/hisonsoft/tsf-go/pkg/identity"
+	"github.com/hisonsoft/tsf-go/pkg/meta"
 	"github.com/hisonsoft/tsf-go/pkg/naming"
 	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+	"github.com/hisonsoft/tsf-go/pkg/sys/tag"
 )
 
 func authMiddleware() middleware.Middleware {
@@ -23,7 +30,7 @@ func authMiddleware() middleware.Middleware {
 				k, _ := kratos.FromContext(ctx)
 				serviceName := k.Name()
 				builder := &authenticator.Builder{}
-				authen = builder.Build(consul.DefaultConsul(), naming.NewService(env.NamespaceID(), serviceName))
+				authen = builder.Build(source.Default(), naming.NewService(env.NamespaceID(), serviceName))
 			})
 			_, operation := ServerOperation(ctx)
 			// 鉴权
@@ -35,3 +42,104 @@ func authMiddleware() middleware.Middleware {
 		}
 	}
 }
+
+// AuthPrecheckMiddleware is a client middleware checking the auth rules of
+// the remote service locally, so that the forbidden requests fail fast
+// without reaching the remote service. The requests are only denied if the
+// rules don't use the tags added by the remote service(e.g. the peer ip or
+// certificate), otherwise they are left to the remote service. It should be
+// added after the tsf client middleware:
+// tsf.ClientGrpcOptions(tsf.WithMiddlewares(tsf.AuthPrecheckMiddleware())).
+func AuthPrecheckMiddleware() middleware.Middleware {
+	var (
+		authen *authenticator.Authenticator
+		remote tsfNaming.Service
+		once   sync.Once
+	)
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
+			once.Do(func() {
+				tr, _ := transport.FromClientContext(ctx)
+				remote = remoteService(tr.Endpoint())
+				// global命名空间的服务分布在多个命名空间，无法确定使用哪份规则
+				if remote.Namespace == tsfNaming.NsGlobal {
+					return
+				}
+				builder := &authenticator.Builder{NoAudit: true}
+				authen, _ = builder.Build(source.Default(), naming.NewService(remote.Namespace, remote.Name)).(*authenticator.Authenticator)
+			})
+			if authen == nil {
+				return handler(ctx, req)
+			}
+			method, operation := ClientOperation(ctx)
+			pctx := precheckContext(ctx, remote, method, operation)
+			// http的路由模板在调用双方可能不一致(如gin与kratos)或未设置，接口相关的标签无法还原
+			tr, _ := transport.FromClientContext(ctx)
+			grpcCall := tr != nil && tr.Kind() == transport.KindGRPC && operation != ""
+			if decided, err := authen.Precheck(pctx, operation, precheckKnown(pctx, grpcCall)); decided && err != nil {
+				return nil, errors.Forbidden("AuthPrecheckDenied", fmt.Sprintf("access %s of %s denied by its auth rules", operation, remote.Name))
+			}
+			return handler(ctx, req)
+		}
+	}
+}
+
+// precheckKeys 调用方可以还原的服务端系统标签
+var precheckKeys = map[string]struct{}{
+	meta.SourceKey(meta.ServiceName):        {},
+	meta.SourceKey(meta.ServiceNamespace):   {},
+	meta.SourceKey(meta.Namespace):          {},
+	meta.SourceKey(meta.ApplicationID):      {},
+	meta.SourceKey(meta.GroupID):            {},
+	meta.SourceKey(meta.ApplicationVersion): {},
+	meta.ServiceName:                        {},
+	meta.Namespace:                          {},
+	meta.LaneID:                             {},
+	meta.IdentityTrusted:                    {},
+}
+
+// operationKeys 被调接口相关的系统标签，只有grpc调用可以还原
+var operationKeys = map[string]struct{}{
+	meta.Interface:         {},
+	meta.RequestHTTPMethod: {},
+}
+
+// precheckKnown 服务端根据连接补充的标签(来源IP、证书、服务端自身的部署组等)无法还原，
+// 用户标签可能由服务端补充(如JWT)，只有调用方携带的才可以判断
+func precheckKnown(ctx context.Context, grpcCall bool) func(t tag.Tag) bool {
+	return func(t tag.Tag) bool {
+		if t.Type == tag.TypeUser {
+			return meta.User(ctx, t.Field) != ""
+		}
+		if _, ok := operationKeys[t.Field]; ok {
+			if !grpcCall {
+				return false
+			}
+		} else if _, ok := precheckKeys[t.Field]; !ok {
+			return false
+		}
+		return meta.Sys(ctx, t.Field) != nil
+	}
+}
+
+// precheckContext 构造服务端鉴权时看到的系统标签：本服务作为来源，远端服务作为本地，
+// 用户标签保持不变
+func precheckContext(ctx context.Context, remote tsfNaming.Service, method string, operation string) context.Context {
+	serviceName, _ := meta.Sys(ctx, meta.ServiceName).(string)
+	pairs := []meta.SysPair{
+		{Key: meta.SourceKey(meta.ServiceName), Value: serviceName},
+		{Key: meta.SourceKey(meta.ServiceNamespace), Value: env.NamespaceID()},
+		{Key: meta.SourceKey(meta.Namespace), Value: env.NamespaceID()},
+		{Key: meta.SourceKey(meta.ApplicationID), Value: env.ApplicationID()},
+		{Key: meta.SourceKey(meta.GroupID), Value: env.GroupID()},
+		{Key: meta.SourceKey(meta.ApplicationVersion), Value: env.ProgVersion()},
+		{Key: meta.ServiceName, Value: remote.Name},
+		{Key: meta.Namespace, Value: remote.Namespace},
+		{Key: meta.Interface, Value: operation},
+		{Key: meta.RequestHTTPMethod, Value: method},
+	}
+	if identity.DefaultSigner() != nil {
+		pairs = append(pairs, meta.SysPair{Key: meta.IdentityTrusted, Value: "true"})
+	}
+	return meta.WithSys(ctx, pairs...)
+}
@@ -0,0 +1,115 @@
+package tsf
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/pkg/meta"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/transport"
+)
+
+func TestAuthPrecheck(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tsf-precheck")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	auditPath := filepath.Join(dir, "audit.log")
+	flag.Set("tsf_config_dir", dir)
+	flag.Set("tsf_namespace_id", "ns-test")
+	flag.Set("tsf_auth_audit_path", auditPath)
+	defer func() {
+		flag.Set("tsf_config_dir", "")
+		flag.Set("tsf_namespace_id", "")
+		flag.Set("tsf_auth_audit_path", "")
+	}()
+	writeRules := func(service string, rules string) {
+		path := filepath.Join(dir, "authority", "ns-test", service, "data.yaml")
+		os.MkdirAll(filepath.Dir(path), 0755)
+		if err := ioutil.WriteFile(path, []byte(rules), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	writeRules("whitelist", `
+- type: W
+  rules:
+  - ruleId: rule-1
+    tags:
+    - tagType: S
+      tagField: source.service.name
+      tagOperator: EQUAL
+      tagValue: other
+`)
+	writeRules("blacklist", `
+- type: B
+  rules:
+  - ruleId: rule-2
+    tags:
+    - tagType: S
+      tagField: source.service.name
+      tagOperator: EQUAL
+      tagValue: consumer
+`)
+	// 来源IP只有服务端知道，不做预检
+	writeRules("ip", `
+- type: W
+  rules:
+  - ruleId: rule-3
+    tags:
+    - tagType: S
+      tagField: source.connection.ip
+      tagOperator: EQUAL
+      tagValue: 10.0.0.8
+`)
+	// http的路由模板无法还原，只有grpc调用预检接口
+	writeRules("api", `
+- type: B
+  rules:
+  - ruleId: rule-4
+    tags:
+    - tagType: S
+      tagField: interface
+      tagOperator: EQUAL
+      tagValue: /helloworld.Greeter/SayHello
+`)
+
+	call := func(service string, kind transport.Kind) error {
+		handler := AuthPrecheckMiddleware()(func(ctx context.Context, req interface{}) (interface{}, error) {
+			return "ok", nil
+		})
+		tr := testTransport{kind: kind, endpoint: "discovery:///" + service, operation: "/helloworld.Greeter/SayHello"}
+		ctx := meta.WithSys(context.Background(), meta.SysPair{Key: meta.ServiceName, Value: "consumer"})
+		ctx = transport.NewClientContext(ctx, tr)
+		// 规则异步加载
+		var err error
+		for deadline := time.Now().Add(1500 * time.Millisecond); time.Now().Before(deadline); time.Sleep(50 * time.Millisecond) {
+			if _, err = handler(ctx, nil); err != nil {
+				break
+			}
+		}
+		return err
+	}
+	for _, service := range []string{"whitelist", "blacklist", "api"} {
+		if err := call(service, transport.KindGRPC); !errors.IsForbidden(err) || errors.Reason(err) != "AuthPrecheckDenied" {
+			t.Fatalf("%s: expect denied, got %v", service, err)
+		}
+	}
+	for _, service := range []string{"ip", "norules"} {
+		if err := call(service, transport.KindGRPC); err != nil {
+			t.Fatalf("%s: expect passed through, got %v", service, err)
+		}
+	}
+	if err := call("api", transport.KindHTTP); err != nil {
+		t.Fatalf("api over http: expect passed through, got %v", err)
+	}
+	if _, err := os.Stat(auditPath); !os.IsNotExist(err) {
+		t.Fatalf("expect no audit log written, got %v", err)
+	}
+}
@@ -2,11 +2,13 @@ package tsf
 
 import (
 	"context"
+	"sync"
 
 	"github.com/go-kratos/kratos/v2/errors"
 	"github.com/go-kratos/kratos/v2/middleware"
 	"github.com/go-kratos/kratos/v2/transport"
 	"github.com/hisonsoft/tsf-go/breaker"
+	"github.com/hisonsoft/tsf-go/pkg/sys/metrics"
 )
 
 func BreakerMiddleware(opts ...ClientOption) middleware.Middleware {
@@ -22,12 +24,16 @@ func BreakerMiddleware(opts ...ClientOption) middleware.Middleware {
 		}
 	}
 	group := breaker.NewGroup(o.breakerCfg)
+	var once sync.Once
 	return func(handler middleware.Handler) middleware.Handler {
 		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
 			if tr, ok := transport.FromClientContext(ctx); ok {
+				// 按被调方注册，重建的客户端替换旧的熔断组
+				once.Do(func() { metrics.RegisterBreakers(tr.Endpoint(), group, breaker.StateOpen) })
 				if tr.Operation() != "" {
 					breaker := group.Get(tr.Operation())
 					if err = breaker.Allow(); err != nil {
+						metrics.BreakerRejected(tr.Operation())
 						return
 					}
 					defer func() {
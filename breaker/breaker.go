@@ -146,6 +146,19 @@ func (g *Group) Get(key string) Breaker {
 	return brk
 }
 
+// States returns the state of the breakers by key
+func (g *Group) States() map[string]int32 {
+	g.mu.RLock()
+	defer g.mu.RUnlock()
+	states := make(map[string]int32, len(g.brks))
+	for key, brk := range g.brks {
+		if s, ok := brk.(interface{ State() int32 }); ok {
+			states[key] = s.State()
+		}
+	}
+	return states
+}
+
 // Reload reload the group by specified config, this may let all inner breaker
 // reset to a new one.
 func (g *Group) Reload(conf *Config) {
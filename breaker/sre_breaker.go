@@ -54,6 +54,11 @@ func (b *sreBreaker) summary() (success int64, total int64) {
 	return
 }
 
+// State returns StateOpen or StateClosed
+func (b *sreBreaker) State() int32 {
+	return atomic.LoadInt32(&b.state)
+}
+
 func (b *sreBreaker) Allow() error {
 	success, total := b.summary()
 	k := b.k * float64(success)
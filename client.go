@@ -2,14 +2,19 @@ package tsf
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"sync"
+	"time"
 
 	"github.com/hisonsoft/tsf-go/balancer"
 	"github.com/hisonsoft/tsf-go/balancer/p2c"
 	"github.com/hisonsoft/tsf-go/breaker"
 	"github.com/hisonsoft/tsf-go/grpc/balancer/multi"
+	"github.com/hisonsoft/tsf-go/log"
+	"github.com/hisonsoft/tsf-go/naming"
 	"github.com/hisonsoft/tsf-go/naming/consul"
+	"github.com/hisonsoft/tsf-go/pkg/identity"
 	"github.com/hisonsoft/tsf-go/pkg/meta"
 	"github.com/hisonsoft/tsf-go/pkg/sys/env"
 	"github.com/hisonsoft/tsf-go/route/composite"
@@ -36,6 +41,7 @@ type clientOpionts struct {
 	m                []middleware.Middleware
 	balancer         balancer.Balancer
 	enableDiscovery  bool
+	tlsConf          *tls.Config
 }
 
 func WithEnableDiscovery(enableDiscovery bool) ClientOption {
@@ -68,11 +74,25 @@ func WithBalancer(b balancer.Balancer) ClientOption {
 	}
 }
 
-func startClientContext(ctx context.Context, remoteServiceName string, l *lane.Lane, operation string) context.Context {
-	// 注入远端服务名
+// WithTLSConfig enables tls, see TLSConfig.ClientConfig in pkg/http for mTLS
+// with rotated certificate files. Only the instances registered with tls are
+// discovered.
+//
+// The discovered instances are ip addresses, so TLSConfig.ClientConfig does
+// NOT verify the server host name unless ServerName is set: any server with a
+// certificate signed by the CA is trusted. Set ServerName or ServerSANs to
+// make sure the callee is the expected service.
+func WithTLSConfig(c *tls.Config) ClientOption {
+	return func(o *clientOpionts) {
+		o.tlsConf = c
+	}
+}
+
+func startClientContext(ctx context.Context, remote naming.Service, l *lane.Lane, operation string) context.Context {
+	// 注入远端服务名及命名空间
 	pairs := []meta.SysPair{
-		{Key: meta.DestKey(meta.ServiceName), Value: remoteServiceName},
-		{Key: meta.DestKey(meta.ServiceNamespace), Value: env.NamespaceID()},
+		{Key: meta.DestKey(meta.ServiceName), Value: remote.Name},
+		{Key: meta.DestKey(meta.ServiceNamespace), Value: remote.Namespace},
 	}
 	var serviceName string
 	// 注入自己的服务名
@@ -109,22 +129,38 @@ func startClientContext(ctx context.Context, remoteServiceName string, l *lane.L
 	md.Set(meta.ServiceNamespace, env.NamespaceID())
 	md.Set(meta.ApplicationID, env.ApplicationID())
 	md.Set(meta.ApplicationVersion, env.ProgVersion())
+	if signer := identity.DefaultSigner(); signer != nil {
+		id := identity.Identity{
+			Namespace:          env.NamespaceID(),
+			ApplicationID:      env.ApplicationID(),
+			GroupID:            env.GroupID(),
+			ServiceName:        serviceName,
+			ApplicationVersion: env.ProgVersion(),
+		}
+		// 签名绑定被调服务及接口，被调方收到的签名不能用于冒充调用方访问其他服务
+		target := identity.Target{Namespace: remote.Namespace, Service: remote.Name, Operation: signedOperation(ctx, false)}
+		if sig, err := signer.Sign(id, target, time.Now()); err != nil {
+			log.DefaultLog.Errorw("msg", "sign identity failed!", "err", err)
+		} else {
+			md.Set(meta.IdentitySignature, sig)
+		}
+	}
 	return metadata.MergeToClientContext(ctx, md)
 }
 
 func clientMiddleware() middleware.Middleware {
 	router := composite.DefaultComposite()
 	lane := router.Lane()
-	var remoteServiceName string
+	var remote naming.Service
 	var once sync.Once
 	return func(handler middleware.Handler) middleware.Handler {
 		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
 			once.Do(func() {
 				tr, _ := transport.FromClientContext(ctx)
-				remoteServiceName, _ = util.ParseTarget(tr.Endpoint())
+				remote = remoteService(tr.Endpoint())
 			})
 			_, operation := ClientOperation(ctx)
-			ctx = startClientContext(ctx, remoteServiceName, lane, operation)
+			ctx = startClientContext(ctx, remote, lane, operation)
 
 			reply, err = handler(ctx, req)
 			return
@@ -132,6 +168,13 @@ func clientMiddleware() middleware.Middleware {
 	}
 }
 
+// remoteService parses the target of the client endpoint, e.g.
+// discovery:///provider, discovery:///global/provider or discovery:///<namespace id>/provider
+func remoteService(endpoint string) naming.Service {
+	target, _ := util.ParseTarget(endpoint)
+	return *naming.ParseService(target)
+}
+
 // ClientMiddleware is client middleware
 func ClientMiddleware() middleware.Middleware {
 	return middleware.Chain(clientMiddleware(), tracingClient(), clientMetricsMiddleware(), mmeta.Client())
@@ -159,6 +202,9 @@ func ClientGrpcOptions(copts ...ClientOption) []tgrpc.ClientOption {
 	if o.enableDiscovery {
 		opts = append(opts, tgrpc.WithDiscovery(consul.DefaultConsul()))
 	}
+	if o.tlsConf != nil {
+		opts = append(opts, tgrpc.WithTLSConfig(o.tlsConf))
+	}
 	return opts
 }
 
@@ -184,5 +230,8 @@ func ClientHTTPOptions(copts ...ClientOption) []http.ClientOption {
 	if o.enableDiscovery {
 		opts = append(opts, http.WithDiscovery(consul.DefaultConsul()))
 	}
+	if o.tlsConf != nil {
+		opts = append(opts, http.WithTLSConfig(o.tlsConf))
+	}
 	return opts
 }
@@ -0,0 +1,146 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"sync/atomic"
+
+	"github.com/hisonsoft/tsf-go/log"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Validator is implemented by bound structs to reject an invalid config
+type Validator interface {
+	Validate() error
+}
+
+// Binding keeps the remote config unmarshaled into a typed struct
+type Binding struct {
+	typ        reflect.Type
+	defaults   []byte
+	validators []func(v interface{}) error
+	value      atomic.Value
+
+	mu        sync.Mutex
+	last      *Config
+	applied   bool
+	callbacks []func(v interface{})
+
+	errMu sync.Mutex
+	err   error
+}
+
+// Bind binds the application config(or the global one WithGlobal) into the
+// struct type ptr points to, the value of ptr is used as defaults:
+//
+//	b, err := config.Bind(&AppConfig{Timeout: time.Second})
+//	conf := b.Load().(*AppConfig)
+//
+// Every update is unmarshaled into a new struct, a config which fails to
+// unmarshal or is rejected by Validate or WithValidator is not applied and
+// the previous value is kept. The returned error is the one of the current
+// config, the binding keeps receiving updates anyway.
+func Bind(ptr interface{}, opts ...Option) (*Binding, error) {
+	b, err := newBinding(ptr, opts...)
+	if err != nil {
+		return nil, err
+	}
+	WatchConfig(b.update, opts...)
+	return b, b.Err()
+}
+
+func newBinding(ptr interface{}, opts ...Option) (*Binding, error) {
+	rv := reflect.ValueOf(ptr)
+	if rv.Kind() != reflect.Ptr || rv.IsNil() || rv.Elem().Kind() != reflect.Struct {
+		return nil, fmt.Errorf("config bind: expect a non-nil struct pointer, got %T", ptr)
+	}
+	defaults, err := yaml.Marshal(ptr)
+	if err != nil {
+		return nil, err
+	}
+	var opt options
+	for _, o := range opts {
+		o(&opt)
+	}
+	b := &Binding{
+		typ:        rv.Elem().Type(),
+		defaults:   defaults,
+		validators: opt.validators,
+	}
+	b.value.Store(ptr)
+	return b, nil
+}
+
+// Load returns the current value, a pointer to the bound struct type which
+// must not be modified
+func (b *Binding) Load() interface{} {
+	return b.value.Load()
+}
+
+// Err returns why the latest config was rejected, nil if it was applied
+func (b *Binding) Err() error {
+	b.errMu.Lock()
+	defer b.errMu.Unlock()
+	return b.err
+}
+
+func (b *Binding) setErr(err error) {
+	b.errMu.Lock()
+	b.err = err
+	b.errMu.Unlock()
+}
+
+// OnChange registers a callback called with the current value first and
+// then with every applied update. Callbacks are called one by one in the
+// order they are registered and updates are delivered in order, a callback
+// must not call OnChange.
+func (b *Binding) OnChange(f func(v interface{})) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.callbacks = append(b.callbacks, f)
+	f(b.value.Load())
+}
+
+func (b *Binding) update(conf *Config) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.applied && conf == b.last {
+		return
+	}
+	b.last = conf
+	v, err := b.decode(conf)
+	b.setErr(err)
+	if err != nil {
+		log.DefaultLog.Errorw("msg", "[config] invalid config rejected, keep the previous one!", "type", b.typ.String(), "err", err)
+		return
+	}
+	b.applied = true
+	b.value.Store(v)
+	for _, f := range b.callbacks {
+		f(v)
+	}
+}
+
+func (b *Binding) decode(conf *Config) (interface{}, error) {
+	v := reflect.New(b.typ).Interface()
+	// 每次从默认值的副本开始解析，避免多次更新之间互相影响
+	if err := yaml.Unmarshal(b.defaults, v); err != nil {
+		return nil, err
+	}
+	if err := conf.Unmarshal(v); err != nil {
+		return nil, err
+	}
+	if validator, ok := v.(Validator); ok {
+		if err := validator.Validate(); err != nil {
+			return nil, err
+		}
+	}
+	for _, validate := range b.validators {
+		if err := validate(v); err != nil {
+			return nil, err
+		}
+	}
+	return v, nil
+}
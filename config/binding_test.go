@@ -0,0 +1,79 @@
+package config
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+type rawData []byte
+
+func (r rawData) Unmarshal(v interface{}) error {
+	return yaml.Unmarshal(r, v)
+}
+
+func (r rawData) Raw() []byte {
+	return r
+}
+
+type poolConfig struct {
+	MaxOpen int           `yaml:"maxOpen"`
+	Timeout time.Duration `yaml:"timeout"`
+	Tags    []string      `yaml:"tags"`
+}
+
+func (p *poolConfig) Validate() error {
+	if p.MaxOpen <= 0 {
+		return errors.New("maxOpen must be positive")
+	}
+	return nil
+}
+
+func TestBinding(t *testing.T) {
+	b, err := newBinding(&poolConfig{MaxOpen: 10, Timeout: time.Second}, WithValidator(func(v interface{}) error {
+		if v.(*poolConfig).MaxOpen > 100 {
+			return errors.New("maxOpen too large")
+		}
+		return nil
+	}))
+	if err != nil {
+		t.Fatalf("bind failed!err:=%v", err)
+	}
+	var got []int
+	b.OnChange(func(v interface{}) {
+		got = append(got, v.(*poolConfig).MaxOpen)
+	})
+
+	update := func(raw string) {
+		conf, _ := newTsfConfig(rawData(raw))
+		b.update(conf)
+	}
+	update("maxOpen: 20\ntags: [a]")
+	if conf := b.Load().(*poolConfig); conf.MaxOpen != 20 || conf.Timeout != time.Second || len(conf.Tags) != 1 {
+		t.Fatalf("expect config merged with defaults, got %+v", conf)
+	}
+
+	// 校验失败或格式错误的配置不生效，保留上一份配置
+	for _, raw := range []string{"maxOpen: 0", "maxOpen: 200", "maxOpen: [1"} {
+		update(raw)
+		if b.Err() == nil {
+			t.Fatalf("expect %q rejected", raw)
+		}
+		if conf := b.Load().(*poolConfig); conf.MaxOpen != 20 {
+			t.Fatalf("expect previous config kept, got %+v", conf)
+		}
+	}
+
+	update("maxOpen: 30")
+	if b.Err() != nil {
+		t.Fatalf("expect config applied, err:=%v", b.Err())
+	}
+	if conf := b.Load().(*poolConfig); conf.MaxOpen != 30 || len(conf.Tags) != 0 {
+		t.Fatalf("expect fresh copy of defaults for each update, got %+v", conf)
+	}
+	if len(got) != 3 || got[0] != 10 || got[1] != 20 || got[2] != 30 {
+		t.Fatalf("expect callbacks in order, got %v", got)
+	}
+}
@@ -13,12 +13,14 @@ var _ config.Config = &Config{}
 type Config struct {
 	v map[string]interface{}
 	config.Data
+	// prov 合并配置中每个key的来源层
+	prov map[string]string
 }
 
-func newTsfConfig(d config.Data) *Config {
+func newTsfConfig(d config.Data) (*Config, error) {
 	c := &Config{v: map[string]interface{}{}, Data: d}
-	c.refill()
-	return c
+	err := c.refill()
+	return c, err
 }
 
 func (c *Config) Get(key string) (v interface{}, ok bool) {
@@ -28,7 +30,7 @@ func (c *Config) Get(key string) (v interface{}, ok bool) {
 func (c *Config) GetString(key string) (v string, ok bool) {
 	res, ok := c.get(key)
 	if ok {
-		v, ok = res.(string)
+		v, ok = toString(res)
 	}
 	return
 }
@@ -36,7 +38,7 @@ func (c *Config) GetString(key string) (v string, ok bool) {
 func (c *Config) GetBool(key string) (v bool, ok bool) {
 	res, ok := c.get(key)
 	if ok {
-		v, ok = res.(bool)
+		v, ok = toBool(res)
 	}
 	return
 }
@@ -44,7 +46,7 @@ func (c *Config) GetBool(key string) (v bool, ok bool) {
 func (c *Config) GetInt(key string) (v int64, ok bool) {
 	res, ok := c.get(key)
 	if ok {
-		v, ok = res.(int64)
+		v, ok = toInt64(res)
 	}
 	return
 }
@@ -52,7 +54,7 @@ func (c *Config) GetInt(key string) (v int64, ok bool) {
 func (c *Config) GetFloat(key string) (v float64, ok bool) {
 	res, ok := c.get(key)
 	if ok {
-		v, ok = res.(float64)
+		v, ok = toFloat64(res)
 	}
 	return
 }
@@ -60,7 +62,7 @@ func (c *Config) GetFloat(key string) (v float64, ok bool) {
 func (c *Config) GetDuration(key string) (v time.Duration, ok bool) {
 	res, ok := c.get(key)
 	if ok {
-		v, ok = res.(time.Duration)
+		v, ok = toDuration(res)
 	}
 	return
 }
@@ -68,17 +70,28 @@ func (c *Config) GetDuration(key string) (v time.Duration, ok bool) {
 func (c *Config) GetTime(key string) (v time.Time, ok bool) {
 	res, ok := c.get(key)
 	if ok {
-		v, ok = res.(time.Time)
+		v, ok = toTime(res)
 	}
 	return
 }
 
+// get 支持点号分隔的路径及数组下标，例如 db.pool.maxOpen、servers[0].host，
+// 完整匹配的顶层key优先
 func (c *Config) get(key string) (res interface{}, ok bool) {
 	if c == nil {
 		return
 	}
-	res, ok = c.v[key]
-	return
+	if res, ok = c.v[key]; ok {
+		return
+	}
+	return lookup(c.v, key)
+}
+
+func (c *Config) values() map[string]interface{} {
+	if c == nil {
+		return nil
+	}
+	return c.v
 }
 
 func (c *Config) Unmarshal(v interface{}) error {
@@ -95,9 +108,14 @@ func (c *Config) Raw() []byte {
 	return c.Data.Raw()
 }
 
-func (c *Config) refill() {
+func (c *Config) refill() error {
 	err := c.Data.Unmarshal(c.v)
+	if err == nil {
+		err = c.decrypt()
+	}
 	if err != nil {
-		log.DefaultLog.Errorw("msg", "config refill failed!", "err", err, "raw", string(c.Raw()))
+		// 配置中可能包含密钥，不打印原始内容
+		log.DefaultLog.Errorw("msg", "config refill failed!", "err", err)
 	}
+	return err
 }
@@ -0,0 +1,119 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+const yamlPayload = `
+name: demo
+debug: "true"
+a.b: dotted
+db:
+  pool:
+    maxOpen: 20
+    ratio: 0.5
+    timeout: 5s
+servers:
+  - host: 10.0.0.1
+    port: 8080
+  - host: 10.0.0.2
+    port: "8081"
+released: 2021-01-02T15:04:05Z
+`
+
+const jsonPayload = `{
+  "name": "demo",
+  "debug": "true",
+  "a.b": "dotted",
+  "db": {"pool": {"maxOpen": 20, "ratio": 0.5, "timeout": "5s"}},
+  "servers": [{"host": "10.0.0.1", "port": 8080}, {"host": "10.0.0.2", "port": "8081"}],
+  "released": "2021-01-02T15:04:05Z"
+}`
+
+func TestGetters(t *testing.T) {
+	released := time.Date(2021, 1, 2, 15, 4, 5, 0, time.UTC)
+	for name, payload := range map[string]string{"yaml": yamlPayload, "json": jsonPayload} {
+		c, err := newTsfConfig(rawData(payload))
+		if err != nil {
+			t.Fatalf("%s: parse failed!err:=%v", name, err)
+		}
+		if v, ok := c.GetString("name"); !ok || v != "demo" {
+			t.Fatalf("%s: unexpected name %v", name, v)
+		}
+		if v, ok := c.GetString("a.b"); !ok || v != "dotted" {
+			t.Fatalf("%s: expect top-level dotted key preferred, got %v", name, v)
+		}
+		if v, ok := c.GetBool("debug"); !ok || !v {
+			t.Fatalf("%s: expect string bool coerced, got %v %v", name, v, ok)
+		}
+		if v, ok := c.GetInt("db.pool.maxOpen"); !ok || v != 20 {
+			t.Fatalf("%s: unexpected maxOpen %v %v", name, v, ok)
+		}
+		if v, ok := c.GetFloat("db.pool.ratio"); !ok || v != 0.5 {
+			t.Fatalf("%s: unexpected ratio %v %v", name, v, ok)
+		}
+		if v, ok := c.GetFloat("db.pool.maxOpen"); !ok || v != 20 {
+			t.Fatalf("%s: expect int coerced to float, got %v %v", name, v, ok)
+		}
+		if v, ok := c.GetDuration("db.pool.timeout"); !ok || v != 5*time.Second {
+			t.Fatalf("%s: unexpected timeout %v %v", name, v, ok)
+		}
+		if v, ok := c.GetString("servers[1].host"); !ok || v != "10.0.0.2" {
+			t.Fatalf("%s: unexpected host %v %v", name, v, ok)
+		}
+		if v, ok := c.GetInt("servers.1.port"); !ok || v != 8081 {
+			t.Fatalf("%s: expect string port coerced, got %v %v", name, v, ok)
+		}
+		if v, ok := c.GetString("servers[0].port"); !ok || v != "8080" {
+			t.Fatalf("%s: expect int port as string, got %v %v", name, v, ok)
+		}
+		if v, ok := c.GetTime("released"); !ok || !v.Equal(released) {
+			t.Fatalf("%s: unexpected released %v %v", name, v, ok)
+		}
+		for _, key := range []string{"db.pool.missing", "servers[2].host", "servers[x]", "name.sub", "servers[0"} {
+			if v, ok := c.Get(key); ok {
+				t.Fatalf("%s: expect %s not found, got %v", name, key, v)
+			}
+		}
+		if _, ok := c.GetInt("name"); ok {
+			t.Fatalf("%s: expect non numeric string not coerced to int", name)
+		}
+	}
+	// 超出int64范围的数值不能转换
+	for _, v := range []interface{}{float64(1 << 63), 1e19, "9223372036854775808", uint64(1 << 63)} {
+		if i, ok := toInt64(v); ok {
+			t.Fatalf("%v: expect overflow, got %d", v, i)
+		}
+	}
+	if i, ok := toInt64(float64(-1 << 63)); !ok || i != -1<<63 {
+		t.Fatalf("expect min int64, got %d %v", i, ok)
+	}
+}
+
+func TestKeyWatcher(t *testing.T) {
+	type change struct{ old, new interface{} }
+	var changes []change
+	f := keyWatcher("log.level", func(old, new interface{}) {
+		changes = append(changes, change{old, new})
+	})
+	for _, raw := range []string{
+		"log: {level: info}",
+		"log: {level: info, path: /tmp}\npool: {maxOpen: 10}",
+		"log: {level: debug}",
+		"pool: {maxOpen: 10}",
+	} {
+		c, err := newTsfConfig(rawData(raw))
+		if err != nil {
+			t.Fatal(err)
+		}
+		f(c)
+	}
+	// 其他key的变化不回调
+	f(nil)
+	expect := []change{{nil, "info"}, {"info", "debug"}, {"debug", nil}}
+	if !reflect.DeepEqual(changes, expect) {
+		t.Fatalf("expect %v, got %v", expect, changes)
+	}
+}
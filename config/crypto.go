@@ -0,0 +1,205 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/hisonsoft/tsf-go/pkg/config"
+	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	encPrefix = "ENC("
+	encSuffix = ")"
+)
+
+var (
+	cryptoMu      sync.Mutex
+	decryptor     Decryptor
+	decryptorInit bool
+)
+
+// Decryptor decrypts the config values marked as ENC(ciphertext)
+type Decryptor interface {
+	Decrypt(ciphertext string) (plaintext string, err error)
+}
+
+// SetDecryptor replaces the default AES-GCM decryptor, it should be called
+// before the config is loaded
+func SetDecryptor(d Decryptor) {
+	cryptoMu.Lock()
+	defer cryptoMu.Unlock()
+	decryptor = d
+	decryptorInit = true
+}
+
+// getDecryptor 默认使用 tsf_config_key_file 或 tsf_config_key 中的AES密钥
+func getDecryptor() (Decryptor, error) {
+	cryptoMu.Lock()
+	defer cryptoMu.Unlock()
+	if decryptorInit {
+		return decryptor, nil
+	}
+	key := env.ConfigKey()
+	if path := env.ConfigKeyFile(); path != "" {
+		b, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key = string(b)
+	}
+	if key != "" {
+		d, err := NewAESDecryptor(key)
+		if err != nil {
+			return nil, err
+		}
+		decryptor = d
+	}
+	decryptorInit = true
+	return decryptor, nil
+}
+
+// AESDecryptor decrypts base64(nonce + AES-GCM sealed data)
+type AESDecryptor struct {
+	aead cipher.AEAD
+}
+
+// NewAESDecryptor creates a decryptor from a base64 encoded 16, 24 or 32
+// bytes key
+func NewAESDecryptor(key string) (*AESDecryptor, error) {
+	k, err := base64.StdEncoding.DecodeString(strings.TrimSpace(key))
+	if err != nil {
+		return nil, fmt.Errorf("config key must be base64 encoded: %v", err)
+	}
+	block, err := aes.NewCipher(k)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	return &AESDecryptor{aead: aead}, nil
+}
+
+func (d *AESDecryptor) Decrypt(ciphertext string) (string, error) {
+	b, err := base64.StdEncoding.DecodeString(ciphertext)
+	if err != nil {
+		return "", err
+	}
+	size := d.aead.NonceSize()
+	if len(b) < size {
+		return "", errors.New("ciphertext too short")
+	}
+	plain, err := d.aead.Open(nil, b[:size], b[size:], nil)
+	if err != nil {
+		return "", err
+	}
+	return string(plain), nil
+}
+
+// Encrypt returns the ciphertext of plaintext to be put in ENC(...)
+func (d *AESDecryptor) Encrypt(plaintext string) (string, error) {
+	nonce := make([]byte, d.aead.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(d.aead.Seal(nonce, nonce, []byte(plaintext), nil)), nil
+}
+
+// decryptValues 原地解密所有ENC(...)的值，错误信息只包含key不包含值
+func decryptValues(v interface{}, path string, d Decryptor) (res interface{}, changed bool, err error) {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for k, sub := range node {
+			var c bool
+			if node[k], c, err = decryptValues(sub, joinPath(path, k), d); err != nil {
+				return
+			}
+			changed = changed || c
+		}
+	case map[interface{}]interface{}:
+		for k, sub := range node {
+			var c bool
+			if node[k], c, err = decryptValues(sub, joinPath(path, fmt.Sprint(k)), d); err != nil {
+				return
+			}
+			changed = changed || c
+		}
+	case []interface{}:
+		for i, sub := range node {
+			var c bool
+			if node[i], c, err = decryptValues(sub, fmt.Sprintf("%s[%d]", path, i), d); err != nil {
+				return
+			}
+			changed = changed || c
+		}
+	case string:
+		if !strings.HasPrefix(node, encPrefix) || !strings.HasSuffix(node, encSuffix) {
+			return v, false, nil
+		}
+		if d == nil {
+			return v, false, fmt.Errorf("config %s is encrypted but no decryptor is configured", path)
+		}
+		plain, err := d.Decrypt(node[len(encPrefix) : len(node)-len(encSuffix)])
+		if err != nil {
+			return v, false, fmt.Errorf("config %s decrypt failed: %v", path, err)
+		}
+		return plain, true, nil
+	}
+	return v, changed, nil
+}
+
+func decryptMap(m map[string]interface{}) error {
+	d, err := getDecryptor()
+	if err != nil {
+		return err
+	}
+	_, _, err = decryptValues(m, "", d)
+	return err
+}
+
+// decrypt 解密配置中的值，存在加密值时Unmarshal使用解密后的数据，Raw保持原样
+func (c *Config) decrypt() error {
+	d, err := getDecryptor()
+	if err != nil {
+		return err
+	}
+	_, changed, err := decryptValues(c.v, "", d)
+	if err != nil || !changed {
+		return err
+	}
+	b, err := yaml.Marshal(c.v)
+	if err != nil {
+		return err
+	}
+	c.Data = decrypted{Data: c.Data, plain: yamlData(b)}
+	return nil
+}
+
+type decrypted struct {
+	config.Data
+	plain yamlData
+}
+
+// redact 隐去yaml类型错误中的值，例如 cannot unmarshal !!str `xxx` into int
+var redact = regexp.MustCompile("`[^`]*`")
+
+func (d decrypted) Unmarshal(v interface{}) error {
+	err := d.plain.Unmarshal(v)
+	if err != nil {
+		err = errors.New(redact.ReplaceAllString(err.Error(), "`***`"))
+	}
+	return err
+}
@@ -0,0 +1,63 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestDecrypt(t *testing.T) {
+	key := make([]byte, 32)
+	rand.Read(key)
+	d, err := NewAESDecryptor(base64.StdEncoding.EncodeToString(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	password, _ := d.Encrypt("s3cret")
+	token, _ := d.Encrypt("t0ken")
+	payload := fmt.Sprintf("db:\n  user: root\n  password: ENC(%s)\ntokens: [ENC(%s)]\n", password, token)
+
+	SetDecryptor(nil)
+	if _, err = newTsfConfig(rawData(payload)); err == nil {
+		t.Fatalf("expect error without decryptor")
+	}
+	SetDecryptor(d)
+	defer SetDecryptor(nil)
+	c, err := newTsfConfig(rawData(payload))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, _ := c.GetString("db.password"); v != "s3cret" {
+		t.Fatalf("unexpected password %q", v)
+	}
+	if v, _ := c.GetString("tokens[0]"); v != "t0ken" {
+		t.Fatalf("unexpected token %q", v)
+	}
+	var out struct {
+		DB struct {
+			User     string `yaml:"user"`
+			Password string `yaml:"password"`
+		} `yaml:"db"`
+	}
+	if err = c.Unmarshal(&out); err != nil || out.DB.User != "root" || out.DB.Password != "s3cret" {
+		t.Fatalf("unexpected unmarshal result %+v %v", out, err)
+	}
+	if !strings.Contains(string(c.Raw()), "ENC(") {
+		t.Fatalf("expect raw payload kept encrypted")
+	}
+	// 错误信息中不包含解密后的值
+	var bad struct {
+		DB struct {
+			Password int `yaml:"password"`
+		} `yaml:"db"`
+	}
+	if err = c.Unmarshal(&bad); err == nil || strings.Contains(err.Error(), "s3cret") {
+		t.Fatalf("expect redacted error, got %v", err)
+	}
+
+	if _, err = newTsfConfig(rawData("password: ENC(" + password[:10] + ")")); err == nil || strings.Contains(err.Error(), password[:10]) {
+		t.Fatalf("expect decrypt error without the ciphertext, got %v", err)
+	}
+}
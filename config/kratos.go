@@ -0,0 +1,109 @@
+package config
+
+import (
+	"context"
+
+	kconfig "github.com/go-kratos/kratos/v2/config"
+	"github.com/hisonsoft/tsf-go/pkg/config"
+	"github.com/hisonsoft/tsf-go/pkg/config/source"
+	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+
+	"gopkg.in/yaml.v3"
+)
+
+var _ kconfig.Source = &kratosSource{}
+
+type kratosSource struct {
+	src config.Source
+	key string
+
+	// watcher 由Load创建，交给第一次Watch继续使用
+	watcher config.Watcher
+}
+
+// NewSource returns a kratos config source of the application config(or the
+// global one WithGlobal), so that it can be merged with other sources:
+//
+//	c := kconfig.New(kconfig.WithSource(file.NewSource(path), config.NewSource()))
+//
+// The payload is converted to yaml whatever its format is and the encrypted
+// values are decrypted.
+func NewSource(opts ...Option) kconfig.Source {
+	var opt options
+	for _, o := range opts {
+		o(&opt)
+	}
+	key := appKey()
+	if opt.isGlobal {
+		key = globalKey()
+	}
+	return newKratosSource(source.Default(), key)
+}
+
+func newKratosSource(src config.Source, key string) *kratosSource {
+	return &kratosSource{src: src, key: key}
+}
+
+func (s *kratosSource) Load() ([]*kconfig.KeyValue, error) {
+	if s.watcher != nil {
+		s.watcher.Close()
+	}
+	s.watcher = s.src.Subscribe(s.key)
+	ctx, cancel := context.WithTimeout(context.Background(), env.ConfigTimeout())
+	defer cancel()
+	specs, err := s.watcher.Watch(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return toKeyValues(specs)
+}
+
+func (s *kratosSource) Watch() (kconfig.Watcher, error) {
+	w := &kratosWatcher{watcher: s.watcher}
+	if w.watcher == nil {
+		w.watcher = s.src.Subscribe(s.key)
+	}
+	s.watcher = nil
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	return w, nil
+}
+
+type kratosWatcher struct {
+	watcher config.Watcher
+	ctx     context.Context
+	cancel  context.CancelFunc
+}
+
+func (w *kratosWatcher) Next() ([]*kconfig.KeyValue, error) {
+	specs, err := w.watcher.Watch(w.ctx)
+	if err != nil {
+		// kratos 收到 context.Canceled 时停止监听，否则会一直重试
+		if w.ctx.Err() != nil {
+			return nil, w.ctx.Err()
+		}
+		return nil, err
+	}
+	return toKeyValues(specs)
+}
+
+func (w *kratosWatcher) Stop() error {
+	w.cancel()
+	w.watcher.Close()
+	return nil
+}
+
+func toKeyValues(specs []config.Spec) ([]*kconfig.KeyValue, error) {
+	kvs := make([]*kconfig.KeyValue, 0, len(specs))
+	for _, spec := range specs {
+		c, err := newTsfConfig(spec.Data)
+		if err != nil {
+			return nil, err
+		}
+		b, err := yaml.Marshal(c.v)
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, &kconfig.KeyValue{Key: spec.Key, Value: b, Format: "yaml"})
+	}
+	return kvs, nil
+}
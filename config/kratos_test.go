@@ -0,0 +1,73 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	kconfig "github.com/go-kratos/kratos/v2/config"
+	"github.com/hisonsoft/tsf-go/pkg/config/file"
+)
+
+func TestKratosSource(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tsf-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	key := "config/application/app-1/group-1/data"
+	path := filepath.Join(dir, filepath.FromSlash(key)+".properties")
+	os.MkdirAll(filepath.Dir(path), 0755)
+	if err = ioutil.WriteFile(path, []byte("db.maxOpen=10\ndb.host=10.0.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	src := file.New(&file.Config{Dir: dir, Interval: 10 * time.Millisecond})
+	// 配置已被其它订阅者(如config.Init)加载过时Load不能阻塞
+	first := src.Subscribe(key)
+	defer first.Close()
+	if _, err = first.Watch(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	c := kconfig.New(kconfig.WithSource(newKratosSource(src, key)))
+	defer c.Close()
+	done := make(chan error, 1)
+	go func() { done <- c.Load() }()
+	select {
+	case err = <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("Load blocked on a key subscribed before")
+	}
+	var db struct {
+		MaxOpen int    `json:"maxOpen"`
+		Host    string `json:"host"`
+	}
+	if err = c.Value("db").Scan(&db); err != nil || db.MaxOpen != 10 || db.Host != "10.0.0.1" {
+		t.Fatalf("unexpected db config %+v %v", db, err)
+	}
+
+	changed := make(chan int64, 1)
+	if err = c.Watch("db.maxOpen", func(key string, v kconfig.Value) {
+		n, _ := v.Int()
+		changed <- n
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(path, []byte("db.maxOpen=20\ndb.host=10.0.0.1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case n := <-changed:
+		if n != 20 {
+			t.Fatalf("expect maxOpen 20, got %d", n)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("wait config change timeout")
+	}
+}
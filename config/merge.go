@@ -0,0 +1,349 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/hisonsoft/tsf-go/log"
+	"github.com/hisonsoft/tsf-go/pkg/config"
+	"github.com/hisonsoft/tsf-go/pkg/config/file"
+	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+
+	"gopkg.in/yaml.v3"
+)
+
+// layers of the merged config, from the lowest precedence to the highest
+const (
+	LayerDefault = "default"
+	LayerGlobal  = "global"
+	LayerApp     = "application"
+	LayerFile    = "file"
+	LayerEnv     = "env"
+)
+
+// envPrefix 环境变量覆盖的前缀，双下划线表示层级，例如 tsf_override_db__pool__maxOpen=20
+const envPrefix = "tsf_override_"
+
+var (
+	// mergeMu 保证合并按顺序执行，回调在锁外执行，回调中可以调用SetDefaults
+	mergeMu    sync.Mutex
+	merged     *Config
+	mergedFunc []*mergedWatcher
+	// notifying 是否有goroutine正在执行回调，同一时间只有一个goroutine按顺序回调
+	notifying bool
+
+	// guarded by mu
+	defaults map[string]interface{}
+	local    map[string]interface{}
+	envs     map[string]interface{}
+)
+
+// mergedWatcher 记录已推送的合并结果，只由正在回调的goroutine修改
+type mergedWatcher struct {
+	f    func(conf *Config)
+	last *Config
+}
+
+type layer struct {
+	name string
+	v    map[string]interface{}
+}
+
+type yamlData []byte
+
+func (d yamlData) Unmarshal(v interface{}) error {
+	return yaml.Unmarshal(d, v)
+}
+
+func (d yamlData) Raw() []byte {
+	return d
+}
+
+// SetDefaults sets the lowest layer of the merged config, v is a map or a
+// struct which is marshaled by yaml
+func SetDefaults(v interface{}) error {
+	m, err := toMap(v)
+	if err != nil {
+		return err
+	}
+	mu.Lock()
+	defaults = m
+	mu.Unlock()
+	remerge()
+	return nil
+}
+
+// Provenance returns the layer the value of key comes from in the merged config
+func Provenance(key string) (layer string, ok bool) {
+	return getCfg(WithMerged(true)).Provenance(key)
+}
+
+// Provenance returns the layer the value of key comes from, only available
+// for the merged config. The layer of a map is the highest one contributing to it.
+func (c *Config) Provenance(key string) (layer string, ok bool) {
+	if c == nil || c.prov == nil {
+		return
+	}
+	if _, ok = c.get(key); !ok {
+		return
+	}
+	if layer, ok = c.prov[key]; ok {
+		return
+	}
+	// 数组整体覆盖，数组元素的来源即数组的来源
+	segs, _ := parsePath(key)
+	for n := len(segs); n > 0; n-- {
+		if layer, ok = c.prov[strings.Join(segs[:n], ".")]; ok {
+			return
+		}
+	}
+	return "", false
+}
+
+// initLayers 加载本地配置文件及环境变量覆盖
+func initLayers() {
+	envs = envOverrides(os.Environ())
+	if err := decryptMap(envs); err != nil {
+		log.DefaultLog.Errorw("msg", "env config decrypt failed!", "err", err)
+		envs = nil
+	}
+	path := env.ConfigFile()
+	if path == "" {
+		return
+	}
+	watcher := file.New(&file.Config{Dir: filepath.Dir(path)}).Subscribe(filepath.Base(path))
+	specs, err := watcher.Watch(context.Background())
+	if err != nil {
+		log.DefaultLog.Errorw("msg", "local config watch failed!", "path", path, "err", err)
+		return
+	}
+	local, _ = specMap(specs)
+	go refreshLocal(watcher)
+}
+
+func refreshLocal(watcher config.Watcher) {
+	ctx := context.Background()
+	for {
+		specs, err := watcher.Watch(ctx)
+		if err != nil {
+			log.DefaultLog.Errorw("msg", "refresh local config Watch failed!", "err", err)
+			return
+		}
+		m, err := specMap(specs)
+		if err != nil {
+			// 格式错误的配置不生效，继续使用上一份配置
+			continue
+		}
+		mu.Lock()
+		local = m
+		mu.Unlock()
+		remerge()
+	}
+}
+
+func specMap(specs []config.Spec) (map[string]interface{}, error) {
+	m := map[string]interface{}{}
+	if len(specs) == 0 {
+		return m, nil
+	}
+	err := specs[0].Data.Unmarshal(&m)
+	if err == nil {
+		err = decryptMap(m)
+	}
+	if err != nil {
+		log.DefaultLog.Errorw("msg", "local config unmarshal failed!", "key", specs[0].Key, "err", err)
+		return nil, err
+	}
+	return m, nil
+}
+
+// remerge 重新合并所有层，合并结果变化时按注册顺序回调
+func remerge() {
+	mergeMu.Lock()
+	mu.RLock()
+	layers := []layer{{LayerDefault, defaults}, {LayerGlobal, global.values()}, {LayerApp, app.values()}, {LayerFile, local}, {LayerEnv, envs}}
+	mu.RUnlock()
+	conf, err := mergeLayers(layers)
+	if err != nil {
+		mergeMu.Unlock()
+		log.DefaultLog.Errorw("msg", "config merge failed!", "err", err)
+		return
+	}
+	if merged != nil && reflect.DeepEqual(merged.v, conf.v) {
+		mergeMu.Unlock()
+		return
+	}
+	mu.Lock()
+	merged = conf
+	mu.Unlock()
+	notifyMerged()
+}
+
+func watchMerged(f func(conf *Config)) {
+	mergeMu.Lock()
+	mergedFunc = append(mergedFunc, &mergedWatcher{f: f})
+	notifyMerged()
+}
+
+// notifyMerged 将最新的合并结果按注册顺序推送给还未收到的回调，调用时需持有mergeMu，
+// 返回前释放。已有goroutine在回调时由它继续推送，保证同一回调按顺序收到配置
+func notifyMerged() {
+	if notifying {
+		mergeMu.Unlock()
+		return
+	}
+	notifying = true
+	for {
+		conf := merged
+		var pending []*mergedWatcher
+		if conf != nil {
+			for _, w := range mergedFunc {
+				if w.last != conf {
+					pending = append(pending, w)
+				}
+			}
+		}
+		if len(pending) == 0 {
+			notifying = false
+			mergeMu.Unlock()
+			return
+		}
+		mergeMu.Unlock()
+		for _, w := range pending {
+			w.f(conf)
+			w.last = conf
+		}
+		mergeMu.Lock()
+	}
+}
+
+// mergeLayers 按顺序深度合并，map逐key合并，其他类型(包括数组)整体覆盖
+func mergeLayers(layers []layer) (*Config, error) {
+	v := map[string]interface{}{}
+	prov := map[string]string{}
+	for _, l := range layers {
+		mergeMap(v, l.v, "", l.name, prov)
+	}
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	return &Config{v: v, Data: yamlData(b), prov: prov}, nil
+}
+
+func mergeMap(dst, src map[string]interface{}, prefix, layer string, prov map[string]string) {
+	for k, sv := range src {
+		path := joinPath(prefix, k)
+		sv = normalize(sv)
+		sm, ok := sv.(map[string]interface{})
+		if dm, isMap := dst[k].(map[string]interface{}); ok && isMap {
+			prov[path] = layer
+			mergeMap(dm, sm, path, layer, prov)
+			continue
+		}
+		if _, exists := dst[k]; exists {
+			for p := range prov {
+				if strings.HasPrefix(p, path+".") {
+					delete(prov, p)
+				}
+			}
+		}
+		if ok {
+			m := map[string]interface{}{}
+			prov[path] = layer
+			mergeMap(m, sm, path, layer, prov)
+			sv = m
+		}
+		dst[k] = sv
+		prov[path] = layer
+	}
+}
+
+// normalize 将yaml解析出的非字符串key的map转换为字符串key
+func normalize(v interface{}) interface{} {
+	switch node := v.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(node))
+		for k, v := range node {
+			m[fmt.Sprint(k)] = v
+		}
+		return m
+	case []interface{}:
+		s := make([]interface{}, len(node))
+		for i, v := range node {
+			s[i] = normalize(v)
+			if m, ok := s[i].(map[string]interface{}); ok {
+				s[i] = copyMap(m)
+			}
+		}
+		return s
+	}
+	return v
+}
+
+func copyMap(src map[string]interface{}) map[string]interface{} {
+	m := make(map[string]interface{}, len(src))
+	for k, v := range src {
+		v = normalize(v)
+		if sm, ok := v.(map[string]interface{}); ok {
+			v = copyMap(sm)
+		}
+		m[k] = v
+	}
+	return m
+}
+
+func joinPath(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
+
+// envOverrides 解析 tsf_override_ 前缀的环境变量，值按yaml解析，例如数字、布尔值及[a, b]
+func envOverrides(environ []string) map[string]interface{} {
+	m := map[string]interface{}{}
+	for _, kv := range environ {
+		if !strings.HasPrefix(kv, envPrefix) {
+			continue
+		}
+		i := strings.IndexByte(kv, '=')
+		if i < 0 {
+			continue
+		}
+		keys := strings.Split(kv[len(envPrefix):i], "__")
+		var v interface{}
+		if err := yaml.Unmarshal([]byte(kv[i+1:]), &v); err != nil || v == nil {
+			v = kv[i+1:]
+		}
+		node := m
+		for _, k := range keys[:len(keys)-1] {
+			next, ok := node[k].(map[string]interface{})
+			if !ok {
+				next = map[string]interface{}{}
+				node[k] = next
+			}
+			node = next
+		}
+		node[keys[len(keys)-1]] = v
+	}
+	return m
+}
+
+func toMap(v interface{}) (map[string]interface{}, error) {
+	if m, ok := v.(map[string]interface{}); ok {
+		return copyMap(m), nil
+	}
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]interface{}{}
+	err = yaml.Unmarshal(b, &m)
+	return m, err
+}
@@ -0,0 +1,188 @@
+package config
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/pkg/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestMergeLayers(t *testing.T) {
+	parse := func(s string) map[string]interface{} {
+		m := map[string]interface{}{}
+		if err := yaml.Unmarshal([]byte(s), &m); err != nil {
+			t.Fatal(err)
+		}
+		return m
+	}
+	defaults, err := toMap(struct {
+		Log  map[string]string `yaml:"log"`
+		Pool map[string]int    `yaml:"pool"`
+	}{Log: map[string]string{"level": "info"}, Pool: map[string]int{"maxOpen": 10, "maxIdle": 2}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	global := parse("log: {level: warn, path: /var/log}\nhosts: [a, b]")
+	app := parse("pool: {maxOpen: 20}\nhosts: [c]\nfeature: {beta: true}")
+	local := parse("feature: false")
+	envs := envOverrides([]string{"tsf_override_pool__maxIdle=5", "tsf_other=1"})
+
+	conf, err := mergeLayers([]layer{{LayerDefault, defaults}, {LayerGlobal, global}, {LayerApp, app}, {LayerFile, local}, {LayerEnv, envs}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for key, expect := range map[string]struct {
+		value interface{}
+		layer string
+	}{
+		"log.level":    {"warn", LayerGlobal},
+		"log.path":     {"/var/log", LayerGlobal},
+		"pool.maxOpen": {20, LayerApp},
+		"pool.maxIdle": {5, LayerEnv},
+		"pool":         {nil, LayerEnv},
+		"hosts[0]":     {"c", LayerApp},
+		"feature":      {false, LayerFile},
+	} {
+		v, ok := conf.Get(key)
+		if !ok || (expect.value != nil && v != expect.value) {
+			t.Fatalf("%s: expect %v, got %v", key, expect.value, v)
+		}
+		if layer, _ := conf.Provenance(key); layer != expect.layer {
+			t.Fatalf("%s: expect from %s, got %s", key, expect.layer, layer)
+		}
+	}
+	// 被标量覆盖的map不再保留子key
+	if _, ok := conf.Provenance("feature.beta"); ok {
+		t.Fatalf("expect feature.beta removed")
+	}
+	var out struct {
+		Pool struct {
+			MaxOpen int `yaml:"maxOpen"`
+		} `yaml:"pool"`
+	}
+	if err = conf.Unmarshal(&out); err != nil || out.Pool.MaxOpen != 20 {
+		t.Fatalf("unexpected unmarshal result %+v %v", out, err)
+	}
+	// 各层的原始数据不被修改
+	if app["hosts"].([]interface{})[0] != "c" || global["log"].(map[string]interface{})["level"] != "warn" || len(app["pool"].(map[string]interface{})) != 1 {
+		t.Fatalf("layers modified")
+	}
+}
+
+func TestRemergeReentrant(t *testing.T) {
+	defer func() {
+		mergeMu.Lock()
+		merged, mergedFunc = nil, nil
+		mergeMu.Unlock()
+		mu.Lock()
+		defaults = nil
+		mu.Unlock()
+	}()
+	var levels []interface{}
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// 回调中修改默认值不会死锁，且最后一次回调为最新的配置
+		watchMerged(func(conf *Config) {
+			level, _ := conf.Get("log.level")
+			levels = append(levels, level)
+			if level == "info" {
+				SetDefaults(map[string]interface{}{"log": map[string]interface{}{"level": "debug"}})
+			}
+		})
+		SetDefaults(map[string]interface{}{"log": map[string]interface{}{"level": "info"}})
+	}()
+	select {
+	case <-done:
+	case <-time.After(time.Second * 5):
+		t.Fatal("SetDefaults in the merged config callback deadlocked")
+	}
+	if len(levels) != 2 || levels[0] != "info" || levels[1] != "debug" {
+		t.Fatalf("expect callbacks in order, got %v", levels)
+	}
+}
+
+// chanWatcher 依次返回specs中的配置，关闭后返回错误
+type chanWatcher struct {
+	specs chan []config.Spec
+}
+
+func (w *chanWatcher) Watch(ctx context.Context) ([]config.Spec, error) {
+	specs, ok := <-w.specs
+	if !ok {
+		return nil, errors.New("closed")
+	}
+	return specs, nil
+}
+
+func (w *chanWatcher) Close() {}
+
+func TestWatchMergedOrder(t *testing.T) {
+	defer func() {
+		mergeMu.Lock()
+		merged, mergedFunc = nil, nil
+		mergeMu.Unlock()
+		mu.Lock()
+		app = nil
+		mu.Unlock()
+	}()
+	// 跳过从配置源加载
+	once.Do(func() {})
+	dir, err := ioutil.TempDir("", "tsf-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	flag.Set("tsf_config_snapshot_dir", dir)
+	defer flag.Set("tsf_config_snapshot_dir", "")
+	const versions = 50
+	w := &chanWatcher{specs: make(chan []config.Spec)}
+	refreshed := make(chan struct{})
+	go func() {
+		defer close(refreshed)
+		var degraded int32
+		refresh(w, "app", &app, &appFunc, &degraded)
+	}()
+
+	var wg sync.WaitGroup
+	results := make([][]int, 8)
+	for i := range results {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			WatchConfig(func(conf *Config) {
+				v, _ := conf.GetInt("version")
+				results[i] = append(results[i], int(v))
+			}, WithMerged(true))
+		}(i)
+	}
+	for v := 1; v <= versions; v++ {
+		w.specs <- []config.Spec{{Key: "app", Data: rawData(fmt.Sprintf("version: %d", v))}}
+	}
+	close(w.specs)
+	<-refreshed
+	wg.Wait()
+
+	mergeMu.Lock()
+	defer mergeMu.Unlock()
+	for i, res := range results {
+		// 每个回调按顺序收到配置，最后一次为最新的配置
+		for j := 1; j < len(res); j++ {
+			if res[j] <= res[j-1] {
+				t.Fatalf("watcher %d: config delivered out of order %v", i, res)
+			}
+		}
+		if len(res) == 0 || res[len(res)-1] != versions {
+			t.Fatalf("watcher %d: expect latest version %d, got %v", i, versions, res)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+package config
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/hisonsoft/tsf-go/log"
+	"github.com/hisonsoft/tsf-go/pkg/config"
+	"github.com/hisonsoft/tsf-go/pkg/config/file"
+	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+)
+
+// saveSnapshot 按key的目录结构保存配置的原始内容(加密的值保持加密)，
+// 使用本地文件配置源时不需要快照
+func saveSnapshot(key string, specs []config.Spec) {
+	dir := env.ConfigSnapshotDir()
+	if env.ConfigDir() != "" || dir == "" {
+		return
+	}
+	path := filepath.Join(dir, filepath.FromSlash(key))
+	if len(specs) == 0 || specs[0].Data == nil || specs[0].Data.Raw() == nil {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			log.DefaultLog.Errorw("msg", "config snapshot remove failed!", "path", path, "err", err)
+		}
+		return
+	}
+	if err := writeFile(path, specs[0].Data.Raw()); err != nil {
+		log.DefaultLog.Errorw("msg", "config snapshot save failed!", "path", path, "err", err)
+	}
+}
+
+// loadSnapshot 快照目录与本地文件配置源的目录结构相同
+func loadSnapshot(key string) ([]config.Spec, bool) {
+	dir := env.ConfigSnapshotDir()
+	if dir == "" {
+		return nil, false
+	}
+	specs := file.New(&file.Config{Dir: dir}).Get(context.Background(), key)
+	return specs, len(specs) > 0
+}
+
+// writeFile 先写临时文件再重命名，避免读到写了一半的快照
+func writeFile(path string, b []byte) error {
+	dir := filepath.Dir(path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	// 快照可能包含敏感配置，已存在的目录也收紧权限
+	if err := os.Chmod(dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, ".snapshot-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err = f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+	if err = f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,82 @@
+package config
+
+import (
+	"context"
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/pkg/config"
+	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+)
+
+// stubWatcher 第一次Watch返回specs，specs为nil时一直阻塞，模拟配置源不可用
+type stubWatcher struct {
+	specs []config.Spec
+}
+
+func (w *stubWatcher) Watch(ctx context.Context) ([]config.Spec, error) {
+	if w.specs != nil {
+		return w.specs, nil
+	}
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func (w *stubWatcher) Close() {}
+
+func TestSnapshot(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tsf-snapshot")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	flag.Set("tsf_config_snapshot_dir", dir)
+	defer flag.Set("tsf_config_snapshot_dir", "")
+
+	key := "config/application/app-1/group-1/data"
+	var degraded int32
+	conf, err := loadKey(context.Background(), &stubWatcher{specs: []config.Spec{{Key: key, Data: rawData("maxOpen: 10")}}}, key, &degraded)
+	if err != nil || degraded != 0 {
+		t.Fatalf("unexpected load result %v %d", err, degraded)
+	}
+	if v, _ := conf.GetInt("maxOpen"); v != 10 {
+		t.Fatalf("unexpected maxOpen %d", v)
+	}
+	info, err := os.Stat(filepath.Dir(filepath.Join(dir, filepath.FromSlash(key))))
+	if err != nil || info.Mode().Perm() != 0700 {
+		t.Fatalf("expect private snapshot dir, got %v %v", info, err)
+	}
+
+	// 配置源不可用时使用上一次保存的快照
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	conf, err = loadKey(ctx, &stubWatcher{}, key, &degraded)
+	if err != nil || degraded != 1 {
+		t.Fatalf("unexpected load result %v %d", err, degraded)
+	}
+	if v, _ := conf.GetInt("maxOpen"); v != 10 {
+		t.Fatalf("unexpected maxOpen %d", v)
+	}
+	if _, err = loadKey(ctx, &stubWatcher{}, "config/application/app-2/group-1/data", &degraded); err == nil {
+		t.Fatalf("expect error without snapshot")
+	}
+
+	// 配置被删除时快照同步删除
+	if _, err = loadKey(context.Background(), &stubWatcher{specs: []config.Spec{}}, key, &degraded); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := loadSnapshot(key); ok {
+		t.Fatalf("expect snapshot removed")
+	}
+
+	// 默认保存在用户缓存目录，而不是工作目录
+	flag.Set("tsf_config_snapshot_dir", "")
+	if cache, err := os.UserCacheDir(); err == nil && !strings.HasPrefix(env.ConfigSnapshotDir(), filepath.Join(cache, "tsf-go")) {
+		t.Fatalf("expect snapshot dir in the user cache dir, got %s", env.ConfigSnapshotDir())
+	}
+}
@@ -2,13 +2,16 @@ package config
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"reflect"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/hisonsoft/tsf-go/log"
 	"github.com/hisonsoft/tsf-go/pkg/config"
-	"github.com/hisonsoft/tsf-go/pkg/config/consul"
+	"github.com/hisonsoft/tsf-go/pkg/config/source"
 	"github.com/hisonsoft/tsf-go/pkg/sys/env"
 	"github.com/hisonsoft/tsf-go/pkg/util"
 )
@@ -22,77 +25,145 @@ var app *Config
 var globalFunc []func(conf *Config)
 var appFunc []func(conf *Config)
 
-// Init 需要提前初始化，否则可能获取不到数据
+var (
+	// ErrDegraded is returned by Load when some configs are loaded from the
+	// local snapshot because the config source is unavailable
+	ErrDegraded = errors.New("config source unavailable, running with the local snapshot")
+
+	loadErr error
+	// 从本地快照加载且尚未从配置源更新的配置
+	appDegraded    int32
+	globalDegraded int32
+)
+
+// Init 需要提前初始化，否则可能获取不到数据，最多等待 tsf_config_timeout
 func Init() {
+	once.Do(func() {
+		util.ParseFlag()
+		ctx, cancel := context.WithTimeout(context.Background(), env.ConfigTimeout())
+		defer cancel()
+		loadErr = load(ctx)
+	})
+}
+
+// Load blocks until the application and global configs are loaded or ctx is
+// done, the configs are only loaded once. If the config source does not
+// respond in time the local snapshot of the last run is used and ErrDegraded
+// is returned, a config with neither causes an error. The configs keep
+// being refreshed from the source in the background anyway.
+func Load(ctx context.Context) error {
+	once.Do(func() {
+		loadErr = load(ctx)
+	})
+	return loadErr
+}
+
+// Degraded reports whether a config is served from the local snapshot and
+// has not been loaded from the config source yet
+func Degraded() bool {
+	return atomic.LoadInt32(&appDegraded) == 1 || atomic.LoadInt32(&globalDegraded) == 1
+}
+
+func appKey() string {
+	return fmt.Sprintf("config/application/%s/%s/data", env.ApplicationID(), env.GroupID())
+}
+
+func globalKey() string {
+	return fmt.Sprintf("config/application/%s/data", env.NamespaceID())
+}
+
+func load(ctx context.Context) error {
 	util.ParseFlag()
-	source := consul.DefaultConsul()
-	appWatcher := source.Subscribe(fmt.Sprintf("config/application/%s/%s/data", env.ApplicationID(), env.GroupID()))
-	globalWatcher := source.Subscribe(fmt.Sprintf("config/application/%s/data", env.NamespaceID()))
+	src := source.Default()
+	appWatcher := src.Subscribe(appKey())
+	globalWatcher := src.Subscribe(globalKey())
 
-	appSpecs, err := appWatcher.Watch(context.Background())
-	if err != nil {
-		log.DefaultLog.Errorf("config watch failed!err:=%v", err)
-		return
+	var appErr, globalErr error
+	app, appErr = loadKey(ctx, appWatcher, appKey(), &appDegraded)
+	global, globalErr = loadKey(ctx, globalWatcher, globalKey(), &globalDegraded)
+	initLayers()
+	remerge()
+
+	go refreshGlobal(globalWatcher)
+	go refreshApp(appWatcher)
+
+	for _, err := range []error{appErr, globalErr} {
+		if err != nil {
+			return err
+		}
 	}
-	gloablSpecs, err := globalWatcher.Watch(context.Background())
-	if err != nil {
-		log.DefaultLog.Errorf("config watch failed!err:=%v", err)
-		return
+	if Degraded() {
+		log.DefaultLog.Errorw("msg", "config source unavailable, running with the local snapshot!", "dir", env.ConfigSnapshotDir())
+		return ErrDegraded
+	}
+	return nil
+}
+
+// loadKey 首次加载配置，超时则使用本地快照
+func loadKey(ctx context.Context, watcher config.Watcher, key string, degraded *int32) (*Config, error) {
+	specs, err := watcher.Watch(ctx)
+	if err == nil {
+		return parseSpecs(key, specs)
 	}
-	if len(appSpecs) > 0 {
-		app = newTsfConfig(appSpecs[0].Data)
+	log.DefaultLog.Errorw("msg", "config load failed, try the local snapshot!", "key", key, "err", err)
+	snapshot, ok := loadSnapshot(key)
+	if !ok {
+		err = fmt.Errorf("config %s load failed and no local snapshot found: %v", key, err)
+		log.DefaultLog.Errorw("msg", "config load failed!", "key", key, "err", err)
+		return nil, err
 	}
-	if len(gloablSpecs) > 0 {
-		global = newTsfConfig(gloablSpecs[0].Data)
+	conf, err := newTsfConfig(snapshot[0].Data)
+	if err != nil {
+		return nil, err
 	}
-
-	go refreshApp(appWatcher)
-	go refreshGlobal(globalWatcher)
+	atomic.StoreInt32(degraded, 1)
+	return conf, nil
 }
 
-func refreshGlobal(globalWatcher config.Watcher) {
-	ctx := context.Background()
-	for {
-		specs, err := globalWatcher.Watch(ctx)
-		if err != nil {
-			log.DefaultLog.Errorw("msg", "refreshGlobal Watch failed!", "err", err)
+// parseSpecs 解析配置源推送的配置并保存快照
+func parseSpecs(key string, specs []config.Spec) (conf *Config, err error) {
+	if len(specs) > 0 {
+		if conf, err = newTsfConfig(specs[0].Data); err != nil {
 			return
 		}
-		var conf *Config
-		if len(specs) > 0 {
-			conf = newTsfConfig(specs[0].Data)
-		}
-		mu.Lock()
-		global = conf
-		mu.Unlock()
-		go func() {
-			for _, f := range globalFunc {
-				f(conf)
-			}
-		}()
 	}
+	saveSnapshot(key, specs)
+	return
+}
+
+func refreshGlobal(globalWatcher config.Watcher) {
+	refresh(globalWatcher, globalKey(), &global, &globalFunc, &globalDegraded)
 }
 
 func refreshApp(appWatcher config.Watcher) {
+	refresh(appWatcher, appKey(), &app, &appFunc, &appDegraded)
+}
+
+// refresh 持续监听配置变化，回调按注册顺序在同一个goroutine中依次执行，
+// 保证每个回调按配置变更的顺序收到推送
+func refresh(watcher config.Watcher, key string, cur **Config, funcs *[]func(conf *Config), degraded *int32) {
 	ctx := context.Background()
 	for {
-		specs, err := appWatcher.Watch(ctx)
+		specs, err := watcher.Watch(ctx)
 		if err != nil {
-			log.DefaultLog.Errorw("msg", "refreshApp Watch failed!", "err", err)
+			log.DefaultLog.Errorw("msg", "refresh config Watch failed!", "err", err)
 			return
 		}
-		var conf *Config
-		if len(specs) > 0 {
-			conf = newTsfConfig(specs[0].Data)
+		conf, err := parseSpecs(key, specs)
+		if err != nil {
+			// 格式错误的配置不生效，继续使用上一份配置
+			continue
 		}
+		atomic.StoreInt32(degraded, 0)
 		mu.Lock()
-		app = conf
+		*cur = conf
+		fs := make([]func(conf *Config), len(*funcs))
+		copy(fs, *funcs)
 		mu.Unlock()
-		go func() {
-			for _, f := range appFunc {
-				f(conf)
-			}
-		}()
+		for _, f := range fs {
+			f(conf)
+		}
+		remerge()
 	}
 }
 
@@ -104,11 +175,15 @@ func GetConfig(opts ...Option) *Config {
 // WatchConfig 订阅配置文件的变化，如果非空则第一次必推送
 // 可能推送nil config
 func WatchConfig(f func(conf *Config), opts ...Option) {
-	once.Do(Init)
+	Init()
 	var opt options
 	for _, o := range opts {
 		o(&opt)
 	}
+	if opt.isMerged {
+		watchMerged(f)
+		return
+	}
 	mu.Lock()
 	defer mu.Unlock()
 	if opt.isGlobal {
@@ -125,8 +200,28 @@ func WatchConfig(f func(conf *Config), opts ...Option) {
 	return
 }
 
+// WatchKey 订阅某个key的变化，只有该路径上的值变化时才回调，路径格式同Get。
+// 第一次如果非空则推送(old为nil)，key被删除时new为nil
+func WatchKey(path string, f func(old, new interface{}), opts ...Option) {
+	WatchConfig(keyWatcher(path, f), opts...)
+}
+
+// keyWatcher 比较相邻两次推送的配置中path上的值
+func keyWatcher(path string, f func(old, new interface{})) func(conf *Config) {
+	var last interface{}
+	return func(conf *Config) {
+		v, _ := conf.Get(path)
+		if reflect.DeepEqual(last, v) {
+			return
+		}
+		old := last
+		last = v
+		f(old, v)
+	}
+}
+
 func getCfg(opts ...Option) *Config {
-	once.Do(Init)
+	Init()
 	var cfg *Config
 	var opt options
 	for _, o := range opts {
@@ -134,7 +229,9 @@ func getCfg(opts ...Option) *Config {
 	}
 	mu.RLock()
 	defer mu.RUnlock()
-	if opt.isGlobal {
+	if opt.isMerged {
+		cfg = merged
+	} else if opt.isGlobal {
 		cfg = global
 	} else {
 		cfg = app
@@ -200,7 +297,9 @@ func GetTime(key string, opts ...Option) (v time.Time, ok bool) {
 }
 
 type options struct {
-	isGlobal bool
+	isGlobal   bool
+	isMerged   bool
+	validators []func(v interface{}) error
 }
 
 // WithGlobal is with global config
@@ -210,5 +309,22 @@ func WithGlobal(isGlobal bool) Option {
 	}
 }
 
+// WithMerged is with the merged config of all layers: defaults, global,
+// application, local file and env overrides, a later layer takes precedence.
+// Maps are merged key by key, other values including arrays are replaced.
+func WithMerged(isMerged bool) Option {
+	return func(o *options) {
+		o.isMerged = isMerged
+	}
+}
+
+// WithValidator adds a validation hook to Bind, a config rejected by the
+// hook is not applied and the previous value is kept
+func WithValidator(f func(v interface{}) error) Option {
+	return func(o *options) {
+		o.validators = append(o.validators, f)
+	}
+}
+
 // Option is config client option.
 type Option func(*options)
@@ -0,0 +1,180 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lookup 按路径查找值，路径由点号分隔的key及[n]数组下标组成，数字段也可以作为下标
+func lookup(v interface{}, path string) (interface{}, bool) {
+	segs, ok := parsePath(path)
+	if !ok {
+		return nil, false
+	}
+	for _, seg := range segs {
+		switch node := v.(type) {
+		case map[string]interface{}:
+			if v, ok = node[seg]; !ok {
+				return nil, false
+			}
+		case map[interface{}]interface{}:
+			if v, ok = node[seg]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			idx, err := strconv.Atoi(seg)
+			if err != nil || idx < 0 || idx >= len(node) {
+				return nil, false
+			}
+			v = node[idx]
+		default:
+			return nil, false
+		}
+	}
+	return v, true
+}
+
+func parsePath(path string) (segs []string, ok bool) {
+	for _, part := range strings.Split(path, ".") {
+		for {
+			i := strings.IndexByte(part, '[')
+			if i < 0 {
+				break
+			}
+			j := strings.IndexByte(part[i:], ']')
+			if j < 0 {
+				return nil, false
+			}
+			if i > 0 {
+				segs = append(segs, part[:i])
+			}
+			segs = append(segs, part[i+1:i+j])
+			part = part[i+j+1:]
+		}
+		if part != "" {
+			segs = append(segs, part)
+		}
+	}
+	return segs, len(segs) > 0
+}
+
+func toString(v interface{}) (string, bool) {
+	switch s := v.(type) {
+	case string:
+		return s, true
+	case bool, int, int64, int32, uint, uint64, uint32, float64, float32:
+		return fmt.Sprint(s), true
+	case time.Time:
+		return s.Format(time.RFC3339Nano), true
+	}
+	return "", false
+}
+
+func toBool(v interface{}) (bool, bool) {
+	switch b := v.(type) {
+	case bool:
+		return b, true
+	case string:
+		res, err := strconv.ParseBool(strings.TrimSpace(b))
+		return res, err == nil
+	}
+	return false, false
+}
+
+func toInt64(v interface{}) (int64, bool) {
+	switch i := v.(type) {
+	case int:
+		return int64(i), true
+	case int64:
+		return i, true
+	case int32:
+		return int64(i), true
+	case uint:
+		return int64(i), uint64(i) <= math.MaxInt64
+	case uint64:
+		return int64(i), i <= math.MaxInt64
+	case uint32:
+		return int64(i), true
+	case float64:
+		// float64(math.MaxInt64)为2^63，转换会溢出，因此上限不能取等
+		return int64(i), i == math.Trunc(i) && i >= -1<<63 && i < 1<<63
+	case float32:
+		return toInt64(float64(i))
+	case string:
+		res, err := strconv.ParseInt(strings.TrimSpace(i), 10, 64)
+		if err != nil {
+			if f, err := strconv.ParseFloat(strings.TrimSpace(i), 64); err == nil {
+				return toInt64(f)
+			}
+			return 0, false
+		}
+		return res, true
+	}
+	return 0, false
+}
+
+func toFloat64(v interface{}) (float64, bool) {
+	switch f := v.(type) {
+	case float64:
+		return f, true
+	case float32:
+		return float64(f), true
+	case string:
+		res, err := strconv.ParseFloat(strings.TrimSpace(f), 64)
+		return res, err == nil
+	}
+	if i, ok := toInt64(v); ok {
+		return float64(i), true
+	}
+	return 0, false
+}
+
+// toDuration 支持 "5s"、"1m30s" 格式，整数按纳秒处理
+func toDuration(v interface{}) (time.Duration, bool) {
+	switch d := v.(type) {
+	case time.Duration:
+		return d, true
+	case string:
+		d = strings.TrimSpace(d)
+		if res, err := time.ParseDuration(d); err == nil {
+			return res, true
+		}
+		if i, err := strconv.ParseInt(d, 10, 64); err == nil {
+			return time.Duration(i), true
+		}
+		return 0, false
+	}
+	if i, ok := toInt64(v); ok {
+		return time.Duration(i), true
+	}
+	return 0, false
+}
+
+var timeLayouts = []string{
+	time.RFC3339Nano,
+	"2006-01-02 15:04:05",
+	"2006-01-02",
+}
+
+// toTime 支持 RFC3339、"2006-01-02 15:04:05"、"2006-01-02" 格式，整数按unix秒处理
+func toTime(v interface{}) (time.Time, bool) {
+	switch t := v.(type) {
+	case time.Time:
+		return t, true
+	case string:
+		t = strings.TrimSpace(t)
+		for _, layout := range timeLayouts {
+			if res, err := time.Parse(layout, t); err == nil {
+				return res, true
+			}
+		}
+		return time.Time{}, false
+	}
+	if i, ok := toInt64(v); ok {
+		return time.Unix(i, 0), true
+	}
+	return time.Time{}, false
+}
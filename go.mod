@@ -3,6 +3,7 @@ module github.com/hisonsoft/tsf-go
 go 1.14
 
 require (
+	github.com/BurntSushi/toml v0.3.1
 	github.com/elazarl/goproxy v0.0.0-20210801061803-8e322dfb79c4
 	github.com/fullstorydev/grpcurl v1.8.2
 	github.com/gin-gonic/gin v1.7.3
@@ -25,6 +26,7 @@ require (
 	github.com/onsi/ginkgo v1.16.5 // indirect
 	github.com/onsi/gomega v1.18.1 // indirect
 	github.com/openzipkin/zipkin-go v0.2.5
+	github.com/prometheus/client_golang v1.11.1
 	github.com/stretchr/testify v1.7.0
 	github.com/ugorji/go v1.2.6 // indirect
 	go.opentelemetry.io/contrib/propagators v0.22.0
@@ -39,6 +41,7 @@ require (
 	golang.org/x/text v0.3.7 // indirect
 	golang.org/x/tools v0.1.5 // indirect
 	google.golang.org/grpc v1.44.0
+	google.golang.org/protobuf v1.27.1
 	gopkg.in/natefinch/lumberjack.v2 v2.0.0 // indirect
 	gopkg.in/yaml.v3 v3.0.0-20210107192922-496545a6307b
 	nhooyr.io/websocket v1.8.7 // indirect
@@ -0,0 +1,159 @@
+package tsf
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/health"
+
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	"google.golang.org/grpc"
+	ghealth "google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+const (
+	healthCheckOperation = "/grpc.health.v1.Health/Check"
+	healthWatchOperation = "/grpc.health.v1.Health/Watch"
+)
+
+// healthInterval 有Watch调用方时后台刷新探针结果的间隔
+var healthInterval = time.Second * 5
+
+var (
+	healthMu      sync.Mutex
+	healthServers = make(map[*health.Checker]*healthServer)
+)
+
+// healthServer 将checker的结果通过SetServingStatus同步到grpc健康检查服务，
+// kratos内置的健康检查服务无法从外部获取，Check及Watch请求由这里应答
+type healthServer struct {
+	*ghealth.Server
+	checker *health.Checker
+
+	mu       sync.Mutex
+	watchers int
+	stop     chan struct{}
+}
+
+// healthServerOf 返回checker对应的健康检查服务
+func healthServerOf(checker *health.Checker) *healthServer {
+	healthMu.Lock()
+	defer healthMu.Unlock()
+	if hs, ok := healthServers[checker]; ok {
+		return hs
+	}
+	hs := &healthServer{Server: ghealth.NewServer(), checker: checker}
+	healthServers[checker] = hs
+	return hs
+}
+
+// watch 增加一个Watch调用方，第一个调用方开始后台刷新，返回的函数在调用结束时释放，
+// 最后一个调用方结束后停止刷新
+func (hs *healthServer) watch() (release func()) {
+	hs.update(context.Background())
+	hs.mu.Lock()
+	hs.watchers++
+	if hs.watchers == 1 {
+		hs.stop = make(chan struct{})
+		go hs.refresh(hs.stop)
+	}
+	hs.mu.Unlock()
+	return func() {
+		hs.mu.Lock()
+		defer hs.mu.Unlock()
+		if hs.watchers--; hs.watchers == 0 {
+			close(hs.stop)
+		}
+	}
+}
+
+func (hs *healthServer) refresh(stop chan struct{}) {
+	ticker := time.NewTicker(healthInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			hs.update(context.Background())
+		}
+	}
+}
+
+// update 执行探针并更新服务状态，状态变化时推送给Watch的调用方
+func (hs *healthServer) update(ctx context.Context) grpc_health_v1.HealthCheckResponse_ServingStatus {
+	status := grpc_health_v1.HealthCheckResponse_SERVING
+	if hs.checker.Check(ctx).Status == health.StatusCritical {
+		status = grpc_health_v1.HealthCheckResponse_NOT_SERVING
+	}
+	hs.SetServingStatus("", status)
+	return status
+}
+
+// healthMiddleware reports NOT_SERVING on grpc.health.v1.Health/Check while
+// critical probes of the application are failing
+func healthMiddleware(checker *health.Checker) middleware.Middleware {
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (resp interface{}, err error) {
+			if tr, ok := transport.FromServerContext(ctx); ok && tr.Operation() == healthCheckOperation {
+				if in, ok := req.(*grpc_health_v1.HealthCheckRequest); ok && in.Service == "" {
+					status := healthServerOf(checker).update(ctx)
+					if status != grpc_health_v1.HealthCheckResponse_SERVING {
+						return &grpc_health_v1.HealthCheckResponse{Status: status}, nil
+					}
+				}
+			}
+			// 服务正常时由kratos应答，停止时返回NOT_SERVING
+			return handler(ctx, req)
+		}
+	}
+}
+
+// HealthStreamInterceptor answers grpc.health.v1.Health/Watch with the status
+// of the health checker(default health.DefaultChecker()), the watchers are
+// notified when critical probes start or stop failing, e.g.
+// grpc.NewServer(grpc.StreamInterceptor(tsf.HealthStreamInterceptor())).
+func HealthStreamInterceptor(opts ...ServerOption) grpc.StreamServerInterceptor {
+	o := serverOpionts{health: health.DefaultChecker()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	return func(srv interface{}, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if info.FullMethod != healthWatchOperation {
+			return handler(srv, ss)
+		}
+		in := new(grpc_health_v1.HealthCheckRequest)
+		if err := ss.RecvMsg(in); err != nil {
+			return err
+		}
+		if in.Service != "" {
+			// 只有整体状态来自checker，其它服务仍由kratos的健康检查服务应答
+			return handler(srv, &healthWatchServer{ServerStream: ss, in: in})
+		}
+		hs := healthServerOf(o.health)
+		defer hs.watch()()
+		return hs.Watch(in, &healthWatchServer{ServerStream: ss})
+	}
+}
+
+type healthWatchServer struct {
+	grpc.ServerStream
+	// in 已经读取的请求，交给原handler时重新返回
+	in *grpc_health_v1.HealthCheckRequest
+}
+
+func (s *healthWatchServer) Send(m *grpc_health_v1.HealthCheckResponse) error {
+	return s.ServerStream.SendMsg(m)
+}
+
+func (s *healthWatchServer) RecvMsg(m interface{}) error {
+	if in, ok := m.(*grpc_health_v1.HealthCheckRequest); ok && s.in != nil {
+		in.Service = s.in.Service
+		s.in = nil
+		return nil
+	}
+	return s.ServerStream.RecvMsg(m)
+}
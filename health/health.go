@@ -0,0 +1,251 @@
+package health
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Status is the aggregated health status of the application
+type Status int
+
+const (
+	// StatusPassing means all probes succeeded
+	StatusPassing Status = iota
+	// StatusWarning means only non-critical probes failed
+	StatusWarning
+	// StatusCritical means at least one critical probe failed
+	StatusCritical
+)
+
+// String returns the consul check status name
+func (s Status) String() string {
+	switch s {
+	case StatusPassing:
+		return "passing"
+	case StatusWarning:
+		return "warning"
+	}
+	return "critical"
+}
+
+// Probe reports whether a dependency of the application is healthy,
+// e.g. database reachable or caches warmed.
+type Probe func(ctx context.Context) error
+
+// ProbeOption configures a probe.
+type ProbeOption func(*probe)
+
+// NonCritical marks the probe as non-critical: its failure only degrades
+// the status to warning instead of critical.
+func NonCritical() ProbeOption {
+	return func(p *probe) {
+		p.critical = false
+	}
+}
+
+type probe struct {
+	name     string
+	fn       Probe
+	critical bool
+}
+
+// Result is the outcome of a health check
+type Result struct {
+	Status Status
+	// Errors holds the failed probes by name
+	Errors map[string]error
+	Time   time.Time
+}
+
+// Output returns a human readable summary used as the consul check note
+func (r Result) Output() string {
+	if len(r.Errors) == 0 {
+		return r.Status.String()
+	}
+	names := make([]string, 0, len(r.Errors))
+	for name := range r.Errors {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	outputs := make([]string, 0, len(names))
+	for _, name := range names {
+		outputs = append(outputs, name+": "+r.Errors[name].Error())
+	}
+	return strings.Join(outputs, "; ")
+}
+
+var (
+	mu             sync.Mutex
+	defaultChecker *Checker
+)
+
+// Checker runs the registered probes and aggregates their results
+type Checker struct {
+	mu       sync.RWMutex
+	probes   []*probe
+	timeout  time.Duration
+	cacheTTL time.Duration
+	last     *Result
+}
+
+// DefaultChecker returns the process wide checker used by service registration
+func DefaultChecker() *Checker {
+	mu.Lock()
+	defer mu.Unlock()
+	if defaultChecker == nil {
+		defaultChecker = New()
+	}
+	return defaultChecker
+}
+
+// Register adds a probe to the default checker
+func Register(name string, p Probe, opts ...ProbeOption) {
+	DefaultChecker().Register(name, p, opts...)
+}
+
+// Deregister removes a probe from the default checker
+func Deregister(name string) {
+	DefaultChecker().Deregister(name)
+}
+
+// New creates a checker; probes run with a 5s timeout and results are
+// cached for one second so frequent health queries do not hammer dependencies.
+func New() *Checker {
+	return &Checker{
+		timeout:  time.Second * 5,
+		cacheTTL: time.Second,
+	}
+}
+
+// Register adds a probe, replacing any existing probe with the same name
+func (c *Checker) Register(name string, p Probe, opts ...ProbeOption) {
+	pr := &probe{name: name, fn: p, critical: true}
+	for _, o := range opts {
+		o(pr)
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	// copy on write, Check iterates the probes without holding the lock
+	probes := make([]*probe, 0, len(c.probes)+1)
+	for _, old := range c.probes {
+		if old.name != name {
+			probes = append(probes, old)
+		}
+	}
+	c.probes = append(probes, pr)
+	c.last = nil
+}
+
+// Deregister removes the probe with the given name
+func (c *Checker) Deregister(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	probes := make([]*probe, 0, len(c.probes))
+	for _, old := range c.probes {
+		if old.name != name {
+			probes = append(probes, old)
+		}
+	}
+	c.probes = probes
+	c.last = nil
+}
+
+// Check runs all probes concurrently and returns the aggregated result
+func (c *Checker) Check(ctx context.Context) Result {
+	c.mu.RLock()
+	last := c.last
+	probes := c.probes
+	c.mu.RUnlock()
+	if last != nil && time.Since(last.Time) < c.cacheTTL {
+		return *last
+	}
+
+	res := Result{Status: StatusPassing, Time: time.Now()}
+	if len(probes) > 0 {
+		ctx, cancel := context.WithTimeout(ctx, c.timeout)
+		defer cancel()
+		errs := make([]error, len(probes))
+		var wg sync.WaitGroup
+		for i, p := range probes {
+			wg.Add(1)
+			go func(i int, p *probe) {
+				defer wg.Done()
+				errs[i] = run(ctx, p.fn)
+			}(i, p)
+		}
+		wg.Wait()
+		for i, err := range errs {
+			if err == nil {
+				continue
+			}
+			if res.Errors == nil {
+				res.Errors = make(map[string]error)
+			}
+			res.Errors[probes[i].name] = err
+			if probes[i].critical {
+				res.Status = StatusCritical
+			} else if res.Status == StatusPassing {
+				res.Status = StatusWarning
+			}
+		}
+	}
+	c.mu.Lock()
+	c.last = &res
+	c.mu.Unlock()
+	return res
+}
+
+// Handler returns a http handler suitable for a consul HTTP check:
+// 503 when critical, otherwise 200. Warning is reported in the body only,
+// since consul discovery drops instances whose checks are not passing.
+func (c *Checker) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		res := c.Check(r.Context())
+		code := http.StatusOK
+		if res.Status == StatusCritical {
+			code = http.StatusServiceUnavailable
+		}
+		errs := make(map[string]string, len(res.Errors))
+		for name, err := range res.Errors {
+			errs[name] = err.Error()
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(code)
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"status": res.Status.String(),
+			"errors": errs,
+		})
+	})
+}
+
+func run(ctx context.Context, p Probe) (err error) {
+	done := make(chan error, 1)
+	go func() {
+		defer func() {
+			if r := recover(); r != nil {
+				done <- panicError{r}
+			}
+		}()
+		done <- p(ctx)
+	}()
+	select {
+	case err = <-done:
+	case <-ctx.Done():
+		err = ctx.Err()
+	}
+	return
+}
+
+type panicError struct {
+	v interface{}
+}
+
+func (e panicError) Error() string {
+	return fmt.Sprintf("probe panic: %v", e.v)
+}
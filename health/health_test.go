@@ -0,0 +1,81 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestCheck(t *testing.T) {
+	c := New()
+	c.cacheTTL = 0
+	if res := c.Check(context.Background()); res.Status != StatusPassing {
+		t.Fatalf("expect passing without probes, got %v", res.Status)
+	}
+
+	var cacheErr, dbErr error
+	c.Register("cache", func(ctx context.Context) error { return cacheErr }, NonCritical())
+	c.Register("db", func(ctx context.Context) error { return dbErr })
+
+	cacheErr = errors.New("not warmed")
+	if res := c.Check(context.Background()); res.Status != StatusWarning || res.Output() != "cache: not warmed" {
+		t.Fatalf("expect warning, got %v %s", res.Status, res.Output())
+	}
+	dbErr = errors.New("unreachable")
+	if res := c.Check(context.Background()); res.Status != StatusCritical {
+		t.Fatalf("expect critical, got %v", res.Status)
+	}
+	c.Deregister("db")
+	cacheErr = nil
+	if res := c.Check(context.Background()); res.Status != StatusPassing {
+		t.Fatalf("expect passing, got %v %s", res.Status, res.Output())
+	}
+}
+
+func TestCheckTimeoutAndPanic(t *testing.T) {
+	c := New()
+	c.timeout = time.Millisecond * 50
+	c.Register("slow", func(ctx context.Context) error {
+		time.Sleep(time.Second)
+		return nil
+	})
+	c.Register("panic", func(ctx context.Context) error {
+		panic("boom")
+	})
+	res := c.Check(context.Background())
+	if res.Status != StatusCritical || len(res.Errors) != 2 {
+		t.Fatalf("expect both probes failed, got %v %s", res.Status, res.Output())
+	}
+}
+
+func TestHandler(t *testing.T) {
+	c := New()
+	c.cacheTTL = 0
+	var err error
+	srv := httptest.NewServer(c.Handler())
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		err  error
+		opt  []ProbeOption
+		code int
+	}{
+		{nil, nil, http.StatusOK},
+		{errors.New("down"), []ProbeOption{NonCritical()}, http.StatusOK},
+		{errors.New("down"), nil, http.StatusServiceUnavailable},
+	} {
+		err = tc.err
+		c.Register("db", func(ctx context.Context) error { return err }, tc.opt...)
+		resp, e := http.Get(srv.URL)
+		if e != nil {
+			t.Fatalf("get health failed!err:=%v", e)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.code {
+			t.Fatalf("expect code %d, got %d", tc.code, resp.StatusCode)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package tsf
+
+import (
+	"context"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/health"
+
+	"google.golang.org/grpc"
+	ghealth "google.golang.org/grpc/health"
+	pb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestHealthWatch(t *testing.T) {
+	defer func(d time.Duration) { healthInterval = d }(healthInterval)
+	healthInterval = time.Millisecond * 100
+	checker := health.New()
+	var down atomic.Value
+	down.Store(false)
+	checker.Register("db", func(ctx context.Context) error {
+		if down.Load().(bool) {
+			return errors.New("unreachable")
+		}
+		return nil
+	})
+
+	// 与kratos一样注册内置的健康检查服务
+	srv := grpc.NewServer(grpc.StreamInterceptor(HealthStreamInterceptor(WithHealthChecker(checker))))
+	builtin := ghealth.NewServer()
+	builtin.SetServingStatus("other", pb.HealthCheckResponse_NOT_SERVING)
+	pb.RegisterHealthServer(srv, builtin)
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, lis.Addr().String(), grpc.WithInsecure())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	cli := pb.NewHealthClient(conn)
+	sctx, scancel := context.WithCancel(ctx)
+	stream, err := cli.Watch(sctx, &pb.HealthCheckRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := func(status pb.HealthCheckResponse_ServingStatus) {
+		t.Helper()
+		resp, err := stream.Recv()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != status {
+			t.Fatalf("expect %v, got %v", status, resp.Status)
+		}
+	}
+	expect(pb.HealthCheckResponse_SERVING)
+	down.Store(true)
+	expect(pb.HealthCheckResponse_NOT_SERVING)
+	down.Store(false)
+	expect(pb.HealthCheckResponse_SERVING)
+
+	// 最后一个调用方结束后停止后台刷新
+	hs := healthServerOf(checker)
+	scancel()
+	watching := func() int {
+		hs.mu.Lock()
+		defer hs.mu.Unlock()
+		return hs.watchers
+	}
+	for deadline := time.Now().Add(time.Second); watching() != 0; time.Sleep(10 * time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatalf("expect refresh stopped, got %d watchers", watching())
+		}
+	}
+
+	// 其它服务仍由内置的健康检查服务应答
+	other, err := cli.Watch(ctx, &pb.HealthCheckRequest{Service: "other"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp, err := other.Recv(); err != nil || resp.Status != pb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("expect builtin status NOT_SERVING, got %v %v", resp, err)
+	}
+}
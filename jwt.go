@@ -0,0 +1,103 @@
+package tsf
+
+import (
+	"context"
+	"strings"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/config"
+	"github.com/hisonsoft/tsf-go/log"
+	"github.com/hisonsoft/tsf-go/pkg/auth/jwt"
+	"github.com/hisonsoft/tsf-go/pkg/meta"
+
+	"github.com/go-kratos/kratos/v2/middleware"
+	"github.com/go-kratos/kratos/v2/transport"
+	"gopkg.in/yaml.v3"
+)
+
+// WithJWT validates the bearer tokens before the auth rules are checked, the
+// mapped claims are put in the user tags so that the rules can match them.
+func WithJWT(c jwt.Config) ServerOption {
+	return func(o *serverOpionts) {
+		o.jwt = jwt.New(c)
+	}
+}
+
+func jwtMiddleware(v *jwt.Validator) middleware.Middleware {
+	conf := v.Config()
+	if conf.RulesKey != "" {
+		config.WatchKey(conf.RulesKey, rulesWatcher(v))
+	}
+	return func(handler middleware.Handler) middleware.Handler {
+		return func(ctx context.Context, req interface{}) (resp interface{}, err error) {
+			_, operation := ServerOperation(ctx)
+			if operation == healthCheckOperation {
+				return handler(ctx, req)
+			}
+			token := bearerToken(ctx)
+			if token == "" && conf.Optional {
+				if scopes, _ := v.Required(operation); len(scopes) == 0 {
+					return handler(meta.WithUser(ctx, claimTags(conf.Claims, nil)...), req)
+				}
+			}
+			claims, err := v.Validate(token, time.Now())
+			if err != nil {
+				return nil, err
+			}
+			if err = v.Authorize(claims, operation); err != nil {
+				return nil, err
+			}
+			return handler(meta.WithUser(ctx, claimTags(conf.Claims, claims)...), req)
+		}
+	}
+}
+
+func rulesWatcher(v *jwt.Validator) func(old, new interface{}) {
+	conf := v.Config()
+	return func(old, new interface{}) {
+		// 配置被删除时不能放开所有接口，恢复为静态配置的规则
+		if new == nil {
+			log.DefaultLog.Errorw("msg", "[jwt] rules not found in config, use the static ones!", "key", conf.RulesKey)
+			v.SetRules(conf.Rules)
+			return
+		}
+		rules, err := decodeRules(new)
+		if err != nil {
+			log.DefaultLog.Errorw("msg", "[jwt] decode rules failed, keep the old ones!", "key", conf.RulesKey, "err", err)
+			return
+		}
+		v.SetRules(rules)
+	}
+}
+
+// claimTags 请求头中可能伪造了映射的标签，token中没有的claim也置为空
+func claimTags(mapping map[string]string, claims jwt.Claims) []meta.UserPair {
+	pairs := make([]meta.UserPair, 0, len(mapping))
+	for claim, tag := range mapping {
+		value, _ := claims.String(claim)
+		pairs = append(pairs, meta.UserPair{Key: tag, Value: value})
+	}
+	return pairs
+}
+
+func bearerToken(ctx context.Context) string {
+	tr, ok := transport.FromServerContext(ctx)
+	if !ok {
+		return ""
+	}
+	auth := tr.RequestHeader().Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[:7], "Bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+func decodeRules(v interface{}) ([]jwt.Rule, error) {
+	var rules []jwt.Rule
+	b, err := yaml.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	err = yaml.Unmarshal(b, &rules)
+	return rules, err
+}
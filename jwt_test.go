@@ -0,0 +1,28 @@
+package tsf
+
+import (
+	"testing"
+
+	"github.com/hisonsoft/tsf-go/pkg/auth/jwt"
+)
+
+func TestJWTRulesWatcher(t *testing.T) {
+	v := jwt.New(jwt.Config{Keys: jwt.HMACKey("secret"), RulesKey: "jwt.rules", Rules: []jwt.Rule{
+		{Operation: "/api/admin/*", Scopes: []string{"admin"}},
+	}})
+	watch := rulesWatcher(v)
+	watch(nil, []interface{}{map[string]interface{}{"operation": "/api/admin/*", "scopes": []interface{}{"root"}}})
+	if scopes, _ := v.Required("/api/admin/users"); len(scopes) != 1 || scopes[0] != "root" {
+		t.Fatalf("expect rules replaced, got %v", scopes)
+	}
+	// 解析失败时保留原规则
+	watch(nil, "invalid")
+	if scopes, _ := v.Required("/api/admin/users"); len(scopes) != 1 || scopes[0] != "root" {
+		t.Fatalf("expect rules kept, got %v", scopes)
+	}
+	// 配置被删除时恢复为静态规则，而不是放开所有接口
+	watch(nil, nil)
+	if scopes, _ := v.Required("/api/admin/users"); len(scopes) != 1 || scopes[0] != "admin" {
+		t.Fatalf("expect static rules restored, got %v", scopes)
+	}
+}
@@ -9,8 +9,9 @@ import (
 	"github.com/go-kratos/kratos/v2/middleware"
 	"github.com/go-kratos/kratos/v2/transport"
 	"github.com/hisonsoft/tsf-go/pkg/meta"
+	"github.com/hisonsoft/tsf-go/pkg/sys/metrics"
 	"github.com/hisonsoft/tsf-go/pkg/sys/monitor"
-	"github.com/hisonsoft/tsf-go/util"
+	"github.com/hisonsoft/tsf-go/route/lane"
 )
 
 func getStat(serviceName string, operation string, method string) *monitor.Stat {
@@ -25,6 +26,20 @@ func getClientStat(ctx context.Context, remoteServiceName string, operation stri
 	return monitor.NewStat(monitor.CategoryMS, monitor.KindClient, &monitor.Endpoint{ServiceName: localService, InterfaceName: localOperation, Path: localOperation, Method: localMethod}, &monitor.Endpoint{ServiceName: remoteServiceName, InterfaceName: operation, Path: operation, Method: method})
 }
 
+// laneLabel 返回请求的泳道ID，泳道ID由调用方传入，未配置的泳道不作为监控标签，
+// 避免调用方随意构造导致标签无限增长
+func laneLabel(ctx context.Context) string {
+	laneID, _ := meta.Sys(ctx, meta.LaneID).(string)
+	if laneID == "" || !laneExists(laneID) {
+		return ""
+	}
+	return laneID
+}
+
+var laneExists = func(laneID string) bool {
+	return lane.DefaultLane().Exists(laneID)
+}
+
 func serverMetricsMiddleware() middleware.Middleware {
 	var (
 		once        sync.Once
@@ -39,12 +54,14 @@ func serverMetricsMiddleware() middleware.Middleware {
 
 			method, operation := ServerOperation(ctx)
 			stat := getStat(serviceName, operation, method)
+			done := metrics.StartRequest(metrics.Labels{Kind: monitor.KindServer, LocalService: serviceName, LocalInterface: operation, Lane: laneLabel(ctx)})
 			defer func() {
 				var code = 200
 				if err != nil {
 					code = int(errors.FromError(err).GetCode())
 				}
 				stat.Record(code)
+				done(code)
 			}()
 
 			reply, err = handler(ctx, req)
@@ -59,17 +76,26 @@ func clientMetricsMiddleware() middleware.Middleware {
 		return func(ctx context.Context, req interface{}) (reply interface{}, err error) {
 			once.Do(func() {
 				tr, _ := transport.FromClientContext(ctx)
-				remoteServiceName, _ = util.ParseTarget(tr.Endpoint())
+				remoteServiceName = remoteService(tr.Endpoint()).Name
 			})
 
 			method, operation := ClientOperation(ctx)
 			stat := getClientStat(ctx, remoteServiceName, operation, method)
+			done := metrics.StartRequest(metrics.Labels{
+				Kind:            monitor.KindClient,
+				LocalService:    stat.Local.ServiceName,
+				LocalInterface:  stat.Local.InterfaceName,
+				RemoteService:   remoteServiceName,
+				RemoteInterface: operation,
+				Lane:            laneLabel(ctx),
+			})
 			defer func() {
 				var code = 200
 				if err != nil {
 					code = int(errors.FromError(err).GetCode())
 				}
 				stat.Record(code)
+				done(code)
 			}()
 
 			reply, err = handler(ctx, req)
@@ -0,0 +1,19 @@
+package tsf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hisonsoft/tsf-go/pkg/meta"
+)
+
+func TestLaneLabel(t *testing.T) {
+	defer func(f func(string) bool) { laneExists = f }(laneExists)
+	laneExists = func(laneID string) bool { return laneID == "lane-1" }
+	for laneID, expect := range map[string]string{"": "", "lane-1": "lane-1", "forged-123": ""} {
+		ctx := meta.WithSys(context.Background(), meta.SysPair{Key: meta.LaneID, Value: laneID})
+		if label := laneLabel(ctx); label != expect {
+			t.Fatalf("%q: expect lane label %q, got %q", laneID, expect, label)
+		}
+	}
+}
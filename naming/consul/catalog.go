@@ -0,0 +1,178 @@
+package consul
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"sort"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/hisonsoft/tsf-go/naming"
+)
+
+// CatalogService is a service registered in a namespace
+type CatalogService struct {
+	Name string
+	// Tags is the union of the tags of all instances
+	Tags []string
+}
+
+type catalogInfo struct {
+	namespace string
+	services  atomic.Value
+	watcher   map[*CatalogWatcher]struct{}
+	cancel    func()
+	consul    *Consul
+	// only accessed by the watch handler
+	ready bool
+	last  []CatalogService
+}
+
+// CatalogWatcher receives the service list of a namespace whenever it changes
+type CatalogWatcher struct {
+	info   *catalogInfo
+	event  chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+// Catalog keeps a blocking query on the service catalog of the namespace
+func (c *Consul) Catalog() {
+	c.WatchServices(context.Background(), "")
+}
+
+// WatchServices subscribes the service list of a namespace: empty or local
+// for the current namespace, global or a namespace id
+func (c *Consul) WatchServices(ctx context.Context, namespace string) (*CatalogWatcher, error) {
+	namespace = naming.NewService(namespace, "").Namespace
+	w := &CatalogWatcher{
+		event: make(chan struct{}, 1),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	v, ok := c.catalogs[namespace]
+	if !ok {
+		v = &catalogInfo{namespace: namespace, watcher: make(map[*CatalogWatcher]struct{}), consul: c}
+		c.catalogs[namespace] = v
+		v.cancel = c.engine.Watch(c.watchKey("catalog|"+namespace), v.query, v.onChange)
+	} else if _, ok := v.services.Load().([]CatalogService); ok {
+		w.event <- struct{}{}
+	}
+	w.info = v
+	v.watcher[w] = struct{}{}
+	return w, nil
+}
+
+// Services returns the service list of a namespace by a plain query on the
+// catalog, use WatchServices to keep the list updated
+func (c *Consul) Services(ctx context.Context, namespace string) ([]CatalogService, error) {
+	namespace = naming.NewService(namespace, "").Namespace
+	services, _, err := c.catalog(ctx, namespace, 0, 0)
+	if err != nil {
+		return nil, err
+	}
+	return catalogServices(services), nil
+}
+
+// RequireServices checks that the services, like "[namespace/]name", exist
+// in the catalog, e.g. to validate declared downstream services at startup
+func (c *Consul) RequireServices(ctx context.Context, services ...string) error {
+	var missing []string
+	catalogs := make(map[string]map[string]bool)
+	for _, service := range services {
+		svc := naming.ParseService(service)
+		names, ok := catalogs[svc.Namespace]
+		if !ok {
+			list, err := c.Services(ctx, svc.Namespace)
+			if err != nil {
+				return err
+			}
+			names = make(map[string]bool, len(list))
+			for _, s := range list {
+				names[s.Name] = true
+			}
+			catalogs[svc.Namespace] = names
+		}
+		if !names[svc.Name] {
+			missing = append(missing, service)
+		}
+	}
+	if len(missing) > 0 {
+		return errors.NotFound(errors.UnknownReason, fmt.Sprintf("services not found in consul catalog: %v", missing))
+	}
+	return nil
+}
+
+func (v *catalogInfo) query(ctx context.Context, index int64, wait time.Duration) (interface{}, int64, error) {
+	services, index, err := v.consul.catalog(ctx, v.namespace, index, wait)
+	return services, index, err
+}
+
+func (v *catalogInfo) onChange(result interface{}, index int64) {
+	list := catalogServices(result.(map[string][]string))
+	if v.ready && reflect.DeepEqual(v.last, list) {
+		return
+	}
+	v.ready = true
+	v.last = list
+	v.services.Store(list)
+	v.consul.lock.RLock()
+	defer v.consul.lock.RUnlock()
+	for w := range v.watcher {
+		select {
+		case w.event <- struct{}{}:
+		default:
+		}
+	}
+}
+
+// catalogServices sorts the services and their tags
+func catalogServices(services map[string][]string) []CatalogService {
+	list := make([]CatalogService, 0, len(services))
+	for name, tags := range services {
+		tags = append([]string{}, tags...)
+		sort.Strings(tags)
+		list = append(list, CatalogService{Name: name, Tags: tags})
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name < list[j].Name })
+	return list
+}
+
+// Next blocks until the service list changes, the first call returns the
+// current list
+func (w *CatalogWatcher) Next() ([]CatalogService, error) {
+	return w.next(context.Background())
+}
+
+func (w *CatalogWatcher) next(ctx context.Context) (services []CatalogService, err error) {
+	select {
+	case <-ctx.Done():
+		err = errors.GatewayTimeout(errors.UnknownReason, ctx.Err().Error())
+	case <-w.ctx.Done():
+		err = errors.ClientClosed(errors.UnknownReason, "")
+	case <-w.event:
+		services, _ = w.info.services.Load().([]CatalogService)
+	}
+	return
+}
+
+func (w *CatalogWatcher) Stop() error {
+	select {
+	case <-w.ctx.Done():
+		return nil
+	default:
+	}
+	w.cancel()
+	c := w.info.consul
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(w.info.watcher, w)
+	if len(w.info.watcher) == 0 {
+		delete(c.catalogs, w.info.namespace)
+		w.info.cancel()
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package consul
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/hisonsoft/tsf-go/naming"
+	"github.com/hisonsoft/tsf-go/pkg/consultest"
+)
+
+func TestCatalog(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	c := New(&Config{Address: []string{srv.Addr()}})
+	register := func(id string, name string, tags ...string) {
+		ins := &naming.Instance{ID: id, Service: &naming.Service{Name: name}, Host: "127.0.0.1", Port: 9000, Tags: tags}
+		if err := c.register(ins); err != nil {
+			t.Fatalf("register failed!err:=%v", err)
+		}
+	}
+	register("user-1", "user_grpc", "v1")
+	register("user-2", "user_grpc", "canary")
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	services, err := c.Services(ctx, "")
+	if err != nil {
+		t.Fatalf("list services failed!err:=%v", err)
+	}
+	if len(services) != 1 || services[0].Name != "user_grpc" || len(services[0].Tags) != 2 || services[0].Tags[0] != "canary" {
+		t.Fatalf("unexpected services %+v", services)
+	}
+	// 普通查询不保留长轮询
+	if len(c.catalogs) != 0 || len(c.WatchStats()) != 0 {
+		t.Fatalf("expect no catalog watch left, got %d", len(c.catalogs))
+	}
+
+	w, err := c.WatchServices(ctx, "")
+	if err != nil {
+		t.Fatalf("watch services failed!err:=%v", err)
+	}
+	defer w.Stop()
+	if services, err = w.next(ctx); err != nil || len(services) != 1 {
+		t.Fatalf("expect current services, got %+v err:=%v", services, err)
+	}
+	register("order-1", "order_http")
+	if services, err = w.next(ctx); err != nil || len(services) != 2 || services[0].Name != "order_http" {
+		t.Fatalf("expect new service, got %+v err:=%v", services, err)
+	}
+
+	if err = c.RequireServices(ctx, "user_grpc", "order_http"); err != nil {
+		t.Fatalf("expect declared services exist, err:=%v", err)
+	}
+	if err = c.RequireServices(ctx, "user_grpc", "stock_grpc"); !errors.IsNotFound(err) {
+		t.Fatalf("expect missing service reported, got %v", err)
+	}
+}
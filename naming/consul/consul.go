@@ -10,7 +10,9 @@ import (
 	"github.com/hisonsoft/tsf-go/naming"
 	"github.com/hisonsoft/tsf-go/pkg/http"
 	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+	"github.com/hisonsoft/tsf-go/pkg/sys/metrics"
 	"github.com/hisonsoft/tsf-go/pkg/util"
+	"github.com/hisonsoft/tsf-go/pkg/watch"
 )
 
 var _ registry.Discovery = &Consul{}
@@ -25,28 +27,32 @@ type insInfo struct {
 }
 
 type svcInfo struct {
-	info    naming.Service
+	info naming.Service
+	// nodes is the last non-empty snapshot, latest may be empty
 	nodes   atomic.Value
+	latest  atomic.Value
 	watcher map[*Watcher]struct{}
-	cancel  context.CancelFunc
+	cancel  func()
 	consul  *Consul
+	// the last nodes broadcasted, only accessed by the watch handler
+	lastNodes []CheckServiceNode
 }
 
 func DefaultConsul() *Consul {
 	mu.Lock()
 	defer mu.Unlock()
 	if defaultConsul == nil {
-		defaultConsul = New(&Config{Address: env.ConsulAddressList(), Token: env.Token()})
+		defaultConsul = New(&Config{Address: env.ConsulAddressList(), TokenFunc: env.ConsulToken, TLS: http.ConsulTLSConfig()})
+		metrics.RegisterDiscovery("naming", defaultConsul)
 	}
 	return defaultConsul
 }
 
 func New(conf *Config) *Consul {
 	c := &Consul{
-		queryCli:  http.NewClient(http.WithTimeout(time.Second * 120)),
-		setCli:    http.NewClient(http.WithTimeout(time.Second * 30)),
 		registry:  make(map[string]*insInfo),
 		discovery: make(map[naming.Service]*svcInfo),
+		catalogs:  make(map[string]*catalogInfo),
 		bc: &util.BackoffConfig{
 			MaxDelay:  25 * time.Second,
 			BaseDelay: 500 * time.Millisecond,
@@ -55,8 +61,15 @@ func New(conf *Config) *Consul {
 		},
 		conf: conf,
 	}
-	if conf != nil && conf.Catalog {
-		go c.Catalog()
+	c.queryCli = http.NewClient(http.WithTimeout(time.Second*120), http.WithTLS(conf.TLS), http.WithHeader(c.setToken))
+	c.setCli = http.NewClient(http.WithTimeout(time.Second*30), http.WithTLS(conf.TLS), http.WithHeader(c.setToken))
+	c.endpoints = util.NewEndpoints(conf.Address, c.bc)
+	c.engine = conf.Engine
+	if c.engine == nil {
+		c.engine = watch.DefaultEngine()
+	}
+	if conf.Catalog {
+		c.Catalog()
 	}
 	return c
 }
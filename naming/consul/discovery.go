@@ -3,19 +3,22 @@ package consul
 import (
 	"context"
 	"fmt"
-	"math/rand"
 	xhttp "net/http"
+	"sort"
 	"strconv"
+	"strings"
 	"sync"
 	"time"
 
 	"github.com/go-kratos/kratos/v2/errors"
 	"github.com/go-kratos/kratos/v2/registry"
+	"github.com/hisonsoft/tsf-go/health"
 	"github.com/hisonsoft/tsf-go/log"
 	"github.com/hisonsoft/tsf-go/naming"
 	"github.com/hisonsoft/tsf-go/pkg/http"
 	"github.com/hisonsoft/tsf-go/pkg/sys/env"
 	"github.com/hisonsoft/tsf-go/pkg/util"
+	"github.com/hisonsoft/tsf-go/pkg/watch"
 )
 
 // Used to return information about a node
@@ -63,6 +66,12 @@ func (self *CheckServiceNode) compare(node *CheckServiceNode) (euqal bool) {
 		return
 	} else if self.Service.Port != node.Service.Port {
 		return
+	} else if !equalMeta(self.Service.Meta, node.Service.Meta) {
+		return
+	} else if !equalTags(self.Service.Tags, node.Service.Tags) {
+		return
+	} else if self.Checks.AggregatedStatus() != node.Checks.AggregatedStatus() {
+		return
 	}
 	euqal = true
 	return
@@ -72,14 +81,13 @@ func compareNodes(old, new []CheckServiceNode) (euqal bool) {
 	for len(old) != len(new) {
 		return
 	}
-	for _, node := range new {
-		ok := false
-		for _, oldNode := range old {
-			if oldNode.compare(&node) {
-				ok = true
-			}
-		}
-		if !ok {
+	olds := make(map[string]*CheckServiceNode, len(old))
+	for i := range old {
+		olds[old[i].Service.ID] = &old[i]
+	}
+	for i := range new {
+		oldNode, ok := olds[new[i].Service.ID]
+		if !ok || !oldNode.compare(&new[i]) {
 			return
 		}
 	}
@@ -87,8 +95,72 @@ func compareNodes(old, new []CheckServiceNode) (euqal bool) {
 	return
 }
 
+func equalMeta(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for k, v := range a {
+		if bv, ok := b[k]; !ok || bv != v {
+			return false
+		}
+	}
+	return true
+}
+
+// equalTags 标签的顺序不影响路由，按集合比较
+func equalTags(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	set := make(map[string]int, len(a))
+	for _, tag := range a {
+		set[tag]++
+	}
+	for _, tag := range b {
+		if set[tag] == 0 {
+			return false
+		}
+		set[tag]--
+	}
+	return true
+}
+
 type HealthChecks []*HealthCheck
 
+const (
+	HealthPassing  = "passing"
+	HealthWarning  = "warning"
+	HealthCritical = "critical"
+	HealthMaint    = "maintenance"
+)
+
+// AggregatedStatus returns the worst status of all checks
+func (c HealthChecks) AggregatedStatus() string {
+	var warning, critical, maintenance bool
+	for _, check := range c {
+		if check == nil {
+			continue
+		}
+		switch check.Status {
+		case HealthWarning:
+			warning = true
+		case HealthCritical:
+			critical = true
+		case HealthMaint:
+			maintenance = true
+		}
+	}
+	switch {
+	case maintenance:
+		return HealthMaint
+	case critical:
+		return HealthCritical
+	case warning:
+		return HealthWarning
+	}
+	return HealthPassing
+}
+
 // HealthCheck represents a single check on a given node
 type HealthCheck struct {
 	Node        string
@@ -152,73 +224,132 @@ type ServiceDefinition struct {
 type Config struct {
 	Address []string
 	Token   string
+	// TokenFunc returns the current acl token and overrides Token,
+	// e.g. env.ConsulToken which picks up a rotated token
+	TokenFunc func() string
+	// TLS enables https to the consul agents
+	TLS *http.TLSConfig
 	// additional message:tsf namespaceid and tencent appid if exsist
 	AppID       string
 	NamespaceID string
 
 	Catalog bool
+
+	// Health decides the status sent by heartbeats, default health.DefaultChecker()
+	Health *health.Checker
+	// HTTPCheck makes consul probe this url(e.g. served by health.Checker.Handler)
+	// instead of registering a TTL check passed by heartbeats
+	HTTPCheck string
+	// HTTPCheckInterval is the probe interval of HTTPCheck, default 10s
+	HTTPCheckInterval time.Duration
+
+	// Engine runs the blocking queries, default watch.DefaultEngine()
+	Engine *watch.Engine
 }
 
 type Consul struct {
 	queryCli  *http.Client
 	setCli    *http.Client
 	bc        *util.BackoffConfig
+	endpoints *util.Endpoints
+	engine    *watch.Engine
 	registry  map[string]*insInfo
 	discovery map[naming.Service]*svcInfo
+	catalogs  map[string]*catalogInfo
 	lock      sync.RWMutex
+	// serializes re-registrations so that runtime updates are not lost
+	updateLock sync.Mutex
 
 	conf *Config
 }
 
+// addr picks the consul agent to talk to, sticking to a healthy one and
+// failing over with backoff when it becomes unavailable
 func (c *Consul) addr() string {
-	if len(c.conf.Address) == 0 {
-		return ""
-	} else if len(c.conf.Address) == 1 {
-		return c.conf.Address[0]
+	return c.endpoints.Pick()
+}
+
+func (c *Consul) token() string {
+	if c.conf.TokenFunc != nil {
+		return c.conf.TokenFunc()
 	}
+	return c.conf.Token
+}
 
-	return c.conf.Address[rand.Intn(len(c.conf.Address))]
+// setToken sends the acl token by header, so that it is not leaked to access logs
+func (c *Consul) setToken(header xhttp.Header) {
+	if token := c.token(); token != "" {
+		header.Set("X-Consul-Token", token)
+	}
 }
 
-func (c *Consul) catalog(index int64) (services map[string]interface{}, consulIndex int64, err error) {
-	url := fmt.Sprintf("http://%s/v1/catalog/services?token=%s&wait=55s&index=%d", c.addr(), c.conf.Token, index)
+func (c *Consul) scheme() string {
+	if c.conf.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// query appends the tsf namespace and app id to the query params
+func (c *Consul) query(params ...string) string {
 	if c.conf.NamespaceID != "" {
-		url += "&nid=" + c.conf.NamespaceID
+		params = append(params, "nid="+c.conf.NamespaceID)
 	}
 	if c.conf.AppID != "" {
-		url += "&uid=" + c.conf.AppID
+		params = append(params, "uid="+c.conf.AppID)
 	}
-	defer func() {
-		if err != nil {
-			log.DefaultLog.Errorw("msg", "[naming] get catalog failed!", "url", url, "err", err)
-		}
-	}()
-	var header xhttp.Header
-	services = map[string]interface{}{}
-	header, err = c.queryCli.Get(url, &services)
-	if err != nil {
-		if errors.IsNotFound(err) {
-			err = nil
-		} else {
-			return
-		}
+	if len(params) == 0 {
+		return ""
 	}
-	if header != nil {
-		str := header.Get("X-Consul-Index")
-		consulIndex, err = strconv.ParseInt(str, 10, 64)
-		if err != nil {
-			err = errors.InternalServer(errors.UnknownReason, fmt.Sprintf("consul index invalid: %s", str))
-			return
+	return "?" + strings.Join(params, "&")
+}
+
+// watchKey identifies the blocking query of a path, only the watches of the
+// same client are coalesced by the engine: clients with different tokens or
+// tls configs may see different results
+func (c *Consul) watchKey(path string) string {
+	return fmt.Sprintf("naming|%p|%s|%s|%s|%s", c, strings.Join(c.conf.Address, ","), c.conf.NamespaceID, c.conf.AppID, path)
+}
+
+// WatchStats returns the state of the blocking queries of the watch engine
+func (c *Consul) WatchStats() []watch.Stat {
+	return c.engine.Stats()
+}
+
+// EndpointStats returns the connection state of the consul agents
+func (c *Consul) EndpointStats() []util.EndpointStat {
+	return c.endpoints.Stats()
+}
+
+// Failovers returns how many times the client switched to another agent
+func (c *Consul) Failovers() int64 {
+	return c.endpoints.Failovers()
+}
+
+// ServiceInstances returns the number of the healthy instances of each
+// discovered service
+func (c *Consul) ServiceInstances() map[string]int {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	res := make(map[string]int, len(c.discovery))
+	for svc, v := range c.discovery {
+		if snap, ok := v.nodes.Load().(*snapshot); ok {
+			res[svc.String()] = len(snap.nodes)
 		}
-	} else {
-		err = errors.InternalServer(errors.UnknownReason, "consul index invalid,no http header found!")
-		return
+	}
+	return res
+}
+
+func (c *Consul) catalog(ctx context.Context, namespace string, index int64, wait time.Duration) (services map[string][]string, consulIndex int64, err error) {
+	services = map[string][]string{}
+	url, consulIndex, err := c.get(ctx, "/v1/catalog/services", c.nsParams(namespace), index, wait, &services)
+	if err != nil && ctx.Err() == nil {
+		log.DefaultLog.Errorw("msg", "[naming] get catalog failed!", "url", url, "err", err)
 	}
 	return
 }
 
-func (c *Consul) healthService(svc naming.Service, index int64) (nodes []CheckServiceNode, consulIndex int64, err error) {
-	url := fmt.Sprintf("http://%s/v1/health/service/%s?token=%s&passing&wait=55s&index=%d", c.addr(), svc.Name, c.conf.Token, index)
+func (c *Consul) healthService(ctx context.Context, svc naming.Service, index int64, wait time.Duration) (nodes []CheckServiceNode, consulIndex int64, err error) {
 	/*if svc.NameSpace == "global" {
 		url += "&nsType=GLOBAL"
 	} else if svc.NameSpace == "all" {
@@ -227,25 +358,50 @@ func (c *Consul) healthService(svc naming.Service, index int64) (nodes []CheckSe
 	} else if svc.NameSpace == "local" {
 		url += "&nsType=DEF"
 	}*/
-	if svc.Namespace != "" && svc.Namespace != env.NamespaceID() {
-		if svc.Namespace == naming.NsGlobal {
-			url += "&nsType=GLOBAL"
+	params := append([]string{"passing"}, c.nsParams(svc.Namespace)...)
+	url, consulIndex, err := c.get(ctx, "/v1/health/service/"+svc.Name, params, index, wait, &nodes)
+	if err != nil && ctx.Err() == nil {
+		log.DefaultLog.Error("msg", "[naming] get healthService failed!", "name", svc.Name, "url", url, "err", err)
+	}
+	return
+}
+
+// nsParams returns the query params selecting namespace: global, another
+// namespace id, or the namespace of the client if it is empty or local
+func (c *Consul) nsParams(namespace string) []string {
+	var params []string
+	if namespace != "" && namespace != env.NamespaceID() {
+		if namespace == naming.NsGlobal {
+			params = append(params, "nsType=GLOBAL")
 		} else {
-			url += "&nid=" + svc.Namespace
+			params = append(params, "nid="+namespace)
 		}
 	} else if c.conf.NamespaceID != "" {
-		url += "&nid=" + c.conf.NamespaceID
+		params = append(params, "nid="+c.conf.NamespaceID)
 	}
 	if c.conf.AppID != "" {
-		url += "&uid=" + c.conf.AppID
+		params = append(params, "uid="+c.conf.AppID)
 	}
-	defer func() {
-		if err != nil {
-			log.DefaultLog.Error("msg", "[naming] get healthService failed!", "name", svc.Name, "url", url, "err", err)
-		}
-	}()
-	var header xhttp.Header
-	header, err = c.queryCli.Get(url, &nodes)
+	return params
+}
+
+// get queries path on the consul agent and returns the X-Consul-Index of the
+// result, the query blocks until the index changes if wait is positive
+func (c *Consul) get(ctx context.Context, path string, params []string, index int64, wait time.Duration, out interface{}) (url string, consulIndex int64, err error) {
+	addr := c.addr()
+	if wait > 0 {
+		params = append(params, waitParam(wait), fmt.Sprintf("index=%d", index))
+	}
+	url = fmt.Sprintf("%s://%s%s", c.scheme(), addr, path)
+	if len(params) > 0 {
+		url += "?" + strings.Join(params, "&")
+	}
+	header, err := c.queryCli.GetContext(ctx, url, out)
+	if ctx.Err() != nil {
+		// 取消订阅时中断的查询不计为agent故障
+		return url, 0, ctx.Err()
+	}
+	c.endpoints.Done(addr, err)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			err = nil
@@ -253,42 +409,29 @@ func (c *Consul) healthService(svc naming.Service, index int64) (nodes []CheckSe
 			return
 		}
 	}
-	if header != nil {
-		str := header.Get("X-Consul-Index")
-		consulIndex, err = strconv.ParseInt(str, 10, 64)
-		if err != nil {
-			err = errors.InternalServer(errors.UnknownReason, fmt.Sprintf("consul index invalid: %s", str))
-			return
-		}
-	} else {
+	if header == nil {
 		err = errors.InternalServer(errors.UnknownReason, "consul index invalid,no http header found!")
 		return
 	}
+	str := header.Get("X-Consul-Index")
+	consulIndex, err = strconv.ParseInt(str, 10, 64)
+	if err != nil {
+		err = errors.InternalServer(errors.UnknownReason, fmt.Sprintf("consul index invalid: %s", str))
+	}
 	return
 }
 
-func (c *Consul) Catalog() {
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
-	var lastIndex int64
-	retries := 0
-	for {
-		<-ticker.C
-		_, index, err := c.catalog(lastIndex)
-		if err != nil {
-			time.Sleep(c.bc.Backoff(retries))
-			retries++
-			continue
-		}
-		retries = 0
-		lastIndex = index
-	}
+// Watch subscribes a service, which may be qualified by a namespace
+// like global/user-service or <namespace id>/user-service
+func (c *Consul) Watch(ctx context.Context, service string) (registry.Watcher, error) {
+	return c.watch(service, false), nil
 }
 
-func (c *Consul) Watch(ctx context.Context, service string) (registry.Watcher, error) {
-	svc := naming.Service{Name: service}
+func (c *Consul) watch(service string, events bool) *Watcher {
+	svc := *naming.ParseService(service)
 	w := &Watcher{
-		event: make(chan struct{}, 1),
+		event:  make(chan struct{}, 1),
+		events: events,
 	}
 	w.ctx, w.cancel = context.WithCancel(context.Background())
 	c.lock.Lock()
@@ -297,29 +440,27 @@ func (c *Consul) Watch(ctx context.Context, service string) (registry.Watcher, e
 	if !ok {
 		v = c.newService(svc)
 	} else {
-		nodes, _ := v.nodes.Load().([]*registry.ServiceInstance)
-		if len(nodes) > 0 {
+		snap := v.snapshot(events)
+		if snap != nil && len(snap.nodes) > 0 {
 			// watcher初始化的时候至少一个slot，所以肯定可以非阻塞推送成功
 			w.event <- struct{}{}
 		}
 	}
 	w.svc = v
 	v.watcher[w] = struct{}{}
-	return w, nil
+	return w
 }
 
 func (c *Consul) newService(svc naming.Service) *svcInfo {
 	v := &svcInfo{watcher: make(map[*Watcher]struct{}, 0), consul: c, info: svc}
-	var ctx context.Context
-	ctx, v.cancel = context.WithCancel(context.Background())
 	c.discovery[svc] = v
-	go v.subscribe(ctx, svc)
+	v.cancel = c.engine.Watch(c.watchKey("health|"+svc.String()), v.query, v.onChange)
 	return v
 }
 
 // GetService is get service
 func (c *Consul) GetService(ctx context.Context, service string) (nodes []*registry.ServiceInstance, err error) {
-	svc := naming.Service{Name: service}
+	svc := *naming.ParseService(service)
 	c.lock.RLock()
 	v, ok := c.discovery[svc]
 	c.lock.RUnlock()
@@ -331,56 +472,38 @@ func (c *Consul) GetService(ctx context.Context, service string) (nodes []*regis
 		c.lock.Unlock()
 		return
 	}
-	nodes, ok = v.nodes.Load().([]*registry.ServiceInstance)
+	snap, ok := v.nodes.Load().(*snapshot)
 	if !ok {
 		return nil, fmt.Errorf("not found ")
 	}
+	nodes = snap.nodes
 	return
 }
 
-func (s *svcInfo) subscribe(ctx context.Context, svc naming.Service) {
-	var (
-		lastNodes []CheckServiceNode
-		lastIndex int64
-		err       error
-	)
+func (s *svcInfo) query(ctx context.Context, index int64, wait time.Duration) (interface{}, int64, error) {
+	nodes, index, err := s.consul.healthService(ctx, s.info, index, wait)
+	return nodes, index, err
+}
 
-	lastNodes, lastIndex, err = s.consul.healthService(svc, lastIndex)
-	if err == nil && len(lastNodes) > 0 {
-		s.broadcast(lastNodes)
-	}
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
-	retries := 0
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			nodes, index, err := s.
-				consul.healthService(svc, lastIndex)
-			if err != nil {
-				time.Sleep(s.consul.bc.Backoff(retries))
-				retries++
-				continue
-			}
-			if len(nodes) != 0 {
-				if index != lastIndex || !compareNodes(lastNodes, nodes) {
-					lastNodes = nodes
-					s.broadcast(nodes)
-				}
-			}
-			retries = 0
-			lastIndex = index
-		}
+func (s *svcInfo) onChange(result interface{}, index int64) {
+	nodes := result.([]CheckServiceNode)
+	if compareNodes(s.lastNodes, nodes) {
+		return
 	}
+	s.lastNodes = nodes
+	s.broadcast(nodes, index)
 }
 
-func (s *svcInfo) broadcast(nodes []CheckServiceNode) {
-	s.store(nodes)
+// broadcast 实例列表为空时可能是注册中心异常，Next不清空已有的实例，
+// 只通知NextEvents的订阅者实例已移除
+func (s *svcInfo) broadcast(nodes []CheckServiceNode, index int64) {
+	s.store(nodes, index)
 	s.consul.lock.RLock()
 	defer s.consul.lock.RUnlock()
 	for k := range s.watcher {
+		if len(nodes) == 0 && !k.events {
+			continue
+		}
 		select {
 		case k.event <- struct{}{}:
 		default:
@@ -388,42 +511,159 @@ func (s *svcInfo) broadcast(nodes []CheckServiceNode) {
 	}
 }
 
-func (s *svcInfo) store(nodes []CheckServiceNode) {
+func (s *svcInfo) store(nodes []CheckServiceNode, index int64) {
 	var inss []*registry.ServiceInstance
 	for _, node := range nodes {
+		status, _ := strconv.ParseInt(node.Service.Meta[naming.StatusKey], 10, 64)
+		if status == naming.StatusDown {
+			// 手动下线的实例不再参与路由
+			continue
+		}
 		var ins = naming.Instance{
 			Service:  naming.NewService(node.Service.Meta[naming.NamespaceID], node.Service.Service),
 			ID:       node.Service.ID,
 			Host:     node.Service.Address,
 			Port:     node.Service.Port,
 			Metadata: node.Service.Meta,
-			Status:   naming.StatusUp,
+			Status:   status,
 		}
 
 		inss = append(inss, ins.ToKratosInstance())
 	}
-	s.nodes.Store(inss)
+	snap := &snapshot{nodes: inss, index: index}
+	s.latest.Store(snap)
+	if len(nodes) > 0 {
+		s.nodes.Store(snap)
+	}
+}
+
+// snapshot returns the latest instances for NextEvents, otherwise the last
+// non-empty ones
+func (s *svcInfo) snapshot(latest bool) *snapshot {
+	if latest {
+		snap, _ := s.latest.Load().(*snapshot)
+		return snap
+	}
+	snap, _ := s.nodes.Load().(*snapshot)
+	return snap
+}
+
+// snapshot is the instance list of a service at a consul index
+type snapshot struct {
+	nodes []*registry.ServiceInstance
+	index int64
+}
+
+// EventType is the kind of change of a service instance
+type EventType int
+
+const (
+	// EventAdded means the instance is newly discovered
+	EventAdded EventType = iota
+	// EventRemoved means the instance is gone or no longer healthy
+	EventRemoved
+	// EventUpdated means the metadata, tags or status of the instance changed
+	EventUpdated
+)
+
+func (t EventType) String() string {
+	switch t {
+	case EventAdded:
+		return "added"
+	case EventRemoved:
+		return "removed"
+	case EventUpdated:
+		return "updated"
+	}
+	return "unknown"
+}
+
+// Event is a change of a single service instance
+type Event struct {
+	Type EventType
+	// Instance is the current instance, or the last seen one for EventRemoved
+	Instance *registry.ServiceInstance
+	// Old is the previous instance for EventUpdated
+	Old *registry.ServiceInstance
+	// Index is the consul index the change was observed at
+	Index int64
 }
 
 type Watcher struct {
 	event chan struct{}
 	svc   *svcInfo
+	// events is true if the watcher is consumed by NextEvents
+	events bool
+	// instances delivered by NextEvents
+	last map[string]*registry.ServiceInstance
 	// for cancel
 	ctx    context.Context
 	cancel context.CancelFunc
 }
 
+// WatchEvents is like Watch but returns a watcher whose NextEvents yields
+// the diffs between successive instance lists.
+func (c *Consul) WatchEvents(ctx context.Context, service string) (*Watcher, error) {
+	return c.watch(service, true), nil
+}
+
 func (w *Watcher) Next() (nodes []*registry.ServiceInstance, err error) {
 	select {
 	case <-w.ctx.Done():
 		err = errors.ClientClosed(errors.UnknownReason, "")
 		return
 	case <-w.event:
-		nodes = w.svc.nodes.Load().([]*registry.ServiceInstance)
+		nodes = w.svc.nodes.Load().(*snapshot).nodes
+	}
+	return
+}
+
+// NextEvents blocks until the instance list changes and returns what
+// changed since the previous call; the first call reports every instance
+// as added. Unlike Next, the instances are reported removed even if none is
+// left. It must be called on the watcher returned by WatchEvents.
+func (w *Watcher) NextEvents() (events []Event, err error) {
+	for len(events) == 0 {
+		select {
+		case <-w.ctx.Done():
+			err = errors.ClientClosed(errors.UnknownReason, "")
+			return
+		case <-w.event:
+		}
+		events = w.diff(w.svc.snapshot(true))
+	}
+	return
+}
+
+func (w *Watcher) diff(snap *snapshot) (events []Event) {
+	current := make(map[string]*registry.ServiceInstance, len(snap.nodes))
+	for _, node := range snap.nodes {
+		current[node.ID] = node
+		old, ok := w.last[node.ID]
+		if !ok {
+			events = append(events, Event{Type: EventAdded, Instance: node, Index: snap.index})
+		} else if !equalInstance(old, node) {
+			events = append(events, Event{Type: EventUpdated, Instance: node, Old: old, Index: snap.index})
+		}
 	}
+	var removed []string
+	for id := range w.last {
+		if _, ok := current[id]; !ok {
+			removed = append(removed, id)
+		}
+	}
+	sort.Strings(removed)
+	for _, id := range removed {
+		events = append(events, Event{Type: EventRemoved, Instance: w.last[id], Index: snap.index})
+	}
+	w.last = current
 	return
 }
 
+func equalInstance(a, b *registry.ServiceInstance) bool {
+	return a.Name == b.Name && a.Version == b.Version && equalTags(a.Endpoints, b.Endpoints) && equalMeta(a.Metadata, b.Metadata)
+}
+
 func (w *Watcher) Stop() error {
 	select {
 	case <-w.ctx.Done():
@@ -441,6 +681,15 @@ func (w *Watcher) Stop() error {
 	return nil
 }
 
+// waitParam returns the blocking time param of a query in seconds
+func waitParam(wait time.Duration) string {
+	secs := int(wait / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return fmt.Sprintf("wait=%ds", secs)
+}
+
 func uniName(svc naming.Service) string {
 	return fmt.Sprintf("%s/%s", svc.Namespace, svc.Name)
 }
@@ -0,0 +1,186 @@
+package consul
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/naming"
+	"github.com/hisonsoft/tsf-go/pkg/consultest"
+	"github.com/hisonsoft/tsf-go/pkg/watch"
+)
+
+func TestCompareNodes(t *testing.T) {
+	node := func(id string, weight string, status string) CheckServiceNode {
+		return CheckServiceNode{
+			Service: &NodeService{ID: id, Address: "127.0.0.1", Port: 8080, Meta: map[string]string{"weight": weight}},
+			Checks:  HealthChecks{{Status: status}},
+		}
+	}
+	old := []CheckServiceNode{node("a", "10", HealthPassing), node("b", "10", HealthPassing)}
+	if !compareNodes(old, []CheckServiceNode{node("b", "10", HealthPassing), node("a", "10", HealthPassing)}) {
+		t.Fatalf("expect equal regardless of order")
+	}
+	if compareNodes(old, []CheckServiceNode{node("a", "10", HealthPassing), node("b", "20", HealthPassing)}) {
+		t.Fatalf("expect metadata change detected")
+	}
+	if compareNodes(old, []CheckServiceNode{node("a", "10", HealthPassing), node("b", "10", HealthWarning)}) {
+		t.Fatalf("expect status change detected")
+	}
+	tagged := func(tags ...string) []CheckServiceNode {
+		n := node("a", "10", HealthPassing)
+		n.Service.Tags = tags
+		return []CheckServiceNode{n}
+	}
+	if !compareNodes(tagged("v1", "canary"), tagged("canary", "v1")) {
+		t.Fatalf("expect equal regardless of tag order")
+	}
+	if compareNodes(tagged("v1", "v1"), tagged("v1", "canary")) {
+		t.Fatalf("expect tag change detected")
+	}
+}
+
+func TestWatchEvents(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	c := New(&Config{Address: []string{srv.Addr()}})
+
+	newIns := func(id string, weight string) *naming.Instance {
+		return &naming.Instance{
+			ID:       id,
+			Service:  &naming.Service{Name: "provider_grpc"},
+			Host:     "127.0.0.1",
+			Port:     9000,
+			Metadata: map[string]string{"weight": weight},
+		}
+	}
+	for _, ins := range []*naming.Instance{newIns("a", "10"), newIns("b", "10")} {
+		if err := c.registerIns(ins); err != nil {
+			t.Fatalf("register failed!err:=%v", err)
+		}
+	}
+	w, err := c.WatchEvents(context.Background(), "provider_grpc")
+	if err != nil {
+		t.Fatalf("watch failed!err:=%v", err)
+	}
+	defer w.Stop()
+
+	var events []Event
+	for len(events) < 2 {
+		events = append(events, nextEvents(t, w)...)
+	}
+	for _, e := range events {
+		if e.Type != EventAdded || e.Index == 0 {
+			t.Fatalf("expect added event with index, got %+v", e)
+		}
+	}
+
+	if err = c.register(newIns("b", "20")); err != nil {
+		t.Fatalf("update failed!err:=%v", err)
+	}
+	events = nextEvents(t, w)
+	if len(events) != 1 || events[0].Type != EventUpdated || events[0].Instance.Metadata["weight"] != "20" || events[0].Old.Metadata["weight"] != "10" {
+		t.Fatalf("expect weight update event, got %+v", events)
+	}
+
+	if err = c.deregister(newIns("a", "10")); err != nil {
+		t.Fatalf("deregister failed!err:=%v", err)
+	}
+	events = nextEvents(t, w)
+	if len(events) != 1 || events[0].Type != EventRemoved || events[0].Instance.ID != "a" {
+		t.Fatalf("expect removed event, got %+v", events)
+	}
+
+	// 最后一个实例下线时同样通知
+	if err = c.deregister(newIns("b", "20")); err != nil {
+		t.Fatalf("deregister failed!err:=%v", err)
+	}
+	events = nextEvents(t, w)
+	if len(events) != 1 || events[0].Type != EventRemoved || events[0].Instance.ID != "b" {
+		t.Fatalf("expect last instance removed, got %+v", events)
+	}
+	// Next不清空已有的实例
+	nodes, err := c.GetService(context.Background(), "provider_grpc")
+	if err != nil || len(nodes) != 1 || nodes[0].ID != "b" {
+		t.Fatalf("expect last non-empty instances kept, got %+v err:=%v", nodes, err)
+	}
+}
+
+func nextEvents(t *testing.T, w *Watcher) []Event {
+	res := make(chan []Event, 1)
+	go func() {
+		events, _ := w.NextEvents()
+		res <- events
+	}()
+	select {
+	case events := <-res:
+		return events
+	case <-time.After(time.Second * 5):
+		t.Fatalf("wait discovery event timeout")
+	}
+	return nil
+}
+
+func TestWatchNamespace(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	for _, ns := range []string{naming.NsGlobal, "ns-other"} {
+		provider := New(&Config{Address: []string{srv.Addr()}, NamespaceID: ns})
+		ins := &naming.Instance{ID: "user-" + ns, Service: &naming.Service{Name: "user-service"}, Host: "127.0.0.1", Port: 9000}
+		if err := provider.registerIns(ins); err != nil {
+			t.Fatalf("register failed!err:=%v", err)
+		}
+		defer provider.deregisterIns(ins)
+	}
+
+	c := New(&Config{Address: []string{srv.Addr()}})
+	for _, target := range []string{"global/user-service", "ns-other/user-service"} {
+		w, err := c.WatchEvents(context.Background(), target)
+		if err != nil {
+			t.Fatalf("watch failed!err:=%v", err)
+		}
+		events := nextEvents(t, w)
+		w.Stop()
+		svc := naming.ParseService(target)
+		if len(events) != 1 || events[0].Instance.ID != "user-"+svc.Namespace {
+			t.Fatalf("target %s expect instance of namespace %s, got %+v", target, svc.Namespace, events)
+		}
+	}
+}
+
+func TestWatchCancel(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	engine := watch.New(watch.Config{})
+	c := New(&Config{Address: []string{srv.Addr()}, Engine: engine})
+	ins := &naming.Instance{ID: "user-1", Service: &naming.Service{Name: "user-service"}, Host: "127.0.0.1", Port: 9000}
+	if err := c.registerIns(ins); err != nil {
+		t.Fatalf("register failed!err:=%v", err)
+	}
+	defer c.deregisterIns(ins)
+
+	w, err := c.WatchEvents(context.Background(), "user-service")
+	if err != nil {
+		t.Fatalf("watch failed!err:=%v", err)
+	}
+	nextEvents(t, w)
+	// 等待下一次阻塞查询开始
+	deadline := time.Now().Add(5 * time.Second)
+	for engine.InFlight() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	w.Stop()
+	// 取消订阅后正在阻塞的查询立即中断，而不是等到阻塞超时
+	deadline = time.Now().Add(2 * time.Second)
+	for engine.InFlight() > 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := engine.InFlight(); n != 0 {
+		t.Fatalf("expect blocking query aborted, %d in flight", n)
+	}
+	for _, e := range c.EndpointStats() {
+		if e.Errors != 0 {
+			t.Fatalf("expect aborted query not counted as agent failure, got %+v", e)
+		}
+	}
+}
@@ -3,10 +3,14 @@ package consul
 import (
 	"context"
 	"fmt"
+	"net/url"
+	"sort"
+	"strconv"
 	"time"
 
 	"github.com/go-kratos/kratos/v2/errors"
 	"github.com/go-kratos/kratos/v2/registry"
+	"github.com/hisonsoft/tsf-go/health"
 	"github.com/hisonsoft/tsf-go/log"
 	"github.com/hisonsoft/tsf-go/naming"
 )
@@ -28,10 +32,11 @@ func (c *Consul) registerIns(ins *naming.Instance) (err error) {
 		return
 	}
 	ctx, cancel := context.WithCancel(context.Background())
-	c.registry[ins.ID] = &insInfo{
+	info := &insInfo{
 		ins:    ins,
 		cancel: cancel,
 	}
+	c.registry[ins.ID] = info
 	c.lock.Unlock()
 
 	err = c.register(ins)
@@ -53,8 +58,13 @@ func (c *Consul) registerIns(ins *naming.Instance) (err error) {
 				if err != nil {
 					if errors.IsNotFound(err) || errors.IsInternalServer(err) {
 						time.Sleep(time.Millisecond * 500)
-						// 如果注册中心报错500或者404，则重新注册
-						err = c.register(ins)
+						// 如果注册中心报错500或者404，则使用最新的实例信息重新注册
+						c.updateLock.Lock()
+						c.lock.RLock()
+						cur := info.ins
+						c.lock.RUnlock()
+						err = c.register(cur)
+						c.updateLock.Unlock()
 					}
 					if err != nil {
 						timer.Reset(c.bc.Backoff(retries))
@@ -70,9 +80,78 @@ func (c *Consul) registerIns(ins *naming.Instance) (err error) {
 	return
 }
 
+// Update changes the registered instances at runtime: f is applied to a copy
+// of each instance which then replaces the registration atomically, the
+// health check status and heartbeats are kept.
+func (c *Consul) Update(ctx context.Context, f func(ins *naming.Instance)) (err error) {
+	c.updateLock.Lock()
+	defer c.updateLock.Unlock()
+	c.lock.RLock()
+	infos := make([]*insInfo, 0, len(c.registry))
+	for _, info := range c.registry {
+		infos = append(infos, info)
+	}
+	c.lock.RUnlock()
+	for _, info := range infos {
+		c.lock.RLock()
+		ins := info.ins.Clone()
+		c.lock.RUnlock()
+		f(ins)
+		if err = c.register(ins); err != nil {
+			return
+		}
+		c.lock.Lock()
+		info.ins = ins
+		c.lock.Unlock()
+	}
+	return
+}
+
+// SetMetadata updates the metadata of the registered instances, an empty
+// value deletes the key
+func (c *Consul) SetMetadata(ctx context.Context, md map[string]string) error {
+	return c.Update(ctx, func(ins *naming.Instance) {
+		for k, v := range md {
+			if v == "" {
+				delete(ins.Metadata, k)
+			} else {
+				ins.Metadata[k] = v
+			}
+		}
+	})
+}
+
+// SetTags replaces the tags of the registered instances
+func (c *Consul) SetTags(ctx context.Context, tags []string) error {
+	return c.Update(ctx, func(ins *naming.Instance) {
+		ins.Tags = append([]string(nil), tags...)
+	})
+}
+
+// SetStatus switches the registered instances to naming.StatusDown or
+// naming.StatusUp; down instances are filtered by discovery clients while
+// the process keeps running and serving in-flight requests.
+func (c *Consul) SetStatus(ctx context.Context, status int64) error {
+	return c.Update(ctx, func(ins *naming.Instance) {
+		ins.Status = status
+	})
+}
+
+// Instances returns the instances registered by this client
+func (c *Consul) Instances() []*naming.Instance {
+	c.lock.RLock()
+	defer c.lock.RUnlock()
+	inss := make([]*naming.Instance, 0, len(c.registry))
+	for _, info := range c.registry {
+		inss = append(inss, info.ins.Clone())
+	}
+	sort.Slice(inss, func(i, j int) bool { return inss[i].ID < inss[j].ID })
+	return inss
+}
+
 func (c *Consul) Deregister(ctx context.Context, ki *registry.ServiceInstance) (err error) {
 	for _, ins := range naming.FromKratosInstance(ki) {
-		err := c.deregister(ins)
+		err := c.deregisterIns(ins)
 		if err != nil {
 			return err
 		}
@@ -82,22 +161,28 @@ func (c *Consul) Deregister(ctx context.Context, ki *registry.ServiceInstance) (
 
 func (c *Consul) deregisterIns(ins *naming.Instance) (err error) {
 	log.DefaultLog.Infow("msg", "deregister service!", "svc", ins.Service.Name)
-	c.lock.RLock()
+	c.lock.Lock()
 	v, ok := c.registry[ins.ID]
-	c.lock.RUnlock()
+	delete(c.registry, ins.ID)
+	c.lock.Unlock()
 	if ok && v != nil {
+		// 先停止心跳，避免心跳失败后又重新注册
 		v.cancel()
 	}
-	c.deregister(ins)
-	return
+	return c.deregister(ins)
 }
 
 func (c *Consul) register(ins *naming.Instance) (err error) {
+	meta := make(map[string]string, len(ins.Metadata)+1)
+	for k, v := range ins.Metadata {
+		meta[k] = v
+	}
+	meta[naming.StatusKey] = strconv.FormatInt(ins.Status, 10)
 	sd := &ServiceDefinition{
 		ID:      ins.ID,
 		Name:    ins.Service.Name,
 		Address: ins.Host,
-		Meta:    ins.Metadata,
+		Meta:    meta,
 		Port:    ins.Port,
 		Check: CheckType{
 			CheckID: checkID(ins),
@@ -105,17 +190,25 @@ func (c *Consul) register(ins *naming.Instance) (err error) {
 		},
 		Tags: ins.Tags,
 	}
+	if c.conf.HTTPCheck != "" {
+		interval := c.conf.HTTPCheckInterval
+		if interval == 0 {
+			interval = time.Second * 10
+		}
+		sd.Check = CheckType{
+			CheckID:  checkID(ins),
+			HTTP:     c.conf.HTTPCheck,
+			Interval: interval,
+			Timeout:  time.Second * 5,
+		}
+	}
 	/*for k, v := range ins.Metadata {
 		sd.Tags = append(sd.Tags, k+"="+v)
 	}*/
-	url := fmt.Sprintf("http://%s/v1/agent/service/register?token=%s", c.addr(), c.conf.Token)
-	if c.conf.NamespaceID != "" {
-		url += "&nid=" + c.conf.NamespaceID
-	}
-	if c.conf.AppID != "" {
-		url += "&uid=" + c.conf.AppID
-	}
+	addr := c.addr()
+	url := fmt.Sprintf("%s://%s/v1/agent/service/register%s", c.scheme(), addr, c.query())
 	err = c.setCli.Put(url, sd, nil)
+	c.endpoints.Done(addr, err)
 	if err != nil {
 		log.DefaultLog.Errorw("msg", "[naming] register instance to consul failed!", "instance", sd, "url", url, "err", err)
 	} else {
@@ -125,29 +218,38 @@ func (c *Consul) register(ins *naming.Instance) (err error) {
 }
 
 func (c *Consul) heartBeat(ins *naming.Instance) (err error) {
-	url := fmt.Sprintf("http://%s/v1/agent/check/pass/%s?token=%s", c.addr(), checkID(ins), c.conf.Token)
-	if c.conf.NamespaceID != "" {
-		url += "&nid=" + c.conf.NamespaceID
+	if c.conf.HTTPCheck != "" {
+		// consul主动探测应用的健康检查接口，无需心跳
+		return
+	}
+	checker := c.conf.Health
+	if checker == nil {
+		checker = health.DefaultChecker()
 	}
-	if c.conf.AppID != "" {
-		url += "&uid=" + c.conf.AppID
+	res := checker.Check(context.Background())
+	// 服务发现只返回passing的实例，warn会与fail一样摘除实例，
+	// 非关键探针失败时仍上报pass，失败原因记录在note中
+	status := "pass"
+	if res.Status == health.StatusCritical {
+		status = "fail"
 	}
+	addr := c.addr()
+	url := fmt.Sprintf("%s://%s/v1/agent/check/%s/%s%s", c.scheme(), addr, status, checkID(ins), c.query("note="+url.QueryEscape(res.Output())))
 	err = c.setCli.Put(url, nil, nil)
+	c.endpoints.Done(addr, err)
 	if err != nil {
 		log.DefaultLog.Errorw("msg", "[naming] send heartbeat to consul failed!", "id", ins.ID, "url", url, "err", err)
+	} else if res.Status != health.StatusPassing {
+		log.DefaultLog.Warnw("msg", "[naming] application unhealthy, heartbeat not passing!", "id", ins.ID, "status", res.Status.String(), "output", res.Output())
 	}
 	return
 }
 
 func (c *Consul) deregister(ins *naming.Instance) (err error) {
-	url := fmt.Sprintf("http://%s/v1/agent/service/deregister/%s?token=%s", c.addr(), ins.ID, c.conf.Token)
-	if c.conf.NamespaceID != "" {
-		url += "&nid=" + c.conf.NamespaceID
-	}
-	if c.conf.AppID != "" {
-		url += "&uid=" + c.conf.AppID
-	}
+	addr := c.addr()
+	url := fmt.Sprintf("%s://%s/v1/agent/service/deregister/%s%s", c.scheme(), addr, ins.ID, c.query())
 	err = c.setCli.Put(url, nil, nil)
+	c.endpoints.Done(addr, err)
 	if err != nil {
 		log.DefaultLog.Errorw("msg", "[naming] deregister ins to consul failed!", "id", ins.ID, "url", url, "err", err)
 	}
@@ -0,0 +1,222 @@
+package consul
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/health"
+	"github.com/hisonsoft/tsf-go/naming"
+	"github.com/hisonsoft/tsf-go/pkg/consultest"
+)
+
+func TestHeartBeatHealth(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	checker := health.New()
+	c := New(&Config{Address: []string{srv.Addr()}, Health: checker})
+	ins := &naming.Instance{ID: "provider-1", Service: &naming.Service{Name: "provider_grpc"}, Host: "127.0.0.1", Port: 9000}
+	if err := c.register(ins); err != nil {
+		t.Fatalf("register failed!err:=%v", err)
+	}
+
+	var probeErr error
+	for _, tc := range []struct {
+		err    error
+		opts   []health.ProbeOption
+		expect string
+	}{
+		{nil, nil, consultest.StatusPassing},
+		// 非关键探针失败时实例仍可被发现
+		{errors.New("cache cold"), []health.ProbeOption{health.NonCritical()}, consultest.StatusPassing},
+		{errors.New("db unreachable"), nil, consultest.StatusCritical},
+	} {
+		probeErr = tc.err
+		checker.Register("db", func(ctx context.Context) error { return probeErr }, tc.opts...)
+		if err := c.heartBeat(ins); err != nil {
+			t.Fatalf("heartbeat failed!err:=%v", err)
+		}
+		if status, _ := srv.CheckStatus(ins.ID); status != tc.expect {
+			t.Fatalf("expect check %s, got %s", tc.expect, status)
+		}
+	}
+}
+
+func TestHTTPCheck(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	checker := health.New()
+	checker.Register("cache", func(ctx context.Context) error { return errors.New("cold") }, health.NonCritical())
+	hsrv := httptest.NewServer(checker.Handler())
+	defer hsrv.Close()
+
+	c := New(&Config{Address: []string{srv.Addr()}, HTTPCheck: hsrv.URL, HTTPCheckInterval: time.Millisecond * 100})
+	ins := &naming.Instance{ID: "provider-2", Service: &naming.Service{Name: "provider_http"}, Host: "127.0.0.1", Port: 8000}
+	if err := c.registerIns(ins); err != nil {
+		t.Fatalf("register failed!err:=%v", err)
+	}
+	defer c.deregisterIns(ins)
+	for i := 0; i < 50; i++ {
+		if status, _ := srv.CheckStatus(ins.ID); status == consultest.StatusPassing {
+			return
+		}
+		time.Sleep(time.Millisecond * 100)
+	}
+	t.Fatalf("expect http check passing while only non-critical probes fail")
+}
+
+func TestEndpointFailover(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	dead := consultest.NewServer()
+	deadAddr := dead.Addr()
+	dead.Close()
+
+	c := New(&Config{Address: []string{deadAddr, srv.Addr()}})
+	ins := &naming.Instance{ID: "provider-3", Service: &naming.Service{Name: "provider_grpc"}, Host: "127.0.0.1", Port: 9000}
+	var err error
+	for i := 0; i < 2; i++ {
+		if err = c.register(ins); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		t.Fatalf("register failed!err:=%v", err)
+	}
+	if len(srv.Instances("", "provider_grpc")) != 1 {
+		t.Fatalf("expect instance registered on healthy agent")
+	}
+	for i := 0; i < 3; i++ {
+		if err = c.heartBeat(ins); err != nil {
+			t.Fatalf("heartbeat should stick to healthy agent!err:=%v", err)
+		}
+	}
+	for _, stat := range c.EndpointStats() {
+		if stat.Addr == deadAddr && stat.Current {
+			t.Fatalf("expect dead agent not current, got %+v", stat)
+		}
+	}
+}
+
+func TestTokenRotation(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	srv.SetToken("token-1")
+
+	var mu sync.Mutex
+	token := "token-1"
+	c := New(&Config{Address: []string{srv.Addr()}, TokenFunc: func() string {
+		mu.Lock()
+		defer mu.Unlock()
+		return token
+	}})
+	ins := &naming.Instance{ID: "provider-4", Service: &naming.Service{Name: "provider_grpc"}, Host: "127.0.0.1", Port: 9000}
+	if err := c.register(ins); err != nil {
+		t.Fatalf("register failed!err:=%v", err)
+	}
+
+	// token轮换后无需重建client
+	srv.SetToken("token-2")
+	if err := c.heartBeat(ins); err == nil {
+		t.Fatalf("expect heartbeat rejected by stale token")
+	}
+	mu.Lock()
+	token = "token-2"
+	mu.Unlock()
+	if err := c.heartBeat(ins); err != nil {
+		t.Fatalf("heartbeat failed!err:=%v", err)
+	}
+}
+
+func TestUpdateMetadata(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	c := New(&Config{Address: []string{srv.Addr()}})
+	ins := &naming.Instance{ID: "provider-5", Service: &naming.Service{Name: "provider_grpc"}, Host: "127.0.0.1", Port: 9000, Metadata: map[string]string{"weight": "10"}}
+	if err := c.registerIns(ins); err != nil {
+		t.Fatalf("register failed!err:=%v", err)
+	}
+	defer c.deregisterIns(ins)
+	w, err := c.WatchEvents(context.Background(), "provider_grpc")
+	if err != nil {
+		t.Fatalf("watch failed!err:=%v", err)
+	}
+	defer w.Stop()
+	if events := nextEvents(t, w); len(events) != 1 || events[0].Type != EventAdded {
+		t.Fatalf("expect added event, got %+v", events)
+	}
+
+	if err = c.SetMetadata(context.Background(), map[string]string{"weight": "20", "maintenance": "true"}); err != nil {
+		t.Fatalf("update metadata failed!err:=%v", err)
+	}
+	events := nextEvents(t, w)
+	if len(events) != 1 || events[0].Type != EventUpdated || events[0].Instance.Metadata["weight"] != "20" || events[0].Instance.Metadata["maintenance"] != "true" {
+		t.Fatalf("expect metadata update event, got %+v", events)
+	}
+	// 重新注册不影响健康状态，原始实例信息不被修改
+	if status, _ := srv.CheckStatus(ins.ID); status != consultest.StatusPassing {
+		t.Fatalf("expect check passing after update, got %s", status)
+	}
+	if ins.Metadata["weight"] != "10" {
+		t.Fatalf("expect original instance untouched, got %+v", ins.Metadata)
+	}
+
+	if err = c.SetMetadata(context.Background(), map[string]string{"maintenance": ""}); err != nil {
+		t.Fatalf("update metadata failed!err:=%v", err)
+	}
+	events = nextEvents(t, w)
+	if _, ok := events[0].Instance.Metadata["maintenance"]; len(events) != 1 || ok {
+		t.Fatalf("expect maintenance flag deleted, got %+v", events)
+	}
+	if inss := c.Instances(); len(inss) != 1 || inss[0].Metadata["weight"] != "20" {
+		t.Fatalf("unexpected registered instances %+v", inss)
+	}
+}
+
+func TestMarkDown(t *testing.T) {
+	srv := consultest.NewServer()
+	defer srv.Close()
+	providers := make([]*Consul, 2)
+	for i := range providers {
+		providers[i] = New(&Config{Address: []string{srv.Addr()}})
+		ins := &naming.Instance{ID: fmt.Sprintf("provider-down-%d", i), Service: &naming.Service{Name: "provider_grpc"}, Host: "127.0.0.1", Port: 9000 + i}
+		if err := providers[i].registerIns(ins); err != nil {
+			t.Fatalf("register failed!err:=%v", err)
+		}
+		defer providers[i].deregisterIns(ins)
+	}
+	c := New(&Config{Address: []string{srv.Addr()}})
+	w, err := c.WatchEvents(context.Background(), "provider_grpc")
+	if err != nil {
+		t.Fatalf("watch failed!err:=%v", err)
+	}
+	defer w.Stop()
+	var events []Event
+	for len(events) < 2 {
+		events = append(events, nextEvents(t, w)...)
+	}
+
+	if err = providers[0].SetStatus(context.Background(), naming.StatusDown); err != nil {
+		t.Fatalf("mark down failed!err:=%v", err)
+	}
+	events = nextEvents(t, w)
+	if len(events) != 1 || events[0].Type != EventRemoved || events[0].Instance.ID != "provider-down-0" {
+		t.Fatalf("expect down instance filtered, got %+v", events)
+	}
+	// 下线的实例仍然保持注册和心跳
+	if status, _ := srv.CheckStatus("provider-down-0"); status != consultest.StatusPassing {
+		t.Fatalf("expect check passing after mark down, got %s", status)
+	}
+
+	if err = providers[0].SetStatus(context.Background(), naming.StatusUp); err != nil {
+		t.Fatalf("mark up failed!err:=%v", err)
+	}
+	events = nextEvents(t, w)
+	if len(events) != 1 || events[0].Type != EventAdded || events[0].Instance.ID != "provider-down-0" {
+		t.Fatalf("expect instance back online, got %+v", events)
+	}
+}
@@ -14,6 +14,10 @@ import (
 const (
 	StatusUp   = 0
 	StatusDown = 1
+	// StatusKey is the metadata key of the instance status
+	StatusKey = "tsf_status"
+	// SecureKey is "true" in the metadata if the instance serves TLS
+	SecureKey = "secure"
 
 	GroupID       = "TSF_GROUP_ID"
 	NamespaceID   = "TSF_NAMESPACE_ID"
@@ -37,6 +41,24 @@ func NewService(namespace string, name string) *Service {
 	return &Service{Namespace: namespace, Name: name}
 }
 
+// ParseService 解析服务发现的目标"[namespace/]name"，namespace可以是
+// global、local或者命名空间ID，例如 discovery:///global/user-service
+func ParseService(target string) *Service {
+	target = strings.Trim(target, "/")
+	if strs := strings.SplitN(target, "/", 2); len(strs) == 2 {
+		return NewService(strs[0], strs[1])
+	}
+	return NewService("", target)
+}
+
+// String returns the discovery target of the service
+func (s Service) String() string {
+	if s.Namespace == "" || s.Namespace == env.NamespaceID() {
+		return s.Name
+	}
+	return s.Namespace + "/" + s.Name
+}
+
 // Instance 服务实例信息
 type Instance struct {
 	// 服务信息
@@ -57,6 +79,22 @@ type Instance struct {
 	Tags []string `json:"tags"`
 }
 
+// Clone returns a copy of the instance which can be modified without
+// affecting the original one
+func (i *Instance) Clone() *Instance {
+	ins := *i
+	if i.Service != nil {
+		svc := *i.Service
+		ins.Service = &svc
+	}
+	ins.Metadata = make(map[string]string, len(i.Metadata))
+	for k, v := range i.Metadata {
+		ins.Metadata[k] = v
+	}
+	ins.Tags = append([]string(nil), i.Tags...)
+	return &ins
+}
+
 func (i Instance) Addr() string {
 	return i.Host + ":" + strconv.FormatInt(int64(i.Port), 10)
 }
@@ -66,7 +104,7 @@ func (i Instance) ToKratosInstance() *registry.ServiceInstance {
 	for k, v := range i.Metadata {
 		metadata[k] = v
 	}
-	metadata["tsf_status"] = strconv.FormatInt(i.Status, 10)
+	metadata[StatusKey] = strconv.FormatInt(i.Status, 10)
 	tags, _ := json.Marshal(i.Tags)
 	metadata["tsf_tags"] = string(tags)
 	protocol := metadata["protocol"]
@@ -80,13 +118,17 @@ func (i Instance) ToKratosInstance() *registry.ServiceInstance {
 		Metadata:  metadata,
 		Endpoints: []string{fmt.Sprintf("%s://%s:%d", protocol, i.Host, i.Port)},
 	}
+	if metadata[SecureKey] == "true" {
+		// kratos 的客户端开启TLS时只选择 isSecure 的节点
+		ki.Endpoints[0] += "?isSecure=true"
+	}
 	return ki
 }
 
 func FromKratosInstance(ki *registry.ServiceInstance) (inss []*Instance) {
 	for _, e := range ki.Endpoints {
-		scheme, ip, port := parseEndpoint(e)
-		status, _ := strconv.Atoi(ki.Metadata["tsf_status"])
+		scheme, ip, port, secure := parseEndpoint(e)
+		status, _ := strconv.Atoi(ki.Metadata[StatusKey])
 		id := ki.ID
 		if len(ki.Endpoints) > 1 {
 			id += "-" + scheme
@@ -105,6 +147,9 @@ func FromKratosInstance(ki *registry.ServiceInstance) (inss []*Instance) {
 			ins.Metadata[k] = v
 		}
 		ins.Metadata["protocol"] = scheme
+		if secure {
+			ins.Metadata[SecureKey] = "true"
+		}
 		if scheme == "grpc" {
 			if ins.Metadata["TSF_API_METAS_GRPC"] != "" {
 				ins.Metadata["TSF_API_METAS"] = ins.Metadata["TSF_API_METAS_GRPC"]
@@ -120,10 +165,11 @@ func FromKratosInstance(ki *registry.ServiceInstance) (inss []*Instance) {
 	return
 }
 
-func parseEndpoint(endpoint string) (string, string, int) {
+func parseEndpoint(endpoint string) (string, string, int, bool) {
 	u, _ := url.Parse(endpoint)
 	addrs := strings.Split(u.Host, ":")
 	ip := addrs[0]
 	port, _ := strconv.ParseInt(addrs[1], 10, 32)
-	return u.Scheme, ip, int(port)
+	secure, _ := strconv.ParseBool(u.Query().Get("isSecure"))
+	return u.Scheme, ip, int(port), secure
 }
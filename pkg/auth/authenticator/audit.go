@@ -0,0 +1,90 @@
+package authenticator
+
+import (
+	"context"
+	"sync"
+
+	"github.com/hisonsoft/tsf-go/pkg/meta"
+	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+	"github.com/hisonsoft/tsf-go/pkg/sys/monitor"
+	"github.com/natefinch/lumberjack"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zapcore"
+)
+
+var (
+	auditOnce   sync.Once
+	auditLogger *zap.Logger
+	recordAuth  = monitor.RecordAuth
+)
+
+// Decision is the result of an auth check
+type Decision struct {
+	Allowed bool
+	// RuleID and RuleName are empty if no rule matched
+	RuleID   string
+	RuleName string
+	// RuleType is W(whitelist) or B(blacklist)
+	RuleType  string
+	Service   string
+	Operation string
+}
+
+// getAuditLogger 第一次写审计日志时才创建文件
+func getAuditLogger() *zap.Logger {
+	auditOnce.Do(func() {
+		if auditLogger != nil {
+			return
+		}
+		encoding := zapcore.EncoderConfig{
+			TimeKey:        "time",
+			MessageKey:     "msg",
+			LineEnding:     zapcore.DefaultLineEnding,
+			EncodeTime:     zapcore.ISO8601TimeEncoder,
+			EncodeDuration: zapcore.SecondsDurationEncoder,
+		}
+		w := zapcore.AddSync(&lumberjack.Logger{
+			Filename:   env.AuthAuditPath(),
+			MaxSize:    100, // megabytes
+			MaxBackups: 10,
+			MaxAge:     30, // days
+		})
+		auditLogger = zap.New(zapcore.NewCore(zapcore.NewJSONEncoder(encoding), w, zap.InfoLevel))
+	})
+	return auditLogger
+}
+
+// audit 记录鉴权结果及调用方身份，并按规则统计允许、拒绝的次数
+func (a *Authenticator) audit(ctx context.Context, d Decision) {
+	if a.noAudit {
+		return
+	}
+	recordAuth(d.Service, d.RuleID, d.RuleType, d.Allowed)
+	if d.Allowed && !env.AuthAuditAllow() {
+		return
+	}
+	decision := "deny"
+	if d.Allowed {
+		decision = "allow"
+	}
+	getAuditLogger().Info("auth",
+		zap.String("decision", decision),
+		zap.String("rule_id", d.RuleID),
+		zap.String("rule_name", d.RuleName),
+		zap.String("rule_type", d.RuleType),
+		zap.String("service", d.Service),
+		zap.String("operation", d.Operation),
+		zap.String("source_namespace", sysString(ctx, meta.SourceKey(meta.Namespace))),
+		zap.String("source_service", sysString(ctx, meta.SourceKey(meta.ServiceName))),
+		zap.String("source_application", sysString(ctx, meta.SourceKey(meta.ApplicationID))),
+		zap.String("source_group", sysString(ctx, meta.SourceKey(meta.GroupID))),
+		zap.String("source_ip", sysString(ctx, meta.SourceKey(meta.ConnnectionIP))),
+		zap.String("source_tls_san", sysString(ctx, meta.SourceKey(meta.TLSSAN))),
+		zap.String("identity_trusted", sysString(ctx, meta.IdentityTrusted)),
+	)
+}
+
+func sysString(ctx context.Context, key string) string {
+	s, _ := meta.Sys(ctx, key).(string)
+	return s
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/hisonsoft/tsf-go/pkg/auth"
 	"github.com/hisonsoft/tsf-go/pkg/config"
 	"github.com/hisonsoft/tsf-go/pkg/naming"
+	"github.com/hisonsoft/tsf-go/pkg/sys/tag"
 )
 
 var (
@@ -18,11 +19,14 @@ var (
 )
 
 type Builder struct {
+	// NoAudit disables the audit log and the rule counters, e.g. when the
+	// rules of a remote service are checked by its callers
+	NoAudit bool
 }
 
 func (b *Builder) Build(cfg config.Source, svc naming.Service) auth.Auth {
 	watcher := cfg.Subscribe(fmt.Sprintf("authority/%s/%s/data", svc.Namespace, svc.Name))
-	a := &Authenticator{watcher: watcher, svc: svc}
+	a := &Authenticator{watcher: watcher, svc: svc, noAudit: b.NoAudit}
 	a.ctx, a.cancel = context.WithCancel(context.Background())
 	go a.refreshRule()
 	return a
@@ -32,6 +36,7 @@ type Authenticator struct {
 	watcher    config.Watcher
 	svc        naming.Service
 	authConfig *AuthConfig
+	noAudit    bool
 
 	mu sync.RWMutex
 
@@ -46,22 +51,58 @@ func (a *Authenticator) Verify(ctx context.Context, method string) error {
 	if authConfig == nil || len(authConfig.Rules) == 0 {
 		return nil
 	}
+	d := a.decide(ctx, authConfig, method)
+	a.audit(ctx, d)
+	if d.Allowed {
+		return nil
+	}
+	if d.RuleID != "" {
+		log.DefaultLog.Debugw("msg", "Authenticator.Verify hit blacklist,access blocked!", "rule", d.RuleID)
+	} else {
+		log.DefaultLog.Debug("Authenticator.Verify not hit whitelist,access blocked!")
+	}
+	return errors.Forbidden(errors.UnknownReason, "")
+}
 
-	for _, rule := range a.authConfig.Rules {
+// Precheck verifies the request like Verify without the audit, but only if
+// all the tags of the rules are accepted by known. The caller of a service
+// doesn't have the tags added by the service, e.g. the peer ip or
+// certificate, the decision depending on them may differ from the one of the
+// service. decided is false if the request can't be decided.
+func (a *Authenticator) Precheck(ctx context.Context, method string, known func(t tag.Tag) bool) (decided bool, err error) {
+	a.mu.RLock()
+	authConfig := a.authConfig
+	a.mu.RUnlock()
+	if authConfig == nil || len(authConfig.Rules) == 0 {
+		return true, nil
+	}
+	for _, rule := range authConfig.Rules {
 		rule.genTagRules()
-		if rule.tagRule.Hit(ctx) {
-			if authConfig.Type == "W" {
-				return nil
+		for _, t := range rule.tagRule.Tags {
+			if !known(t) {
+				return false, nil
 			}
-			log.DefaultLog.Debugw("msg", "Authenticator.Verify hit blacklist,access blocked!", "rule", rule.tagRule)
-			return errors.Forbidden(errors.UnknownReason, "")
 		}
 	}
-	if authConfig.Type == "W" {
-		log.DefaultLog.Debug("Authenticator.Verify not hit whitelist,access blocked!")
-		return errors.Forbidden(errors.UnknownReason, "")
+	if d := a.decide(ctx, authConfig, method); !d.Allowed {
+		return true, errors.Forbidden(errors.UnknownReason, "")
+	}
+	return true, nil
+}
+
+// decide 命中白名单任意规则时允许，命中黑名单任意规则时拒绝
+func (a *Authenticator) decide(ctx context.Context, authConfig *AuthConfig, method string) Decision {
+	d := Decision{RuleType: authConfig.Type, Service: a.svc.Name, Operation: method}
+	for _, rule := range authConfig.Rules {
+		rule.genTagRules()
+		if rule.tagRule.Hit(ctx) {
+			d.RuleID, d.RuleName = rule.ID, rule.Name
+			d.Allowed = authConfig.Type == "W"
+			return d
+		}
 	}
-	return nil
+	d.Allowed = authConfig.Type != "W"
+	return d
 }
 
 func (a *Authenticator) refreshRule() {
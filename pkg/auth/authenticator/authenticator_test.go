@@ -0,0 +1,95 @@
+package authenticator
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hisonsoft/tsf-go/pkg/meta"
+	"github.com/hisonsoft/tsf-go/pkg/naming"
+	"github.com/hisonsoft/tsf-go/pkg/sys/monitor"
+	"github.com/hisonsoft/tsf-go/pkg/sys/tag"
+	"go.uber.org/zap"
+	"go.uber.org/zap/zaptest/observer"
+)
+
+func TestVerifyAudit(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	auditLogger = zap.New(core)
+
+	rule := AuthRule{ID: "rule-1", Name: "allow consumer", Tags: []Tag{
+		{Type: "S", Field: meta.SourceKey(meta.ServiceName), Operator: "EQUAL", Value: "consumer"},
+	}}
+	a := &Authenticator{
+		svc:        naming.NewService("ns", "provider"),
+		authConfig: &AuthConfig{Type: "W", Rules: []AuthRule{rule}},
+	}
+	allowed := meta.WithSys(context.Background(), meta.SysPair{Key: meta.SourceKey(meta.ServiceName), Value: "consumer"})
+	if err := a.Verify(allowed, "/hello"); err != nil {
+		t.Fatalf("expect allowed, got %v", err)
+	}
+	denied := meta.WithSys(context.Background(),
+		meta.SysPair{Key: meta.SourceKey(meta.ServiceName), Value: "attacker"},
+		meta.SysPair{Key: meta.SourceKey(meta.ConnnectionIP), Value: "10.0.0.8"},
+	)
+	if err := a.Verify(denied, "/hello"); err == nil {
+		t.Fatalf("expect denied")
+	}
+	// 默认只记录拒绝
+	entries := logs.All()
+	if len(entries) != 1 {
+		t.Fatalf("expect 1 audit entry, got %d", len(entries))
+	}
+	fields := entries[0].ContextMap()
+	if fields["decision"] != "deny" || fields["rule_id"] != "" || fields["rule_type"] != "W" ||
+		fields["source_service"] != "attacker" || fields["source_ip"] != "10.0.0.8" || fields["operation"] != "/hello" {
+		t.Fatalf("unexpected audit entry %v", fields)
+	}
+
+	a.authConfig.Type = "B"
+	if err := a.Verify(allowed, "/hello"); err == nil {
+		t.Fatalf("expect denied by blacklist")
+	}
+	if fields = logs.All()[1].ContextMap(); fields["rule_id"] != "rule-1" || fields["rule_name"] != "allow consumer" || fields["rule_type"] != "B" {
+		t.Fatalf("unexpected audit entry %v", fields)
+	}
+}
+
+func TestPrecheck(t *testing.T) {
+	core, logs := observer.New(zap.InfoLevel)
+	auditLogger = zap.New(core)
+	var recorded int
+	recordAuth = func(string, string, string, bool) { recorded++ }
+	defer func() { recordAuth = monitor.RecordAuth }()
+
+	known := func(tg tag.Tag) bool { return tg.Field != meta.SourceKey(meta.ConnnectionIP) }
+	a := &Authenticator{svc: naming.NewService("ns", "provider"), noAudit: true}
+	ctx := meta.WithSys(context.Background(), meta.SysPair{Key: meta.SourceKey(meta.ServiceName), Value: "consumer"})
+	if decided, err := a.Precheck(ctx, "/hello", known); !decided || err != nil {
+		t.Fatalf("expect allowed without rules, got %v %v", decided, err)
+	}
+
+	service := AuthRule{ID: "rule-1", Tags: []Tag{{Type: "S", Field: meta.SourceKey(meta.ServiceName), Operator: "EQUAL", Value: "attacker"}}}
+	ip := AuthRule{ID: "rule-2", Tags: []Tag{{Type: "S", Field: meta.SourceKey(meta.ConnnectionIP), Operator: "EQUAL", Value: "10.0.0.8"}}}
+	for _, c := range []struct {
+		conf    AuthConfig
+		decided bool
+		allowed bool
+	}{
+		{AuthConfig{Type: "W", Rules: []AuthRule{service}}, true, false},
+		{AuthConfig{Type: "B", Rules: []AuthRule{{ID: "rule-3", Tags: []Tag{{Type: "S", Field: meta.SourceKey(meta.ServiceName), Operator: "EQUAL", Value: "consumer"}}}}}, true, false},
+		{AuthConfig{Type: "B", Rules: []AuthRule{service}}, true, true},
+		// 来源IP只有服务端知道，白名单中的IP规则可能放行请求
+		{AuthConfig{Type: "W", Rules: []AuthRule{service, ip}}, false, true},
+	} {
+		conf := c.conf
+		a.authConfig = &conf
+		decided, err := a.Precheck(ctx, "/hello", known)
+		if decided != c.decided || (err == nil) != c.allowed {
+			t.Fatalf("%+v: expect decided %v allowed %v, got %v %v", c.conf, c.decided, c.allowed, decided, err)
+		}
+		a.Verify(ctx, "/hello")
+	}
+	if len(logs.All()) != 0 || recorded != 0 {
+		t.Fatalf("expect no audit entries and counters, got %d entries %d counts", len(logs.All()), recorded)
+	}
+}
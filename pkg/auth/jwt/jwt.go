@@ -0,0 +1,313 @@
+// Package jwt validates the bearer tokens(JWT) of the requests and checks
+// the scopes required by the operations.
+package jwt
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+)
+
+// Config configures the validator
+type Config struct {
+	// Keys verifies the token signatures
+	Keys KeySet
+	// Issuer is the expected iss claim, not checked if empty
+	Issuer string
+	// Audience must be one of the aud claim, not checked if empty
+	Audience string
+	// Leeway is the allowed clock skew checking exp and nbf, default 1m
+	Leeway time.Duration
+	// RequireExp rejects the tokens without exp, which never expire, default
+	// true
+	RequireExp *bool
+	// Claims maps the claims to the user tags so that the auth and route
+	// rules can match them, default sub to jwt.sub
+	Claims map[string]string
+	// ScopeClaim holds the granted scopes, a space separated string or an
+	// array, default scope(scp is also accepted)
+	ScopeClaim string
+	// Optional lets the requests without a token pass unless the operation
+	// requires scopes
+	Optional bool
+	// Rules are the scopes required by the operations
+	Rules []Rule
+	// RulesKey is the path of the rules in the application config, the rules
+	// are replaced when it changes
+	RulesKey string
+}
+
+// Rule requires the scopes on the operations matching Operation, which is a
+// path.Match pattern such as /helloworld.Greeter/* or /api/v1/*
+type Rule struct {
+	Operation string   `yaml:"operation"`
+	Scopes    []string `yaml:"scopes"`
+}
+
+// Claims is the payload of a token
+type Claims map[string]interface{}
+
+// String returns the claim as string, numbers and bools are formatted
+func (c Claims) String(name string) (string, bool) {
+	switch v := c[name].(type) {
+	case string:
+		return v, true
+	case json.Number:
+		return v.String(), true
+	case bool:
+		return fmt.Sprint(v), true
+	}
+	return "", false
+}
+
+// Scopes returns the scopes in the claim
+func (c Claims) Scopes(name string) []string {
+	switch v := c[name].(type) {
+	case string:
+		return strings.Fields(v)
+	case []interface{}:
+		var res []string
+		for _, s := range v {
+			if str, ok := s.(string); ok {
+				res = append(res, str)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+// Validator validates the tokens
+type Validator struct {
+	conf Config
+
+	mu    sync.RWMutex
+	rules []Rule
+}
+
+func New(conf Config) *Validator {
+	if conf.Leeway <= 0 {
+		conf.Leeway = time.Minute
+	}
+	if conf.Claims == nil {
+		conf.Claims = map[string]string{"sub": "jwt.sub"}
+	}
+	if conf.ScopeClaim == "" {
+		conf.ScopeClaim = "scope"
+	}
+	if conf.RequireExp == nil {
+		required := true
+		conf.RequireExp = &required
+	}
+	return &Validator{conf: conf, rules: conf.Rules}
+}
+
+// Config returns the config of the validator
+func (v *Validator) Config() Config {
+	return v.conf
+}
+
+// SetRules replaces the rules
+func (v *Validator) SetRules(rules []Rule) {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.rules = rules
+}
+
+// Required returns the scopes required by the operation, the first matched
+// rule wins
+func (v *Validator) Required(operation string) (scopes []string, ok bool) {
+	v.mu.RLock()
+	defer v.mu.RUnlock()
+	for _, rule := range v.rules {
+		if matched, _ := path.Match(rule.Operation, operation); matched || rule.Operation == operation {
+			return rule.Scopes, true
+		}
+	}
+	return nil, false
+}
+
+// Authorize checks that the claims grant all the scopes required by the
+// operation
+func (v *Validator) Authorize(claims Claims, operation string) error {
+	required, ok := v.Required(operation)
+	if !ok || len(required) == 0 {
+		return nil
+	}
+	granted := make(map[string]struct{})
+	for _, name := range []string{v.conf.ScopeClaim, "scp"} {
+		for _, s := range claims.Scopes(name) {
+			granted[s] = struct{}{}
+		}
+	}
+	for _, s := range required {
+		if _, ok := granted[s]; !ok {
+			return errors.Forbidden("InsufficientScope", fmt.Sprintf("scope %s is required", s))
+		}
+	}
+	return nil
+}
+
+// Validate verifies the signature and the registered claims of the token
+func (v *Validator) Validate(token string, now time.Time) (Claims, error) {
+	if token == "" {
+		return nil, errors.Unauthorized("TokenMissing", "bearer token is required")
+	}
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, invalid("token malformed")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalid("token header malformed")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalid("token signature malformed")
+	}
+	key, err := v.conf.Keys.Key(header.Kid, header.Alg)
+	if err != nil {
+		return nil, invalid(err.Error())
+	}
+	if err = verify(header.Alg, key, parts[0]+"."+parts[1], sig); err != nil {
+		return nil, invalid(err.Error())
+	}
+	var claims Claims
+	if err = decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalid("token claims malformed")
+	}
+	exp, ok := claims.time("exp")
+	if !ok && *v.conf.RequireExp {
+		return nil, invalid("token exp is required")
+	}
+	if ok && now.After(exp.Add(v.conf.Leeway)) {
+		return nil, errors.Unauthorized("TokenExpired", "token expired")
+	}
+	if nbf, ok := claims.time("nbf"); ok && now.Add(v.conf.Leeway).Before(nbf) {
+		return nil, invalid("token not valid yet")
+	}
+	if v.conf.Issuer != "" {
+		if iss, _ := claims.String("iss"); iss != v.conf.Issuer {
+			return nil, invalid("token issuer mismatch")
+		}
+	}
+	if v.conf.Audience != "" && !contains(claims.Scopes("aud"), v.conf.Audience) {
+		return nil, invalid("token audience mismatch")
+	}
+	return claims, nil
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	n, ok := c[name].(json.Number)
+	if !ok {
+		return time.Time{}, false
+	}
+	f, err := n.Float64()
+	if err != nil {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(f), 0), true
+}
+
+func invalid(msg string) error {
+	return errors.Unauthorized("TokenInvalid", msg)
+}
+
+func decodeSegment(seg string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(seg)
+	if err != nil {
+		return err
+	}
+	// 数字保持原样，避免映射到标签时变成科学计数法
+	d := json.NewDecoder(bytes.NewReader(b))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func hashOf(alg string) (crypto.Hash, bool) {
+	if len(alg) != 5 {
+		return 0, false
+	}
+	switch alg[2:] {
+	case "256":
+		return crypto.SHA256, true
+	case "384":
+		return crypto.SHA384, true
+	case "512":
+		return crypto.SHA512, true
+	}
+	return 0, false
+}
+
+// verify 密钥类型必须与算法一致，防止用RSA公钥作为HMAC密钥伪造签名
+func verify(alg string, key interface{}, signed string, sig []byte) error {
+	hash, ok := hashOf(alg)
+	if !ok {
+		return fmt.Errorf("unsupported algorithm %s", alg)
+	}
+	switch alg[:2] {
+	case "HS":
+		secret, ok := key.([]byte)
+		if !ok {
+			return fmt.Errorf("key is not a %s key", alg)
+		}
+		h := hmac.New(hash.New, secret)
+		h.Write([]byte(signed))
+		if !hmac.Equal(sig, h.Sum(nil)) {
+			return fmt.Errorf("token signature mismatch")
+		}
+		return nil
+	case "RS":
+		pub, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key is not a %s key", alg)
+		}
+		h := hash.New()
+		h.Write([]byte(signed))
+		if err := rsa.VerifyPKCS1v15(pub, hash, h.Sum(nil), sig); err != nil {
+			return fmt.Errorf("token signature mismatch")
+		}
+		return nil
+	case "ES":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return fmt.Errorf("key is not a %s key", alg)
+		}
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		if len(sig) != 2*size {
+			return fmt.Errorf("token signature mismatch")
+		}
+		h := hash.New()
+		h.Write([]byte(signed))
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(pub, h.Sum(nil), r, s) {
+			return fmt.Errorf("token signature mismatch")
+		}
+		return nil
+	}
+	return fmt.Errorf("unsupported algorithm %s", alg)
+}
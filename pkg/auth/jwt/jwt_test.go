@@ -0,0 +1,170 @@
+package jwt
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+)
+
+func b64(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func segment(t *testing.T, v interface{}) string {
+	b, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b64(b)
+}
+
+// sign 生成测试token，key为[]byte、*rsa.PrivateKey或*ecdsa.PrivateKey
+func sign(t *testing.T, alg, kid string, key interface{}, claims map[string]interface{}) string {
+	signed := segment(t, map[string]string{"alg": alg, "kid": kid, "typ": "JWT"}) + "." + segment(t, claims)
+	sum := sha256.Sum256([]byte(signed))
+	var sig []byte
+	switch k := key.(type) {
+	case []byte:
+		h := hmac.New(sha256.New, k)
+		h.Write([]byte(signed))
+		sig = h.Sum(nil)
+	case *rsa.PrivateKey:
+		var err error
+		if sig, err = rsa.SignPKCS1v15(rand.Reader, k, crypto.SHA256, sum[:]); err != nil {
+			t.Fatal(err)
+		}
+	case *ecdsa.PrivateKey:
+		r, s, err := ecdsa.Sign(rand.Reader, k, sum[:])
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig = make([]byte, 64)
+		rb, sb := r.Bytes(), s.Bytes()
+		copy(sig[32-len(rb):32], rb)
+		copy(sig[64-len(sb):], sb)
+	}
+	return signed + "." + b64(sig)
+}
+
+func TestValidate(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	secret := []byte("secret")
+	set := map[string]interface{}{"keys": []map[string]string{
+		{"kty": "RSA", "kid": "rsa-1", "alg": "RS256", "n": b64(rsaKey.N.Bytes()), "e": b64(big.NewInt(int64(rsaKey.E)).Bytes())},
+		{"kty": "EC", "kid": "ec-1", "crv": "P-256", "x": b64(ecKey.X.Bytes()), "y": b64(ecKey.Y.Bytes())},
+		{"kty": "oct", "kid": "hs-1", "k": b64(secret)},
+	}}
+	b, _ := json.Marshal(set)
+	f, err := ioutil.TempFile("", "jwks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.Write(b)
+	f.Close()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write(b)
+	}))
+	defer srv.Close()
+
+	now := time.Now()
+	claims := map[string]interface{}{"sub": "alice", "iss": "tsf", "aud": []string{"api"}, "exp": now.Add(time.Hour).Unix(), "uid": 1234567890}
+	for name, keys := range map[string]KeySet{"file": JWKSFile(f.Name()), "url": JWKSURL(srv.URL)} {
+		v := New(Config{Keys: keys, Issuer: "tsf", Audience: "api"})
+		for _, token := range []string{
+			sign(t, "RS256", "rsa-1", rsaKey, claims),
+			sign(t, "ES256", "ec-1", ecKey, claims),
+			sign(t, "HS256", "hs-1", secret, claims),
+		} {
+			c, err := v.Validate(token, now)
+			if err != nil {
+				t.Fatalf("%s: %v", name, err)
+			}
+			if sub, _ := c.String("sub"); sub != "alice" {
+				t.Fatalf("%s: unexpected sub %s", name, sub)
+			}
+			if uid, _ := c.String("uid"); uid != "1234567890" {
+				t.Fatalf("%s: unexpected uid %s", name, uid)
+			}
+		}
+	}
+
+	v := New(Config{Keys: JWKSFile(f.Name()), Issuer: "tsf"})
+	expired := map[string]interface{}{"sub": "alice", "iss": "tsf", "exp": now.Add(-time.Hour).Unix()}
+	other, _ := rsa.GenerateKey(rand.Reader, 2048)
+	for _, c := range []struct {
+		token  string
+		reason string
+	}{
+		{"", "TokenMissing"},
+		{"a.b", "TokenInvalid"},
+		{sign(t, "RS256", "rsa-1", rsaKey, expired), "TokenExpired"},
+		{sign(t, "RS256", "rsa-1", rsaKey, map[string]interface{}{"iss": "other"}), "TokenInvalid"},
+		{sign(t, "RS256", "rsa-1", other, claims), "TokenInvalid"},
+		{sign(t, "RS256", "unknown", rsaKey, claims), "TokenInvalid"},
+		// 使用RSA公钥作为HMAC密钥伪造的token
+		{sign(t, "HS256", "rsa-1", rsaKey.N.Bytes(), claims), "TokenInvalid"},
+		{segment(t, map[string]string{"alg": "none"}) + "." + segment(t, claims) + ".", "TokenInvalid"},
+		// 没有exp的token永不过期
+		{sign(t, "RS256", "rsa-1", rsaKey, map[string]interface{}{"sub": "alice", "iss": "tsf"}), "TokenInvalid"},
+	} {
+		_, err := v.Validate(c.token, now)
+		if se := errors.FromError(err); se == nil || se.Reason != c.reason {
+			t.Fatalf("expect %s, got %v", c.reason, err)
+		}
+	}
+	optional := false
+	v = New(Config{Keys: JWKSFile(f.Name()), RequireExp: &optional})
+	if _, err = v.Validate(sign(t, "RS256", "rsa-1", rsaKey, map[string]interface{}{"sub": "alice"}), now); err != nil {
+		t.Fatalf("expect token without exp accepted, got %v", err)
+	}
+}
+
+func TestAuthorize(t *testing.T) {
+	v := New(Config{Keys: HMACKey("secret"), Rules: []Rule{
+		{Operation: "/helloworld.Greeter/SayHello", Scopes: []string{"hello"}},
+		{Operation: "/api/admin/*", Scopes: []string{"admin", "write"}},
+	}})
+	for _, c := range []struct {
+		claims    Claims
+		operation string
+		allowed   bool
+	}{
+		{Claims{"scope": "read hello"}, "/helloworld.Greeter/SayHello", true},
+		{Claims{"scope": "read"}, "/helloworld.Greeter/SayHello", false},
+		{Claims{"scp": []interface{}{"admin", "write"}}, "/api/admin/users", true},
+		{Claims{"scope": "admin"}, "/api/admin/users", false},
+		{Claims{}, "/api/public", true},
+	} {
+		err := v.Authorize(c.claims, c.operation)
+		if (err == nil) != c.allowed {
+			t.Fatalf("%s %v: expect allowed %v, got %v", c.operation, c.claims, c.allowed, err)
+		}
+	}
+	v.SetRules(nil)
+	if err := v.Authorize(Claims{}, "/helloworld.Greeter/SayHello"); err != nil {
+		t.Fatalf("expect no scope required after rules replaced, got %v", err)
+	}
+}
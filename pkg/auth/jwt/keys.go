@@ -0,0 +1,219 @@
+package jwt
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/log"
+	tsfHttp "github.com/hisonsoft/tsf-go/pkg/http"
+)
+
+// KeySet returns the key verifying a token signed by alg with the key id
+// kid(may be empty): []byte for HS, *rsa.PublicKey for RS and
+// *ecdsa.PublicKey for ES
+type KeySet interface {
+	Key(kid, alg string) (interface{}, error)
+}
+
+// HMACKey is a single secret for the HS algorithms
+type HMACKey []byte
+
+func (k HMACKey) Key(kid, alg string) (interface{}, error) {
+	return []byte(k), nil
+}
+
+// JWKSFile loads a JSON Web Key Set from a file which is reloaded when it
+// changes
+func JWKSFile(path string) KeySet {
+	return &jwks{
+		name:     path,
+		interval: 10 * time.Second,
+		fetch: func() (*jwkSet, error) {
+			b, err := ioutil.ReadFile(path)
+			if err != nil {
+				return nil, err
+			}
+			var set jwkSet
+			if err = json.Unmarshal(b, &set); err != nil {
+				return nil, err
+			}
+			return &set, nil
+		},
+	}
+}
+
+// JWKSURL fetches a JSON Web Key Set from url every 5 minutes, or earlier
+// when a token is signed by an unknown key
+func JWKSURL(url string) KeySet {
+	cli := tsfHttp.NewClient(tsfHttp.WithTimeout(5 * time.Second))
+	return &jwks{
+		name:     url,
+		interval: 5 * time.Minute,
+		fetch: func() (*jwkSet, error) {
+			var set jwkSet
+			if _, err := cli.Get(url, &set); err != nil {
+				return nil, err
+			}
+			return &set, nil
+		},
+	}
+}
+
+type jwkSet struct {
+	Keys []jwk `json:"keys"`
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA
+	N string `json:"n"`
+	E string `json:"e"`
+	// EC
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+	// oct
+	K string `json:"k"`
+}
+
+type parsedKey struct {
+	kid    string
+	alg    string
+	family string
+	key    interface{}
+}
+
+type jwks struct {
+	name     string
+	interval time.Duration
+	fetch    func() (*jwkSet, error)
+
+	mu      sync.Mutex
+	checked time.Time
+	keys    []parsedKey
+}
+
+func (s *jwks) Key(kid, alg string) (interface{}, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.checked.IsZero() || time.Since(s.checked) >= s.interval {
+		s.reload()
+	}
+	key, ok := s.find(kid, alg)
+	// 密钥轮换后签发的token可能使用了新的key，最多每10秒重新拉取一次
+	if !ok && time.Since(s.checked) >= 10*time.Second {
+		s.reload()
+		key, ok = s.find(kid, alg)
+	}
+	if !ok {
+		return nil, fmt.Errorf("no key found for kid %q alg %s", kid, alg)
+	}
+	return key, nil
+}
+
+func (s *jwks) reload() {
+	s.checked = time.Now()
+	set, err := s.fetch()
+	if err != nil {
+		// 拉取失败时继续使用旧的密钥
+		log.DefaultLog.Errorw("msg", "[jwt] load jwks failed!", "jwks", s.name, "err", err)
+		return
+	}
+	keys := make([]parsedKey, 0, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, family, err := k.parse()
+		if err != nil {
+			log.DefaultLog.Errorw("msg", "[jwt] parse jwk failed!", "jwks", s.name, "kid", k.Kid, "err", err)
+			continue
+		}
+		keys = append(keys, parsedKey{kid: k.Kid, alg: k.Alg, family: family, key: key})
+	}
+	s.keys = keys
+}
+
+// find token未指定kid时，只有唯一一个算法匹配的key才可以使用
+func (s *jwks) find(kid, alg string) (interface{}, bool) {
+	var candidates []parsedKey
+	for _, k := range s.keys {
+		if !strings.HasPrefix(alg, k.family) || (k.alg != "" && k.alg != alg) {
+			continue
+		}
+		if kid != "" && k.kid == kid {
+			return k.key, true
+		}
+		candidates = append(candidates, k)
+	}
+	if kid == "" && len(candidates) == 1 {
+		return candidates[0].key, true
+	}
+	return nil, false
+}
+
+func (k jwk) parse() (key interface{}, family string, err error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeInt(k.N)
+		if err != nil {
+			return nil, "", err
+		}
+		e, err := decodeInt(k.E)
+		if err != nil {
+			return nil, "", err
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, "RS", nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, "", fmt.Errorf("unsupported curve %s", k.Crv)
+		}
+		x, err := decodeInt(k.X)
+		if err != nil {
+			return nil, "", err
+		}
+		y, err := decodeInt(k.Y)
+		if err != nil {
+			return nil, "", err
+		}
+		if !curve.IsOnCurve(x, y) {
+			return nil, "", fmt.Errorf("invalid ec point")
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, "ES", nil
+	case "oct":
+		b, err := base64.RawURLEncoding.DecodeString(k.K)
+		if err != nil {
+			return nil, "", err
+		}
+		return b, "HS", nil
+	}
+	return nil, "", fmt.Errorf("unsupported key type %s", k.Kty)
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
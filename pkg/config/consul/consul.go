@@ -15,11 +15,12 @@ import (
 	"github.com/go-kratos/kratos/v2/errors"
 	"github.com/hisonsoft/tsf-go/log"
 	"github.com/hisonsoft/tsf-go/pkg/config"
+	"github.com/hisonsoft/tsf-go/pkg/config/format"
 	"github.com/hisonsoft/tsf-go/pkg/http"
 	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+	"github.com/hisonsoft/tsf-go/pkg/sys/metrics"
 	"github.com/hisonsoft/tsf-go/pkg/util"
-
-	"gopkg.in/yaml.v3"
+	"github.com/hisonsoft/tsf-go/pkg/watch"
 )
 
 var (
@@ -33,17 +34,30 @@ const separator string = "-"
 
 type Config struct {
 	Address string
-	Token   string
+	// Addresses is a list of equivalent consul agents to fail over between,
+	// Address is used if it is empty
+	Addresses []string
+	Token     string
+	// TokenFunc returns the current acl token and overrides Token,
+	// e.g. env.ConsulToken which picks up a rotated token
+	TokenFunc func() string
+	// TLS enables https to the consul agents
+	TLS *http.TLSConfig
 	// additional message: tsf namespaceid and tencent appid if exsist
 	AppID       string
 	NamespaceID string
+
+	// Engine runs the blocking queries, default watch.DefaultEngine()
+	Engine *watch.Engine
 }
 
 type Consul struct {
-	queryCli *http.Client
-	bc       *util.BackoffConfig
-	lock     sync.RWMutex
-	conf     *Config
+	queryCli  *http.Client
+	bc        *util.BackoffConfig
+	endpoints *util.Endpoints
+	engine    *watch.Engine
+	lock      sync.RWMutex
+	conf      *Config
 
 	topic map[string]*Topic
 }
@@ -53,16 +67,17 @@ func DefaultConsul() *Consul {
 	defer mu.Unlock()
 	if defaultConsul == nil {
 		defaultConsul = New(&Config{
-			Address: fmt.Sprintf("%s:%d", env.ConsulHost(), env.ConsulPort()),
-			Token:   env.Token(),
+			Addresses: env.ConsulAddressList(),
+			TokenFunc: env.ConsulToken,
+			TLS:       http.ConsulTLSConfig(),
 		})
+		metrics.RegisterDiscovery("config", defaultConsul)
 	}
 	return defaultConsul
 }
 
 func New(conf *Config) *Consul {
-	return &Consul{
-		queryCli: http.NewClient(http.WithTimeout(time.Second * 90)),
+	c := &Consul{
 		bc: &util.BackoffConfig{
 			MaxDelay:  25 * time.Second,
 			BaseDelay: 500 * time.Millisecond,
@@ -72,6 +87,69 @@ func New(conf *Config) *Consul {
 		topic: make(map[string]*Topic),
 		conf:  conf,
 	}
+	c.queryCli = http.NewClient(http.WithTimeout(time.Second*90), http.WithTLS(conf.TLS), http.WithHeader(c.setToken))
+	addrs := conf.Addresses
+	if len(addrs) == 0 {
+		addrs = []string{conf.Address}
+	}
+	c.endpoints = util.NewEndpoints(addrs, c.bc)
+	c.engine = conf.Engine
+	if c.engine == nil {
+		c.engine = watch.DefaultEngine()
+	}
+	return c
+}
+
+func (c *Consul) token() string {
+	if c.conf.TokenFunc != nil {
+		return c.conf.TokenFunc()
+	}
+	return c.conf.Token
+}
+
+// setToken sends the acl token by header, so that it is not leaked to access logs
+func (c *Consul) setToken(header xhttp.Header) {
+	if token := c.token(); token != "" {
+		header.Set("X-Consul-Token", token)
+	}
+}
+
+func (c *Consul) scheme() string {
+	if c.conf.TLS != nil {
+		return "https"
+	}
+	return "http"
+}
+
+// watchKey identifies the blocking query of a path, only the watches of the
+// same client are coalesced by the engine: clients with different tokens or
+// tls configs may see different results
+func (c *Consul) watchKey(path string) string {
+	return fmt.Sprintf("config|%p|%s|%s|%s|%s", c, strings.Join(c.endpoints.Addrs(), ","), c.conf.NamespaceID, c.conf.AppID, path)
+}
+
+// WatchStats returns the state of the blocking queries of the watch engine
+func (c *Consul) WatchStats() []watch.Stat {
+	return c.engine.Stats()
+}
+
+// waitParam returns the blocking time param of a query in seconds
+func waitParam(wait time.Duration) string {
+	secs := int(wait / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	return fmt.Sprintf("wait=%ds", secs)
+}
+
+// EndpointStats returns the connection state of the consul agents
+func (c *Consul) EndpointStats() []util.EndpointStat {
+	return c.endpoints.Stats()
+}
+
+// Failovers returns how many times the client switched to another agent
+func (c *Consul) Failovers() int64 {
+	return c.endpoints.Failovers()
 }
 
 func (c *Consul) Subscribe(path string) config.Watcher {
@@ -88,18 +166,20 @@ func (c *Consul) Subscribe(path string) config.Watcher {
 			consul:  c,
 			watcher: make(map[*Watcher]struct{}),
 		}
-		var ctx context.Context
-		ctx, topic.cancel = context.WithCancel(context.Background())
 		c.topic[path] = topic
-		go topic.subscribe(ctx)
+		topic.cancel = c.engine.Watch(c.watchKey(path), topic.query, topic.onChange)
+	} else if _, ok := topic.spec.Load().([]config.Spec); ok {
+		// 已有的订阅不会再次推送当前配置，新的订阅者直接读取
+		w.event <- struct{}{}
 	}
 	w.topic = topic
 	topic.watcher[w] = struct{}{}
 	return w
 }
 
-func (c *Consul) fetch(path string, index int64) (res []config.Spec, consulIndex int64, err error) {
-	url := fmt.Sprintf("http://%s/v1/kv/%s?token=%s&wait=55s&nsType=DEF_AND_GLOBAL&index=%d", c.conf.Address, path, c.conf.Token, index)
+func (c *Consul) fetch(ctx context.Context, path string, index int64, wait time.Duration) (res []config.Spec, consulIndex int64, err error) {
+	addr := c.endpoints.Pick()
+	url := fmt.Sprintf("%s://%s/v1/kv/%s?%s&nsType=DEF_AND_GLOBAL&index=%d", c.scheme(), addr, path, waitParam(wait), index)
 	if strings.HasSuffix(path, "/") {
 		url += "&recurse"
 	}
@@ -110,7 +190,7 @@ func (c *Consul) fetch(path string, index int64) (res []config.Spec, consulIndex
 		url += "&uid=" + c.conf.AppID
 	}
 	defer func() {
-		if err != nil {
+		if err != nil && ctx.Err() == nil {
 			log.DefaultLog.Errorw("msg", "[config] get config failed!", "url", url, "err", err)
 		}
 	}()
@@ -122,7 +202,12 @@ func (c *Consul) fetch(path string, index int64) (res []config.Spec, consulIndex
 			Value string
 		}
 	)
-	header, err = c.queryCli.Get(url, &items)
+	header, err = c.queryCli.GetContext(ctx, url, &items)
+	if ctx.Err() != nil {
+		// 取消订阅时中断的查询不计为agent故障
+		return nil, 0, ctx.Err()
+	}
+	c.endpoints.Done(addr, err)
 	if err != nil {
 		if errors.IsNotFound(err) {
 			err = nil
@@ -144,42 +229,27 @@ func (c *Consul) fetch(path string, index int64) (res []config.Spec, consulIndex
 	for _, item := range items {
 		b, err := base64.StdEncoding.DecodeString(item.Value)
 		if err != nil {
-			log.DefaultLog.Errorw("msg", "[config] fetch failed!", "url", url, "key", item.Key, "value", item.Value, "err", err)
+			log.DefaultLog.Errorw("msg", "[config] fetch failed!", "url", url, "key", item.Key, "err", err)
 			continue
 		}
-		res = append(res, config.Spec{Key: item.Key, Data: raw(b)})
+		res = append(res, config.Spec{Key: item.Key, Data: format.NewData(item.Key, b)})
 	}
 	return
 }
 
-func (t *Topic) subscribe(ctx context.Context) {
-	var (
-		lastIndex int64
-		lastRes   []config.Spec
-		err       error
-	)
-	lastRes, lastIndex, err = t.consul.fetch(t.path, lastIndex)
-	if err == nil {
-		t.broadcast(lastRes)
-	}
-	ticker := time.NewTicker(time.Second)
-	defer ticker.Stop()
-	for {
-		select {
-		case <-ctx.Done():
-			return
-		case <-ticker.C:
-			res, index, err := t.consul.fetch(t.path, lastIndex)
-			if err != nil {
-				continue
-			}
-			if !reflect.DeepEqual(lastRes, res) {
-				t.broadcast(res)
-			}
-			lastRes = res
-			lastIndex = index
-		}
+func (t *Topic) query(ctx context.Context, index int64, wait time.Duration) (interface{}, int64, error) {
+	res, index, err := t.consul.fetch(ctx, t.path, index, wait)
+	return res, index, err
+}
+
+func (t *Topic) onChange(result interface{}, index int64) {
+	res := result.([]config.Spec)
+	if t.ready && reflect.DeepEqual(t.lastRes, res) {
+		return
 	}
+	t.ready = true
+	t.lastRes = res
+	t.broadcast(res)
 }
 
 func (t *Topic) broadcast(res []config.Spec) {
@@ -194,39 +264,40 @@ func (t *Topic) broadcast(res []config.Spec) {
 	}
 }
 
+// Get returns the specs of path from the subscription if it is loaded,
+// otherwise they are fetched from consul at once
 func (c *Consul) Get(ctx context.Context, path string) (spec []config.Spec) {
 	c.lock.RLock()
-	defer c.lock.RUnlock()
 	v, ok := c.topic[path]
-	if !ok {
-		return
+	c.lock.RUnlock()
+	if ok {
+		if spec, ok = v.spec.Load().([]config.Spec); ok {
+			return
+		}
+	}
+	ch := make(chan []config.Spec, 1)
+	go func() {
+		// index为0时consul立即返回，错误已在fetch中打印
+		res, _, _ := c.fetch(ctx, path, 0, time.Second)
+		ch <- res
+	}()
+	select {
+	case <-ctx.Done():
+	case spec = <-ch:
 	}
-	spec, _ = v.spec.Load().([]config.Spec)
-	return nil
+	return
 }
 
 type Topic struct {
 	path    string
 	spec    atomic.Value
-	cancel  context.CancelFunc
+	cancel  func()
 	consul  *Consul
 	watcher map[*Watcher]struct{}
-}
-
-type raw []byte
-
-func (r raw) Unmarshal(out interface{}) error {
-	if r == nil {
-		return nil
-	}
-	return yaml.Unmarshal(r, out)
-}
 
-func (r raw) Raw() []byte {
-	if r == nil {
-		return nil
-	}
-	return r
+	// only accessed by the watch handler
+	ready   bool
+	lastRes []config.Spec
 }
 
 type Watcher struct {
@@ -5,17 +5,24 @@ import (
 	"context"
 	"fmt"
 	"net/http"
+	"os"
 	"reflect"
 	"testing"
 	"time"
 
 	"github.com/hisonsoft/tsf-go/pkg/config"
+	"github.com/hisonsoft/tsf-go/pkg/consultest"
 	"gopkg.in/yaml.v3"
 )
 
-func TestMain(m *testing.M) {
-	m.Run()
+var consulAddr string
 
+func TestMain(m *testing.M) {
+	srv := consultest.NewServer()
+	consulAddr = srv.Addr()
+	code := m.Run()
+	srv.Close()
+	os.Exit(code)
 }
 
 const testContent1 = `destList:
@@ -32,9 +39,10 @@ func TestSimpleConfig(t *testing.T) {
 		t.FailNow()
 	}
 	config := New(&Config{
-		Address: "127.0.0.1:8500",
+		Address: consulAddr,
 	})
 	watcher := config.Subscribe("com/tencent/tsf")
+	defer watcher.Close()
 
 	checkConfig(t, watcher, testContent1)
 
@@ -102,7 +110,7 @@ func checkConfig(t *testing.T, watcher config.Watcher, expect string) {
 
 func deleteKey(key string) error {
 	client := http.Client{Timeout: time.Second * 2}
-	req, err := http.NewRequest("DELETE", fmt.Sprintf("http://127.0.0.1:8500/v1/kv/%s", key), nil)
+	req, err := http.NewRequest("DELETE", fmt.Sprintf("http://%s/v1/kv/%s", consulAddr, key), nil)
 	if err != nil {
 		return err
 	}
@@ -119,7 +127,7 @@ func deleteKey(key string) error {
 
 func set(key string, value []byte) error {
 	client := http.Client{Timeout: time.Second * 2}
-	req, err := http.NewRequest("PUT", fmt.Sprintf("http://127.0.0.1:8500/v1/kv/%s", key), bytes.NewReader(value))
+	req, err := http.NewRequest("PUT", fmt.Sprintf("http://%s/v1/kv/%s", consulAddr, key), bytes.NewReader(value))
 	if err != nil {
 		return err
 	}
@@ -133,3 +141,32 @@ func set(key string, value []byte) error {
 	}
 	return nil
 }
+
+func TestGet(t *testing.T) {
+	if err := set("com/tencent/get", []byte(testContent1)); err != nil {
+		t.Fatal(err)
+	}
+	c := New(&Config{
+		Address: consulAddr,
+	})
+	// 没有订阅时直接从consul获取
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*5)
+	defer cancel()
+	specs := c.Get(ctx, "com/tencent/get")
+	if len(specs) != 1 || string(specs[0].Data.Raw()) != testContent1 {
+		t.Fatalf("unexpected specs %+v", specs)
+	}
+	watcher := c.Subscribe("com/tencent/get")
+	defer watcher.Close()
+	checkConfig(t, watcher, testContent1)
+	// 同一个key的第二个订阅者立即收到当前配置
+	second := c.Subscribe("com/tencent/get")
+	defer second.Close()
+	checkConfig(t, second, testContent1)
+	if specs = c.Get(ctx, "com/tencent/get"); len(specs) != 1 {
+		t.Fatalf("unexpected specs %+v", specs)
+	}
+	if specs = c.Get(ctx, "com/tencent/missing"); len(specs) != 0 {
+		t.Fatalf("expect no specs, got %+v", specs)
+	}
+}
@@ -0,0 +1,236 @@
+// Package file is a config source reading consul style keys from local
+// files, e.g. the key route/<namespace>/<service>/data is read from
+// <dir>/route/<namespace>/<service>/data(.yaml|.yml|.json|.properties|.toml).
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/hisonsoft/tsf-go/log"
+	"github.com/hisonsoft/tsf-go/pkg/config"
+	"github.com/hisonsoft/tsf-go/pkg/config/format"
+)
+
+var _ config.Source = &File{}
+
+// extensions are stripped from file names to get the keys
+var extensions = []string{".yaml", ".yml", ".json", ".properties", ".toml"}
+
+type Config struct {
+	// Dir is the root directory of the keys
+	Dir string
+	// Interval is how often files are checked for changes, default 1s
+	Interval time.Duration
+}
+
+// File is a config source backed by a local directory
+type File struct {
+	conf *Config
+	lock sync.RWMutex
+
+	topic map[string]*Topic
+}
+
+func New(conf *Config) *File {
+	if conf.Interval <= 0 {
+		conf.Interval = time.Second
+	}
+	return &File{
+		conf:  conf,
+		topic: make(map[string]*Topic),
+	}
+}
+
+func (f *File) Subscribe(path string) config.Watcher {
+	w := &Watcher{
+		event: make(chan struct{}, 1),
+	}
+	w.ctx, w.cancel = context.WithCancel(context.Background())
+	f.lock.Lock()
+	defer f.lock.Unlock()
+	topic, ok := f.topic[path]
+	if !ok {
+		topic = &Topic{
+			path:    path,
+			file:    f,
+			watcher: make(map[*Watcher]struct{}),
+		}
+		var ctx context.Context
+		ctx, topic.cancel = context.WithCancel(context.Background())
+		f.topic[path] = topic
+		go topic.subscribe(ctx)
+	} else if _, ok := topic.spec.Load().([]config.Spec); ok {
+		// 已有的订阅不会再次推送当前配置，新的订阅者直接读取
+		w.event <- struct{}{}
+	}
+	w.topic = topic
+	topic.watcher[w] = struct{}{}
+	return w
+}
+
+func (f *File) Get(ctx context.Context, path string) []config.Spec {
+	specs, err := f.read(path)
+	if err != nil {
+		log.DefaultLog.Errorw("msg", "[config] read config file failed!", "path", path, "err", err)
+	}
+	return specs
+}
+
+// read returns the specs of a key, or of all keys under path if it ends with /
+func (f *File) read(path string) (specs []config.Spec, err error) {
+	if !strings.HasSuffix(path, "/") {
+		ext, ok := f.resolve(path)
+		if !ok {
+			return
+		}
+		var b []byte
+		if b, err = ioutil.ReadFile(filepath.Join(f.conf.Dir, filepath.FromSlash(path+ext))); err != nil {
+			return
+		}
+		return []config.Spec{{Key: path, Data: format.NewData(path+ext, b)}}, nil
+	}
+	root := filepath.Join(f.conf.Dir, filepath.FromSlash(path))
+	err = filepath.Walk(root, func(file string, info os.FileInfo, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if strings.HasPrefix(info.Name(), ".") {
+			if info.IsDir() && file != root {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if info.IsDir() {
+			return nil
+		}
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, err := filepath.Rel(f.conf.Dir, file)
+		if err != nil {
+			return err
+		}
+		rel = filepath.ToSlash(rel)
+		specs = append(specs, config.Spec{Key: trimExt(rel), Data: format.NewData(rel, b)})
+		return nil
+	})
+	sort.Slice(specs, func(i, j int) bool { return specs[i].Key < specs[j].Key })
+	return
+}
+
+// resolve finds the extension of the file of key, empty if the file has no extension
+func (f *File) resolve(key string) (string, bool) {
+	file := filepath.Join(f.conf.Dir, filepath.FromSlash(key))
+	for _, ext := range append([]string{""}, extensions...) {
+		if info, err := os.Stat(file + ext); err == nil && !info.IsDir() {
+			return ext, true
+		}
+	}
+	return "", false
+}
+
+func trimExt(key string) string {
+	for _, ext := range extensions {
+		if strings.HasSuffix(key, ext) {
+			return strings.TrimSuffix(key, ext)
+		}
+	}
+	return key
+}
+
+type Topic struct {
+	path    string
+	spec    atomic.Value
+	cancel  context.CancelFunc
+	file    *File
+	watcher map[*Watcher]struct{}
+}
+
+func (t *Topic) subscribe(ctx context.Context) {
+	lastRes, err := t.file.read(t.path)
+	if err != nil {
+		log.DefaultLog.Errorw("msg", "[config] read config file failed!", "path", t.path, "err", err)
+	}
+	t.broadcast(lastRes)
+	ticker := time.NewTicker(t.file.conf.Interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			res, err := t.file.read(t.path)
+			if err != nil {
+				// 文件可能正在写入，等待下次检查
+				continue
+			}
+			if !reflect.DeepEqual(lastRes, res) {
+				t.broadcast(res)
+			}
+			lastRes = res
+		}
+	}
+}
+
+func (t *Topic) broadcast(res []config.Spec) {
+	t.spec.Store(res)
+	t.file.lock.RLock()
+	defer t.file.lock.RUnlock()
+	for k := range t.watcher {
+		select {
+		case k.event <- struct{}{}:
+		default:
+		}
+	}
+}
+
+type Watcher struct {
+	topic  *Topic
+	event  chan struct{}
+	ctx    context.Context
+	cancel context.CancelFunc
+}
+
+func (w *Watcher) Watch(ctx context.Context) (spec []config.Spec, err error) {
+	select {
+	case <-ctx.Done():
+		err = errors.GatewayTimeout(errors.UnknownReason, "")
+		return
+	case <-w.ctx.Done():
+		err = errors.ClientClosed(errors.UnknownReason, "")
+		return
+	case <-w.event:
+		spec, _ = w.topic.spec.Load().([]config.Spec)
+	}
+	return
+}
+
+func (w *Watcher) Close() {
+	select {
+	case <-w.ctx.Done():
+		return
+	default:
+	}
+	w.cancel()
+	w.topic.file.lock.Lock()
+	defer w.topic.file.lock.Unlock()
+	delete(w.topic.watcher, w)
+	if len(w.topic.watcher) == 0 {
+		delete(w.topic.file.topic, w.topic.path)
+		w.topic.cancel()
+	}
+}
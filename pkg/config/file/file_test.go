@@ -0,0 +1,85 @@
+package file
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/pkg/config"
+)
+
+func TestFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tsf-config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write(t, dir, "route/ns-1/svc-a/data.yaml", "a: 1")
+	write(t, dir, "route/ns-1/.svc-b.swp", "ignored")
+	write(t, dir, "config/application/app-1/group-1/data", "key: v1")
+
+	f := New(&Config{Dir: dir, Interval: 10 * time.Millisecond})
+	routeWatcher := f.Subscribe("route/ns-1/")
+	defer routeWatcher.Close()
+	appWatcher := f.Subscribe("config/application/app-1/group-1/data")
+	defer appWatcher.Close()
+
+	specs := watch(t, routeWatcher)
+	if len(specs) != 1 || specs[0].Key != "route/ns-1/svc-a/data" {
+		t.Fatalf("unexpected route specs %+v", specs)
+	}
+	specs = watch(t, appWatcher)
+	var v struct{ Key string }
+	if len(specs) != 1 || specs[0].Data.Unmarshal(&v) != nil || v.Key != "v1" {
+		t.Fatalf("unexpected app specs %+v", specs)
+	}
+
+	// 新增、修改和删除文件都能被感知
+	write(t, dir, "route/ns-1/svc-c/data.json", `{"c": 1}`)
+	if specs = watch(t, routeWatcher); len(specs) != 2 || specs[1].Key != "route/ns-1/svc-c/data" {
+		t.Fatalf("unexpected route specs %+v", specs)
+	}
+	write(t, dir, "config/application/app-1/group-1/data", "key: v2")
+	if specs = watch(t, appWatcher); len(specs) != 1 || specs[0].Data.Unmarshal(&v) != nil || v.Key != "v2" {
+		t.Fatalf("unexpected app specs %+v", specs)
+	}
+	if err = os.Remove(filepath.Join(dir, "config/application/app-1/group-1/data")); err != nil {
+		t.Fatal(err)
+	}
+	if specs = watch(t, appWatcher); len(specs) != 0 {
+		t.Fatalf("expect app config deleted, got %+v", specs)
+	}
+	if specs = f.Get(context.Background(), "route/ns-1/"); len(specs) != 2 {
+		t.Fatalf("unexpected route specs %+v", specs)
+	}
+
+	appWatcher.Close()
+	if _, err = appWatcher.Watch(context.Background()); err == nil {
+		t.Fatalf("expect error after close")
+	}
+}
+
+func write(t *testing.T, dir, key, content string) {
+	t.Helper()
+	file := filepath.Join(dir, filepath.FromSlash(key))
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func watch(t *testing.T, w config.Watcher) []config.Spec {
+	t.Helper()
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	specs, err := w.Watch(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return specs
+}
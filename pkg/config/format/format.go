@@ -0,0 +1,167 @@
+// Package format decodes config payloads in yaml, json, java properties and
+// toml into the same structures, so config.Config and typed binding work the
+// same regardless of the format.
+package format
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"path"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/BurntSushi/toml"
+	"github.com/hisonsoft/tsf-go/pkg/config"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	YAML       = "yaml"
+	JSON       = "json"
+	Properties = "properties"
+	TOML       = "toml"
+)
+
+var (
+	mu       sync.RWMutex
+	declared []declaration
+
+	suffixes = map[string]string{
+		".yaml":       YAML,
+		".yml":        YAML,
+		".json":       JSON,
+		".properties": Properties,
+		".toml":       TOML,
+	}
+	// hint 首行注释声明格式，例如 # format: properties
+	hint = regexp.MustCompile(`^#\s*format\s*[:=]\s*(\w+)`)
+)
+
+type declaration struct {
+	pattern string
+	format  string
+}
+
+// Declare declares the format of the keys matching pattern(path.Match syntax,
+// e.g. config/application/*/*/data), a later declaration takes precedence
+func Declare(pattern string, format string) {
+	mu.Lock()
+	defer mu.Unlock()
+	declared = append([]declaration{{pattern, format}}, declared...)
+}
+
+// Detect returns the format of a payload: declared by Declare, then by the
+// suffix of key, then by a first line comment like "# format: toml", a
+// payload starting with { is json, otherwise yaml
+func Detect(key string, b []byte) string {
+	mu.RLock()
+	for _, d := range declared {
+		if ok, _ := path.Match(d.pattern, key); ok {
+			mu.RUnlock()
+			return d.format
+		}
+	}
+	mu.RUnlock()
+	if f, ok := suffixes[strings.ToLower(path.Ext(key))]; ok {
+		return f
+	}
+	b = bytes.TrimSpace(b)
+	line := b
+	if i := bytes.IndexByte(b, '\n'); i >= 0 {
+		line = b[:i]
+	}
+	if m := hint.FindSubmatch(bytes.TrimSpace(line)); m != nil {
+		return strings.ToLower(string(m[1]))
+	}
+	if bytes.HasPrefix(b, []byte("{")) {
+		return JSON
+	}
+	return YAML
+}
+
+// NewData returns the config.Data of a payload, the format is detected by Detect
+func NewData(key string, b []byte) config.Data {
+	if b == nil {
+		return data{}
+	}
+	return data{format: Detect(key, b), raw: b}
+}
+
+// Unmarshal decodes b in format into out, out is decoded as yaml so the yaml
+// struct tags apply to all formats
+func Unmarshal(format string, b []byte, out interface{}) error {
+	var (
+		v   interface{}
+		err error
+	)
+	switch format {
+	case YAML, "yml":
+		return yaml.Unmarshal(b, out)
+	case JSON:
+		dec := json.NewDecoder(bytes.NewReader(b))
+		dec.UseNumber()
+		if err = dec.Decode(&v); err != nil {
+			return err
+		}
+		v = fromJSON(v)
+	case Properties:
+		node, err := parseProperties(b)
+		if err != nil {
+			return err
+		}
+		return node.Decode(out)
+	case TOML:
+		m := map[string]interface{}{}
+		if _, err = toml.Decode(string(b), &m); err != nil {
+			return err
+		}
+		v = m
+	default:
+		return fmt.Errorf("config format %s not supported", format)
+	}
+	var node yaml.Node
+	if err = node.Encode(v); err != nil {
+		return err
+	}
+	return node.Decode(out)
+}
+
+// fromJSON 将json.Number转换为整数或浮点数，避免大整数丢失精度
+func fromJSON(v interface{}) interface{} {
+	switch node := v.(type) {
+	case map[string]interface{}:
+		for k, v := range node {
+			node[k] = fromJSON(v)
+		}
+	case []interface{}:
+		for i, v := range node {
+			node[i] = fromJSON(v)
+		}
+	case json.Number:
+		if i, err := node.Int64(); err == nil {
+			return i
+		}
+		f, _ := node.Float64()
+		return f
+	}
+	return v
+}
+
+type data struct {
+	format string
+	raw    []byte
+}
+
+func (d data) Unmarshal(out interface{}) error {
+	if d.raw == nil {
+		return nil
+	}
+	return Unmarshal(d.format, d.raw, out)
+}
+
+func (d data) Raw() []byte {
+	return d.raw
+}
@@ -0,0 +1,62 @@
+package format
+
+import (
+	"reflect"
+	"testing"
+)
+
+type appConfig struct {
+	Name string `yaml:"name"`
+	DB   struct {
+		Host    string `yaml:"host"`
+		MaxOpen int    `yaml:"maxOpen"`
+		Debug   bool   `yaml:"debug"`
+	} `yaml:"db"`
+	Tags []string `yaml:"tags"`
+}
+
+func TestUnmarshal(t *testing.T) {
+	payloads := map[string]string{
+		"app.yaml": "name: demo\ndb:\n  host: 10.0.0.1\n  maxOpen: 20\n  debug: true\ntags: [a, b]\n",
+		"app.json": `{"name": "demo", "db": {"host": "10.0.0.1", "maxOpen": 20, "debug": true}, "tags": ["a", "b"]}`,
+		"app.toml": "name = \"demo\"\ntags = [\"a\", \"b\"]\n[db]\nhost = \"10.0.0.1\"\nmaxOpen = 20\ndebug = true\n",
+		// 续行、转义及不同的分隔符
+		"app.properties": "# comment\n! comment\nname=de\\\n    mo\ndb.host : 10.0.0.1\ndb.maxOpen 20\ndb.debug=true\n",
+	}
+	for key, payload := range payloads {
+		var c appConfig
+		if err := NewData(key, []byte(payload)).Unmarshal(&c); err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		if c.Name != "demo" || c.DB.Host != "10.0.0.1" || c.DB.MaxOpen != 20 || !c.DB.Debug {
+			t.Fatalf("%s: unexpected config %+v", key, c)
+		}
+		if key != "app.properties" && !reflect.DeepEqual(c.Tags, []string{"a", "b"}) {
+			t.Fatalf("%s: unexpected tags %v", key, c.Tags)
+		}
+		m := map[string]interface{}{}
+		if err := NewData(key, []byte(payload)).Unmarshal(&m); err != nil {
+			t.Fatalf("%s: %v", key, err)
+		}
+		if db, ok := m["db"].(map[string]interface{}); !ok || db["maxOpen"] != 20 {
+			t.Fatalf("%s: unexpected map %v", key, m)
+		}
+	}
+}
+
+func TestDetect(t *testing.T) {
+	Declare("config/application/java-app/*/data", Properties)
+	for _, c := range []struct {
+		key, payload, format string
+	}{
+		{"config/application/java-app/group/data", "a=1", Properties},
+		{"config/application/app/group/data", "# format: toml\na = 1", TOML},
+		{"config/application/app/group/data", " {\"a\": 1}", JSON},
+		{"config/application/app/group/data", "a: 1", YAML},
+		{"route/ns/svc/data.TOML", "a = 1", TOML},
+	} {
+		if f := Detect(c.key, []byte(c.payload)); f != c.format {
+			t.Fatalf("%s: expect %s, got %s", c.key, c.format, f)
+		}
+	}
+}
@@ -0,0 +1,155 @@
+package format
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// propNode 按点号分隔的key构造的树，保持key出现的顺序
+type propNode struct {
+	value    *string
+	keys     []string
+	children map[string]*propNode
+}
+
+func (n *propNode) set(segs []string, value string) {
+	for _, seg := range segs {
+		if n.children == nil {
+			n.children = map[string]*propNode{}
+		}
+		child, ok := n.children[seg]
+		if !ok {
+			child = &propNode{}
+			n.children[seg] = child
+			n.keys = append(n.keys, seg)
+		}
+		// 同一个key不能既是值又有子key，后出现的生效
+		n.value = nil
+		n = child
+	}
+	n.value = &value
+	n.keys, n.children = nil, nil
+}
+
+// node 转换为yaml节点，值不带tag，解码时按yaml规则推断类型
+func (n *propNode) node() *yaml.Node {
+	if n.value != nil {
+		return &yaml.Node{Kind: yaml.ScalarNode, Value: *n.value}
+	}
+	m := &yaml.Node{Kind: yaml.MappingNode, Tag: "!!map"}
+	for _, k := range n.keys {
+		m.Content = append(m.Content, &yaml.Node{Kind: yaml.ScalarNode, Tag: "!!str", Value: k}, n.children[k].node())
+	}
+	return m
+}
+
+// parseProperties 解析java properties，a.b.c=v 按点号展开为嵌套结构
+func parseProperties(b []byte) (*yaml.Node, error) {
+	root := &propNode{}
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	scanner.Buffer(make([]byte, 0, 64*1024), len(b)+1)
+	var logical strings.Builder
+	lineNo := 0
+	for scanner.Scan() {
+		lineNo++
+		line := strings.TrimLeft(scanner.Text(), " \t\f")
+		if logical.Len() == 0 && (line == "" || line[0] == '#' || line[0] == '!') {
+			continue
+		}
+		// 以奇数个反斜杠结尾表示续行
+		if n := len(line) - len(strings.TrimRight(line, "\\")); n%2 == 1 {
+			logical.WriteString(line[:len(line)-1])
+			continue
+		}
+		logical.WriteString(line)
+		key, value, err := splitProperty(logical.String())
+		logical.Reset()
+		if err != nil {
+			return nil, fmt.Errorf("properties line %d: %v", lineNo, err)
+		}
+		if key == "" {
+			continue
+		}
+		root.set(strings.Split(key, "."), value)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if logical.Len() > 0 {
+		key, value, err := splitProperty(logical.String())
+		if err != nil {
+			return nil, fmt.Errorf("properties line %d: %v", lineNo, err)
+		}
+		if key != "" {
+			root.set(strings.Split(key, "."), value)
+		}
+	}
+	return root.node(), nil
+}
+
+// splitProperty key和value以第一个未转义的=、:或空白分隔
+func splitProperty(line string) (key, value string, err error) {
+	end := len(line)
+	for i := 0; i < len(line); i++ {
+		c := line[i]
+		if c == '\\' {
+			i++
+			continue
+		}
+		if c == '=' || c == ':' || c == ' ' || c == '\t' || c == '\f' {
+			end = i
+			break
+		}
+	}
+	rest := strings.TrimLeft(line[end:], " \t\f")
+	if rest != "" && (rest[0] == '=' || rest[0] == ':') {
+		rest = strings.TrimLeft(rest[1:], " \t\f")
+	}
+	if key, err = unescape(line[:end]); err != nil {
+		return
+	}
+	value, err = unescape(rest)
+	return
+}
+
+func unescape(s string) (string, error) {
+	if !strings.Contains(s, "\\") {
+		return s, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(s); i++ {
+		if s[i] != '\\' || i == len(s)-1 {
+			b.WriteByte(s[i])
+			continue
+		}
+		i++
+		switch s[i] {
+		case 't':
+			b.WriteByte('\t')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 'f':
+			b.WriteByte('\f')
+		case 'u':
+			if i+5 > len(s) {
+				return "", fmt.Errorf("invalid unicode escape %q", s[i-1:])
+			}
+			r, err := strconv.ParseUint(s[i+1:i+5], 16, 32)
+			if err != nil {
+				return "", fmt.Errorf("invalid unicode escape %q", s[i-1:i+5])
+			}
+			b.WriteRune(rune(r))
+			i += 4
+		default:
+			b.WriteByte(s[i])
+		}
+	}
+	return b.String(), nil
+}
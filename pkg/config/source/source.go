@@ -0,0 +1,32 @@
+// Package source selects the config source of the rules and application
+// config from env.
+package source
+
+import (
+	"sync"
+
+	"github.com/hisonsoft/tsf-go/pkg/config"
+	"github.com/hisonsoft/tsf-go/pkg/config/consul"
+	"github.com/hisonsoft/tsf-go/pkg/config/file"
+	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+)
+
+var (
+	mu          sync.Mutex
+	defaultFile *file.File
+)
+
+// Default returns the local file source if tsf_config_dir is set,
+// otherwise the consul source
+func Default() config.Source {
+	dir := env.ConfigDir()
+	if dir == "" {
+		return consul.DefaultConsul()
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if defaultFile == nil {
+		defaultFile = file.New(&file.Config{Dir: dir})
+	}
+	return defaultFile
+}
@@ -0,0 +1,695 @@
+// Package consultest provides an in-memory stand-in for the subset of the
+// Consul HTTP API used by the tsf-go SDK, so that registry, discovery and
+// config flows can be tested end-to-end without a real Consul agent.
+package consultest
+
+import (
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// StatusPassing is the check status after a TTL pass
+	StatusPassing = "passing"
+	// StatusWarning is the check status after a TTL warn
+	StatusWarning = "warning"
+	// StatusCritical is the initial status of a TTL check
+	StatusCritical = "critical"
+
+	// NsGlobal is the namespace that nsType=GLOBAL queries resolve to
+	NsGlobal = "global"
+
+	maxWait = 10 * time.Minute
+)
+
+// Service is a registered service instance
+type Service struct {
+	ID                string
+	Service           string
+	Tags              []string
+	Address           string
+	Meta              map[string]string
+	Port              int
+	EnableTagOverride bool
+	CreateIndex       uint64
+	ModifyIndex       uint64
+
+	// namespace the instance was registered into(nid)
+	namespace string
+	checkID   string
+	status    string
+	output    string
+	// stops the http check prober
+	stop chan struct{}
+}
+
+type serviceDefinition struct {
+	ID                string
+	Name              string
+	Tags              []string
+	Address           string
+	Meta              map[string]string
+	Port              int
+	EnableTagOverride bool
+	Check             struct {
+		CheckID  string
+		Status   string
+		HTTP     string
+		Interval json.RawMessage
+	}
+}
+
+type kvPair struct {
+	Key         string
+	CreateIndex uint64
+	ModifyIndex uint64
+	LockIndex   uint64
+	Flags       uint64
+	Value       string
+}
+
+// Server is an in-memory consul stand-in served over HTTP
+type Server struct {
+	srv *httptest.Server
+
+	mu       sync.Mutex
+	index    uint64
+	changed  chan struct{}
+	services map[string]*Service
+	// tombstones of deregistered services: namespace/name -> index
+	svcIndex map[string]uint64
+	catIndex uint64
+	kv       map[string]*kvPair
+	// tombstones of deleted keys: key -> index
+	kvDeleted map[string]uint64
+	requests  map[string]int
+	// acl token required by X-Consul-Token, empty means acl disabled
+	token string
+}
+
+// NewServer starts a consul stand-in on a random local port
+func NewServer() *Server {
+	s := newServer()
+	s.srv.Start()
+	return s
+}
+
+// NewTLSServer starts a consul stand-in served over https with cfg, e.g.
+// requiring client certificates with tls.RequireAndVerifyClientCert
+func NewTLSServer(cfg *tls.Config) *Server {
+	s := newServer()
+	s.srv.TLS = cfg
+	s.srv.StartTLS()
+	return s
+}
+
+func newServer() *Server {
+	s := &Server{
+		index:     1,
+		changed:   make(chan struct{}),
+		services:  make(map[string]*Service),
+		svcIndex:  make(map[string]uint64),
+		kv:        make(map[string]*kvPair),
+		kvDeleted: make(map[string]uint64),
+		requests:  make(map[string]int),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/v1/agent/service/register", s.handleRegister)
+	mux.HandleFunc("/v1/agent/service/deregister/", s.handleDeregister)
+	mux.HandleFunc("/v1/agent/check/pass/", s.handleCheck("/v1/agent/check/pass/", StatusPassing))
+	mux.HandleFunc("/v1/agent/check/warn/", s.handleCheck("/v1/agent/check/warn/", StatusWarning))
+	mux.HandleFunc("/v1/agent/check/fail/", s.handleCheck("/v1/agent/check/fail/", StatusCritical))
+	mux.HandleFunc("/v1/health/service/", s.handleHealth)
+	mux.HandleFunc("/v1/catalog/services", s.handleCatalog)
+	mux.HandleFunc("/v1/kv/", s.handleKV)
+	s.srv = httptest.NewUnstartedServer(s.count(mux))
+	return s
+}
+
+// Addr returns the host:port the server listens on
+func (s *Server) Addr() string {
+	return s.srv.Listener.Addr().String()
+}
+
+// SetToken enables acl: requests without the X-Consul-Token header equal
+// to token are rejected with 403
+func (s *Server) SetToken(token string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.token = token
+}
+
+// Close shuts down the server and unblocks all pending blocking queries
+func (s *Server) Close() {
+	s.mu.Lock()
+	for _, svc := range s.services {
+		if svc.stop != nil {
+			close(svc.stop)
+			svc.stop = nil
+		}
+	}
+	s.notify()
+	s.mu.Unlock()
+	s.srv.CloseClientConnections()
+	s.srv.Close()
+}
+
+// Requests returns how many requests hit the given path prefix
+func (s *Server) Requests(prefix string) (n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for path, count := range s.requests {
+		if strings.HasPrefix(path, prefix) {
+			n += count
+		}
+	}
+	return
+}
+
+// Instances returns the registered instances of the service in namespace,
+// regardless of their check status
+func (s *Server) Instances(namespace string, name string) (res []Service) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, svc := range s.sortedServices() {
+		if svc.namespace == namespace && svc.Service == name {
+			res = append(res, *svc)
+		}
+	}
+	return
+}
+
+// CheckStatus returns the status of the check attached to instance id
+func (s *Server) CheckStatus(id string) (status string, ok bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	svc, ok := s.services[id]
+	if ok {
+		status = svc.status
+	}
+	return
+}
+
+// SetKV writes a raw value into the key/value store
+func (s *Server) SetKV(key string, value []byte) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.setKV(key, value)
+}
+
+// DeleteKV removes a key, or every key under it if recurse is set
+func (s *Server) DeleteKV(key string, recurse bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.deleteKV(key, recurse)
+}
+
+// SetRoute seeds the route rules of a service; v is either raw yaml
+// or a value marshaled as yaml, e.g. []router.RuleGroup
+func (s *Server) SetRoute(namespace string, service string, v interface{}) error {
+	return s.set(fmt.Sprintf("route/%s/%s/data", namespace, service), v)
+}
+
+// SetLaneRule seeds a lane rule, e.g. a lane.LaneRule
+func (s *Server) SetLaneRule(ruleID string, v interface{}) error {
+	return s.set(fmt.Sprintf("lane/rule/%s/data", ruleID), v)
+}
+
+// SetLaneInfo seeds a lane definition, e.g. a lane.LaneInfo
+func (s *Server) SetLaneInfo(laneID string, v interface{}) error {
+	return s.set(fmt.Sprintf("lane/info/%s/data", laneID), v)
+}
+
+// SetAuth seeds the auth rules of a service, e.g. []authenticator.AuthConfig
+func (s *Server) SetAuth(namespace string, service string, v interface{}) error {
+	return s.set(fmt.Sprintf("authority/%s/%s/data", namespace, service), v)
+}
+
+// SetAppConfig seeds the application config of a deploy group
+func (s *Server) SetAppConfig(applicationID string, groupID string, v interface{}) error {
+	return s.set(fmt.Sprintf("config/application/%s/%s/data", applicationID, groupID), v)
+}
+
+// SetGlobalConfig seeds the global config of a namespace
+func (s *Server) SetGlobalConfig(namespace string, v interface{}) error {
+	return s.set(fmt.Sprintf("config/application/%s/data", namespace), v)
+}
+
+func (s *Server) set(key string, v interface{}) error {
+	var value []byte
+	switch data := v.(type) {
+	case []byte:
+		value = data
+	case string:
+		value = []byte(data)
+	default:
+		var err error
+		if value, err = yaml.Marshal(v); err != nil {
+			return err
+		}
+	}
+	s.SetKV(key, value)
+	return nil
+}
+
+func (s *Server) count(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		s.requests[r.URL.Path]++
+		token := s.token
+		s.mu.Unlock()
+		if token != "" && r.Header.Get("X-Consul-Token") != token {
+			http.Error(w, "ACL not found", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// notify must be called with mu held after every write
+func (s *Server) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
+
+func (s *Server) next() uint64 {
+	s.index++
+	return s.index
+}
+
+func (s *Server) sortedServices() []*Service {
+	res := make([]*Service, 0, len(s.services))
+	for _, svc := range s.services {
+		res = append(res, svc)
+	}
+	sort.Slice(res, func(i, j int) bool { return res[i].ID < res[j].ID })
+	return res
+}
+
+func (s *Server) handleRegister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	var sd serviceDefinition
+	if err := json.NewDecoder(r.Body).Decode(&sd); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	if sd.Name == "" {
+		http.Error(w, "missing service name", http.StatusBadRequest)
+		return
+	}
+	if sd.ID == "" {
+		sd.ID = sd.Name
+	}
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	idx := s.next()
+	svc := &Service{
+		ID:                sd.ID,
+		Service:           sd.Name,
+		Tags:              sd.Tags,
+		Address:           sd.Address,
+		Meta:              sd.Meta,
+		Port:              sd.Port,
+		EnableTagOverride: sd.EnableTagOverride,
+		CreateIndex:       idx,
+		ModifyIndex:       idx,
+		namespace:         namespace(r),
+		checkID:           sd.Check.CheckID,
+		status:            StatusCritical,
+	}
+	if svc.checkID == "" {
+		svc.checkID = "service:" + sd.ID
+	}
+	if sd.Check.Status != "" {
+		svc.status = sd.Check.Status
+	}
+	if old, ok := s.services[sd.ID]; ok {
+		svc.CreateIndex = old.CreateIndex
+		// 重复注册时保留原有的健康状态，与consul agent行为一致
+		if sd.Check.Status == "" {
+			svc.status = old.status
+		}
+		s.svcIndex[old.namespace+"/"+old.Service] = idx
+		if old.stop != nil {
+			close(old.stop)
+		}
+	}
+	s.services[sd.ID] = svc
+	if sd.Check.HTTP != "" {
+		svc.stop = make(chan struct{})
+		go s.probe(svc.ID, sd.Check.HTTP, parseDuration(sd.Check.Interval), svc.stop)
+	}
+	s.catIndex = idx
+	s.notify()
+}
+
+func (s *Server) handleDeregister(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPut {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	id := strings.TrimPrefix(r.URL.Path, "/v1/agent/service/deregister/")
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	svc, ok := s.services[id]
+	if !ok {
+		http.Error(w, fmt.Sprintf("Unknown service %q", id), http.StatusNotFound)
+		return
+	}
+	idx := s.next()
+	if svc.stop != nil {
+		close(svc.stop)
+	}
+	delete(s.services, id)
+	s.svcIndex[svc.namespace+"/"+svc.Service] = idx
+	s.catIndex = idx
+	s.notify()
+}
+
+func (s *Server) handleCheck(prefix string, status string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPut {
+			http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+		checkID := strings.TrimPrefix(r.URL.Path, prefix)
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		for _, svc := range s.services {
+			if svc.checkID != checkID {
+				continue
+			}
+			svc.output = r.URL.Query().Get("note")
+			if svc.status != status {
+				svc.status = status
+				svc.ModifyIndex = s.next()
+				s.notify()
+			}
+			return
+		}
+		http.Error(w, fmt.Sprintf("CheckID %q does not have associated TTL", checkID), http.StatusInternalServerError)
+	}
+}
+
+// probe runs a consul HTTP check: 2xx is passing, 429 warning, others critical
+func (s *Server) probe(id string, url string, interval time.Duration, stop chan struct{}) {
+	if interval <= 0 {
+		interval = 10 * time.Second
+	}
+	cli := http.Client{Timeout: 5 * time.Second}
+	for {
+		status := StatusCritical
+		var output string
+		resp, err := cli.Get(url)
+		if err == nil {
+			body, _ := ioutil.ReadAll(resp.Body)
+			resp.Body.Close()
+			output = string(body)
+			if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+				status = StatusPassing
+			} else if resp.StatusCode == http.StatusTooManyRequests {
+				status = StatusWarning
+			}
+		} else {
+			output = err.Error()
+		}
+		s.mu.Lock()
+		select {
+		case <-stop:
+			s.mu.Unlock()
+			return
+		default:
+		}
+		if svc, ok := s.services[id]; ok {
+			svc.output = output
+			if svc.status != status {
+				svc.status = status
+				svc.ModifyIndex = s.next()
+				s.notify()
+			}
+		}
+		s.mu.Unlock()
+		select {
+		case <-stop:
+			return
+		case <-time.After(interval):
+		}
+	}
+}
+
+type node struct {
+	ID         string
+	Node       string
+	Address    string
+	Datacenter string
+}
+
+type check struct {
+	Node        string
+	CheckID     string
+	Name        string
+	Status      string
+	Output      string
+	ServiceID   string
+	ServiceName string
+	ServiceTags []string
+	CreateIndex uint64
+	ModifyIndex uint64
+}
+
+type checkServiceNode struct {
+	Node    node
+	Service Service
+	Checks  []check
+}
+
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, "/v1/health/service/")
+	ns := namespace(r)
+	_, passing := r.URL.Query()["passing"]
+	s.block(w, r, func() (interface{}, uint64, bool) {
+		idx := s.svcIndex[ns+"/"+name]
+		nodes := []checkServiceNode{}
+		for _, svc := range s.sortedServices() {
+			if svc.namespace != ns || svc.Service != name {
+				continue
+			}
+			if svc.ModifyIndex > idx {
+				idx = svc.ModifyIndex
+			}
+			if passing && svc.status != StatusPassing {
+				continue
+			}
+			nodes = append(nodes, checkServiceNode{
+				Node:    node{ID: "consultest", Node: "consultest", Address: "127.0.0.1", Datacenter: "dc1"},
+				Service: *svc,
+				Checks: []check{{
+					Node:        "consultest",
+					CheckID:     svc.checkID,
+					Name:        "Service '" + svc.Service + "' check",
+					Status:      svc.status,
+					Output:      svc.output,
+					ServiceID:   svc.ID,
+					ServiceName: svc.Service,
+					ServiceTags: svc.Tags,
+					CreateIndex: svc.CreateIndex,
+					ModifyIndex: svc.ModifyIndex,
+				}},
+			})
+		}
+		return nodes, idx, true
+	})
+}
+
+func (s *Server) handleCatalog(w http.ResponseWriter, r *http.Request) {
+	ns := namespace(r)
+	s.block(w, r, func() (interface{}, uint64, bool) {
+		services := map[string][]string{}
+		for _, svc := range s.services {
+			if svc.namespace != ns {
+				continue
+			}
+			tags := services[svc.Service]
+			if tags == nil {
+				tags = []string{}
+			}
+			for _, tag := range svc.Tags {
+				if !contains(tags, tag) {
+					tags = append(tags, tag)
+				}
+			}
+			sort.Strings(tags)
+			services[svc.Service] = tags
+		}
+		return services, s.catIndex, true
+	})
+}
+
+func (s *Server) handleKV(w http.ResponseWriter, r *http.Request) {
+	key := strings.TrimPrefix(r.URL.Path, "/v1/kv/")
+	_, recurse := r.URL.Query()["recurse"]
+	switch r.Method {
+	case http.MethodGet:
+		s.block(w, r, func() (interface{}, uint64, bool) {
+			var idx uint64
+			pairs := []kvPair{}
+			for k, pair := range s.kv {
+				if k != key && (!recurse || !strings.HasPrefix(k, key)) {
+					continue
+				}
+				pairs = append(pairs, *pair)
+				if pair.ModifyIndex > idx {
+					idx = pair.ModifyIndex
+				}
+			}
+			for k, deleted := range s.kvDeleted {
+				if (k == key || (recurse && strings.HasPrefix(k, key))) && deleted > idx {
+					idx = deleted
+				}
+			}
+			sort.Slice(pairs, func(i, j int) bool { return pairs[i].Key < pairs[j].Key })
+			if idx == 0 {
+				idx = s.index
+			}
+			return pairs, idx, len(pairs) > 0
+		})
+	case http.MethodPut:
+		value, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		s.SetKV(key, value)
+		w.Write([]byte("true"))
+	case http.MethodDelete:
+		s.DeleteKV(key, recurse)
+		w.Write([]byte("true"))
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (s *Server) setKV(key string, value []byte) {
+	idx := s.next()
+	pair, ok := s.kv[key]
+	if !ok {
+		pair = &kvPair{Key: key, CreateIndex: idx}
+		s.kv[key] = pair
+	}
+	pair.ModifyIndex = idx
+	pair.Value = base64.StdEncoding.EncodeToString(value)
+	delete(s.kvDeleted, key)
+	s.notify()
+}
+
+func (s *Server) deleteKV(key string, recurse bool) {
+	idx := s.next()
+	for k := range s.kv {
+		if k == key || (recurse && strings.HasPrefix(k, key)) {
+			delete(s.kv, k)
+			s.kvDeleted[k] = idx
+		}
+	}
+	s.kvDeleted[key] = idx
+	s.notify()
+}
+
+// block implements consul blocking queries: when the request carries an
+// index, query is re-evaluated on every write until its index moves past
+// the requested one or the wait time elapses.
+func (s *Server) block(w http.ResponseWriter, r *http.Request, query func() (res interface{}, index uint64, found bool)) {
+	var (
+		reqIndex uint64
+		wait     = 5 * time.Minute
+	)
+	if str := r.URL.Query().Get("index"); str != "" {
+		reqIndex, _ = strconv.ParseUint(str, 10, 64)
+	}
+	if str := r.URL.Query().Get("wait"); str != "" {
+		if d, err := time.ParseDuration(str); err == nil {
+			wait = d
+		}
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+
+	s.mu.Lock()
+	for {
+		res, index, found := query()
+		changed := s.changed
+		if reqIndex == 0 || index != reqIndex {
+			s.mu.Unlock()
+			s.reply(w, res, index, found)
+			return
+		}
+		s.mu.Unlock()
+		select {
+		case <-changed:
+		case <-timer.C:
+			s.mu.Lock()
+			res, index, found = query()
+			s.mu.Unlock()
+			s.reply(w, res, index, found)
+			return
+		case <-r.Context().Done():
+			return
+		}
+		s.mu.Lock()
+	}
+}
+
+func (s *Server) reply(w http.ResponseWriter, res interface{}, index uint64, found bool) {
+	w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+	if !found {
+		w.WriteHeader(http.StatusNotFound)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(res)
+}
+
+// parseDuration accepts both "10s" and nanoseconds as sent by time.Duration
+func parseDuration(raw json.RawMessage) time.Duration {
+	var str string
+	if json.Unmarshal(raw, &str) == nil {
+		d, _ := time.ParseDuration(str)
+		return d
+	}
+	var ns int64
+	json.Unmarshal(raw, &ns)
+	return time.Duration(ns)
+}
+
+func namespace(r *http.Request) string {
+	if r.URL.Query().Get("nsType") == "GLOBAL" {
+		return NsGlobal
+	}
+	return r.URL.Query().Get("nid")
+}
+
+func contains(strs []string, s string) bool {
+	for _, str := range strs {
+		if str == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,162 @@
+package consultest
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/registry"
+	"github.com/hisonsoft/tsf-go/naming/consul"
+	cconsul "github.com/hisonsoft/tsf-go/pkg/config/consul"
+)
+
+type destItem struct {
+	DestItemField string `yaml:"destItemField"`
+	DestItemValue string `yaml:"destItemValue"`
+}
+
+type routeRule struct {
+	MicroserviceName string `yaml:"microserviceName"`
+	DestList         []struct {
+		DestItemList []destItem `yaml:"destItemList"`
+	} `yaml:"destList"`
+}
+
+func TestRegistryDiscovery(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	cli := consul.New(&consul.Config{Address: []string{srv.Addr()}, NamespaceID: "ns-test"})
+	ins := &registry.ServiceInstance{
+		ID:        "provider-1",
+		Name:      "provider",
+		Metadata:  map[string]string{"TSF_NAMESPACE_ID": "ns-test"},
+		Endpoints: []string{"grpc://127.0.0.1:9000"},
+	}
+	if err := cli.Register(context.Background(), ins); err != nil {
+		t.Fatalf("register failed!err:=%v", err)
+	}
+	w, err := cli.Watch(context.Background(), "provider_grpc")
+	if err != nil {
+		t.Fatalf("watch failed!err:=%v", err)
+	}
+	defer w.Stop()
+	nodes := next(t, w)
+	if len(nodes) != 1 || nodes[0].ID != "provider-1" {
+		t.Fatalf("expect instance provider-1, got %+v", nodes)
+	}
+
+	if err = cli.Deregister(context.Background(), ins); err != nil {
+		t.Fatalf("deregister failed!err:=%v", err)
+	}
+	if res := srv.Instances("ns-test", "provider_grpc"); len(res) != 0 {
+		t.Fatalf("expect no instance after deregister, got %+v", res)
+	}
+}
+
+func TestHealthPassing(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	cli := consul.New(&consul.Config{Address: []string{srv.Addr()}})
+	w, err := cli.Watch(context.Background(), "provider_http")
+	if err != nil {
+		t.Fatalf("watch failed!err:=%v", err)
+	}
+	defer w.Stop()
+	err = cli.Register(context.Background(), &registry.ServiceInstance{
+		ID:        "provider-2",
+		Name:      "provider",
+		Endpoints: []string{"http://127.0.0.1:8000"},
+	})
+	if err != nil {
+		t.Fatalf("register failed!err:=%v", err)
+	}
+	if nodes := next(t, w); len(nodes) != 1 {
+		t.Fatalf("expect 1 passing instance, got %+v", nodes)
+	}
+	if status, _ := srv.CheckStatus("provider-2"); status != StatusPassing {
+		t.Fatalf("expect check passing, got %s", status)
+	}
+}
+
+func TestSeedRoute(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	var rule routeRule
+	rule.MicroserviceName = "provider"
+	rule.DestList = append(rule.DestList, struct {
+		DestItemList []destItem `yaml:"destItemList"`
+	}{DestItemList: []destItem{{DestItemField: "TSF_GROUP_ID", DestItemValue: "group-1"}}})
+	if err := srv.SetRoute("ns-test", "provider", []routeRule{rule}); err != nil {
+		t.Fatalf("set route failed!err:=%v", err)
+	}
+
+	source := cconsul.New(&cconsul.Config{Address: srv.Addr()})
+	watcher := source.Subscribe("route/ns-test/")
+	defer watcher.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	specs, err := watcher.Watch(ctx)
+	if err != nil {
+		t.Fatalf("watch route failed!err:=%v", err)
+	}
+	if len(specs) != 1 || specs[0].Key != "route/ns-test/provider/data" {
+		t.Fatalf("unexpected route specs %+v", specs)
+	}
+	var res []routeRule
+	if err = specs[0].Data.Unmarshal(&res); err != nil {
+		t.Fatalf("unmarshal route failed!err:=%v", err)
+	}
+	if len(res) != 1 || res[0].DestList[0].DestItemList[0].DestItemValue != "group-1" {
+		t.Fatalf("unexpected route %+v", res)
+	}
+
+	srv.DeleteKV("route/ns-test/", true)
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	specs, err = watcher.Watch(ctx)
+	if err != nil {
+		t.Fatalf("watch route failed!err:=%v", err)
+	}
+	if len(specs) != 0 {
+		t.Fatalf("expect route deleted, got %+v", specs)
+	}
+}
+
+func TestBlockingQuery(t *testing.T) {
+	srv := NewServer()
+	defer srv.Close()
+
+	srv.SetKV("config/application/ns-test/data", []byte("a: 1"))
+	source := cconsul.New(&cconsul.Config{Address: srv.Addr()})
+	watcher := source.Subscribe("config/application/ns-test/data")
+	defer watcher.Close()
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second*3)
+	defer cancel()
+	if _, err := watcher.Watch(ctx); err != nil {
+		t.Fatalf("watch config failed!err:=%v", err)
+	}
+	// 数据不变的情况下，long poll应当一直阻塞而不是频繁请求
+	before := srv.Requests("/v1/kv/")
+	time.Sleep(time.Second * 2)
+	if after := srv.Requests("/v1/kv/"); after-before > 1 {
+		t.Fatalf("expect blocking query, got %d requests", after-before)
+	}
+}
+
+func next(t *testing.T, w registry.Watcher) []*registry.ServiceInstance {
+	res := make(chan []*registry.ServiceInstance, 1)
+	go func() {
+		nodes, _ := w.Next()
+		res <- nodes
+	}()
+	select {
+	case nodes := <-res:
+		return nodes
+	case <-time.After(time.Second * 5):
+		t.Fatalf("wait discovery event timeout")
+	}
+	return nil
+}
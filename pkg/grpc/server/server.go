@@ -14,7 +14,7 @@ import (
 	"github.com/hisonsoft/tsf-go/log"
 	"github.com/hisonsoft/tsf-go/pkg/auth"
 	"github.com/hisonsoft/tsf-go/pkg/auth/authenticator"
-	cfgConsul "github.com/hisonsoft/tsf-go/pkg/config/consul"
+	"github.com/hisonsoft/tsf-go/pkg/config/source"
 	tgrpc "github.com/hisonsoft/tsf-go/pkg/grpc"         // NOTE: open json encoding by set header Content-Type: application/grpc+json
 	"github.com/hisonsoft/tsf-go/pkg/grpc/encoding/json" // NOTE: open json encoding by set header Content-Type: application/grpc+json
 	"github.com/hisonsoft/tsf-go/pkg/naming"
@@ -102,7 +102,7 @@ func NewServer(conf *Config, o ...grpc.ServerOption) (s *Server) {
 	opts = append(opts, o...)
 	s.Server = grpc.NewServer(opts...)
 	builder := &authenticator.Builder{}
-	s.authen = builder.Build(cfgConsul.DefaultConsul(), naming.NewService(env.NamespaceID(), conf.ServerName))
+	s.authen = builder.Build(source.Default(), naming.NewService(env.NamespaceID(), conf.ServerName))
 	s.Use(s.recovery, s.handle)
 	s.UseStream(s.recoveryStream, s.handleStream)
 
@@ -2,23 +2,32 @@ package http
 
 import (
 	"bytes"
+	"context"
+	"crypto/tls"
 	"encoding/json"
 	"io"
 	"io/ioutil"
 	"net"
 	"net/http"
+	"sync"
 	"time"
 
 	"github.com/go-kratos/kratos/v2/errors"
 )
 
 type Client struct {
-	cli *http.Client
+	opts *options
+
+	mu       sync.Mutex
+	cli      *http.Client
+	reloader *tlsReloader
 }
 
 type options struct {
 	timeout         time.Duration
 	maxConnsPerHost int
+	tls             *TLSConfig
+	header          func(http.Header)
 }
 
 // Option configures how we set up the client.
@@ -56,11 +65,41 @@ func WithMaxConnPerHost(max int) Option {
 	})
 }
 
+// WithTLS returns a Option that enables https, the certificate files are
+// reloaded when they change.
+func WithTLS(conf *TLSConfig) Option {
+	return newFuncOption(func(o *options) {
+		o.tls = conf
+	})
+}
+
+// WithHeader returns a Option that sets headers on every request, e.g. a
+// token which may be rotated at runtime.
+func WithHeader(f func(header http.Header)) Option {
+	return newFuncOption(func(o *options) {
+		o.header = f
+	})
+}
+
 func NewClient(optFunc ...Option) *Client {
 	opts := &options{}
 	for _, f := range optFunc {
 		f.apply(opts)
 	}
+	c := &Client{opts: opts}
+	var tlsConfig *tls.Config
+	if opts.tls != nil {
+		if r, err := newTLSReloader(opts.tls); err == nil {
+			c.reloader = r
+			tlsConfig, _ = r.load()
+		}
+	}
+	c.cli = c.newClient(tlsConfig)
+	return c
+}
+
+func (c *Client) newClient(tlsConfig *tls.Config) *http.Client {
+	opts := c.opts
 	transport := &http.Transport{
 		Proxy: http.ProxyFromEnvironment,
 		DialContext: (&net.Dialer{
@@ -73,11 +112,37 @@ func NewClient(optFunc ...Option) *Client {
 		ExpectContinueTimeout: 1 * time.Second,
 		MaxIdleConnsPerHost:   6,
 		MaxConnsPerHost:       opts.maxConnsPerHost,
+		TLSClientConfig:       tlsConfig,
 	}
-	return &Client{cli: &http.Client{
+	return &http.Client{
 		Timeout:   opts.timeout,
 		Transport: transport,
-	}}
+	}
+}
+
+// client returns the http client, rebuilding it when the tls files changed
+func (c *Client) client() (*http.Client, error) {
+	if c.opts.tls == nil {
+		return c.cli, nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.reloader == nil {
+		// 启动时证书加载失败，重试加载
+		r, err := newTLSReloader(c.opts.tls)
+		if err != nil {
+			return nil, err
+		}
+		c.reloader = r
+	}
+	tlsConfig, _ := c.reloader.load()
+	if c.cli.Transport.(*http.Transport).TLSClientConfig != tlsConfig {
+		old := c.cli
+		c.cli = c.newClient(tlsConfig)
+		// 正在进行的长轮询请求不受影响，空闲连接关闭后使用新证书重建
+		old.CloseIdleConnections()
+	}
+	return c.cli, nil
 }
 
 // Get http get
@@ -86,6 +151,12 @@ func (c *Client) Get(url string, respBody interface{}) (header http.Header, err
 	return
 }
 
+// GetContext http get, the request is aborted when ctx is done
+func (c *Client) GetContext(ctx context.Context, url string, respBody interface{}) (header http.Header, err error) {
+	header, err = c.DoContext(ctx, "GET", url, nil, respBody)
+	return
+}
+
 // Put http put
 func (c *Client) Put(url string, reqBody interface{}, respBody interface{}) (err error) {
 	_, err = c.Do("PUT", url, reqBody, respBody)
@@ -100,6 +171,11 @@ func (c *Client) Post(url string, reqBody interface{}, respBody interface{}) (er
 
 // Do http do
 func (c *Client) Do(method string, url string, reqBody interface{}, respBody interface{}) (header http.Header, err error) {
+	return c.DoContext(context.Background(), method, url, reqBody, respBody)
+}
+
+// DoContext http do, the request is aborted when ctx is done
+func (c *Client) DoContext(ctx context.Context, method string, url string, reqBody interface{}, respBody interface{}) (header http.Header, err error) {
 	var (
 		resp    *http.Response
 		content []byte
@@ -113,14 +189,21 @@ func (c *Client) Do(method string, url string, reqBody interface{}, respBody int
 		}
 		body = bytes.NewReader(content)
 	}
-	req, err = http.NewRequest(method, url, body)
+	req, err = http.NewRequestWithContext(ctx, method, url, body)
 	if err != nil {
 		return
 	}
 	if body != nil {
 		req.Header.Add("Content-Type", "application/json")
 	}
-	resp, err = c.cli.Do(req)
+	if c.opts.header != nil {
+		c.opts.header(req.Header)
+	}
+	cli, err := c.client()
+	if err != nil {
+		return
+	}
+	resp, err = cli.Do(req)
 	if err != nil {
 		return
 	}
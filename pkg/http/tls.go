@@ -0,0 +1,266 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+)
+
+// TLSConfig configures https with a CA bundle and an optional client
+// certificate. The files are watched and reloaded when they change.
+type TLSConfig struct {
+	// CAFile is the PEM CA bundle used to verify the server, system roots if empty
+	CAFile string
+	// CertFile and KeyFile are the PEM client certificate and key
+	CertFile string
+	KeyFile  string
+	// ServerName is the host name the server certificate must be issued for.
+	// The instances found by discovery are ip addresses, so the host name is
+	// not verified if it is empty: any certificate signed by CAFile is
+	// accepted, set ServerName or ServerSANs to pin the server identity.
+	ServerName string
+	// ServerSANs allows the server certificates having any of the SANs(dns,
+	// uri, ip, email), e.g. spiffe://cluster.local/ns/default/sa/provider
+	ServerSANs         []string
+	InsecureSkipVerify bool
+}
+
+// ConsulTLSConfig returns the https config of the consul agents from env,
+// nil if tsf_consul_tls is not enabled
+func ConsulTLSConfig() *TLSConfig {
+	if !env.ConsulTLS() {
+		return nil
+	}
+	return &TLSConfig{
+		CAFile:             env.ConsulCAFile(),
+		CertFile:           env.ConsulCertFile(),
+		KeyFile:            env.ConsulKeyFile(),
+		InsecureSkipVerify: env.ConsulTLSSkipVerify(),
+	}
+}
+
+// tlsReloader rebuilds the tls.Config whenever one of the files changes
+type tlsReloader struct {
+	conf     *TLSConfig
+	interval time.Duration
+
+	mu       sync.Mutex
+	checked  time.Time
+	modTimes []time.Time
+	tls      *tls.Config
+}
+
+func newTLSReloader(conf *TLSConfig) (*tlsReloader, error) {
+	r := &tlsReloader{conf: conf, interval: time.Second * 10}
+	if _, err := r.load(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// load returns the current tls.Config, rebuilt if the files changed
+func (r *tlsReloader) load() (*tls.Config, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tls != nil && time.Since(r.checked) < r.interval {
+		return r.tls, nil
+	}
+	r.checked = time.Now()
+	modTimes := r.stat()
+	if r.tls != nil && equalTimes(modTimes, r.modTimes) {
+		return r.tls, nil
+	}
+	cfg, err := r.conf.build()
+	if err != nil {
+		if r.tls != nil {
+			// 证书文件替换过程中可能不完整，继续使用旧的配置
+			return r.tls, err
+		}
+		return nil, err
+	}
+	r.tls = cfg
+	r.modTimes = modTimes
+	return cfg, nil
+}
+
+func (r *tlsReloader) stat() []time.Time {
+	var res []time.Time
+	for _, file := range []string{r.conf.CAFile, r.conf.CertFile, r.conf.KeyFile} {
+		var t time.Time
+		if file != "" {
+			if fi, err := os.Stat(file); err == nil {
+				t = fi.ModTime()
+			}
+		}
+		res = append(res, t)
+	}
+	return res
+}
+
+func (c *TLSConfig) build() (*tls.Config, error) {
+	cfg := &tls.Config{
+		ServerName:         c.ServerName,
+		InsecureSkipVerify: c.InsecureSkipVerify,
+	}
+	if c.CAFile != "" {
+		ca, err := ioutil.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(ca) {
+			return nil, fmt.Errorf("no valid certificate found in ca file %s", c.CAFile)
+		}
+		cfg.RootCAs = pool
+	}
+	if c.CertFile != "" || c.KeyFile != "" {
+		cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+		if err != nil {
+			return nil, err
+		}
+		cfg.Certificates = []tls.Certificate{cert}
+	}
+	return cfg, nil
+}
+
+func equalTimes(a, b []time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !a[i].Equal(b[i]) {
+			return false
+		}
+	}
+	return true
+}
+
+// ServerConfig returns a tls.Config for grpc and http servers, e.g.
+// grpc.TLSConfig(cfg). CertFile and KeyFile are the server certificate, the
+// clients must present a certificate signed by CAFile if it is set. The
+// files are reloaded for the new connections when they change.
+func (c *TLSConfig) ServerConfig() (*tls.Config, error) {
+	r, err := newTLSReloader(c)
+	if err != nil {
+		return nil, err
+	}
+	return r.serverConfig(), nil
+}
+
+func (r *tlsReloader) serverConfig() *tls.Config {
+	return &tls.Config{
+		// grpc要求协商h2，保留一份静态配置的NextProtos
+		NextProtos: []string{"h2", "http/1.1"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cur, err := r.load()
+			if err != nil && cur == nil {
+				return nil, err
+			}
+			cfg := &tls.Config{
+				Certificates: cur.Certificates,
+				NextProtos:   []string{"h2", "http/1.1"},
+			}
+			if cur.RootCAs != nil {
+				cfg.ClientCAs = cur.RootCAs
+				cfg.ClientAuth = tls.RequireAndVerifyClientCert
+			}
+			return cfg, nil
+		},
+	}
+}
+
+// ClientConfig returns a tls.Config for grpc and http clients. The server is
+// verified against CAFile(system roots if empty), and against ServerName and
+// ServerSANs only if they are set: the instances found by discovery usually
+// have no host name to verify.
+// CertFile and KeyFile are the client certificate. The files are reloaded for
+// the new connections when they change.
+func (c *TLSConfig) ClientConfig() (*tls.Config, error) {
+	r, err := newTLSReloader(c)
+	if err != nil {
+		return nil, err
+	}
+	return r.clientConfig(), nil
+}
+
+func (r *tlsReloader) clientConfig() *tls.Config {
+	return &tls.Config{
+		// 标准校验使用的是创建时的RootCAs，证书轮换后改为在VerifyPeerCertificate中校验
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			cur, err := r.load()
+			if err != nil && cur == nil {
+				return nil, err
+			}
+			if len(cur.Certificates) == 0 {
+				return &tls.Certificate{}, nil
+			}
+			return &cur.Certificates[0], nil
+		},
+		VerifyPeerCertificate: func(raw [][]byte, _ [][]*x509.Certificate) error {
+			if r.conf.InsecureSkipVerify {
+				return nil
+			}
+			cur, err := r.load()
+			if err != nil && cur == nil {
+				return err
+			}
+			return verifyPeer(raw, cur.RootCAs, r.conf.ServerName, r.conf.ServerSANs)
+		},
+	}
+}
+
+func verifyPeer(raw [][]byte, roots *x509.CertPool, serverName string, sans []string) error {
+	if len(raw) == 0 {
+		return fmt.Errorf("no certificate presented by the server")
+	}
+	certs := make([]*x509.Certificate, 0, len(raw))
+	for _, b := range raw {
+		cert, err := x509.ParseCertificate(b)
+		if err != nil {
+			return err
+		}
+		certs = append(certs, cert)
+	}
+	opts := x509.VerifyOptions{
+		Roots:         roots,
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range certs[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(opts); err != nil {
+		return err
+	}
+	if len(sans) == 0 || matchSAN(certs[0], sans) {
+		return nil
+	}
+	return fmt.Errorf("server certificate %s has none of the allowed SANs %v", certs[0].Subject.CommonName, sans)
+}
+
+func matchSAN(cert *x509.Certificate, sans []string) bool {
+	var names []string
+	names = append(names, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		names = append(names, u.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		names = append(names, ip.String())
+	}
+	names = append(names, cert.EmailAddresses...)
+	for _, name := range names {
+		for _, san := range sans {
+			if name == san {
+				return true
+			}
+		}
+	}
+	return false
+}
@@ -0,0 +1,192 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/internal/testcert"
+)
+
+// leaf 服务端及客户端证书的模板
+func leaf(usage x509.ExtKeyUsage) *x509.Certificate {
+	return &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{usage},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	}
+}
+
+func TestTLSReload(t *testing.T) {
+	ca := testcert.NewCA(t)
+	srvCert, srvKey := ca.Issue(t, "consul", leaf(x509.ExtKeyUsageServerAuth))
+	pair, err := tls.X509KeyPair(srvCert, srvKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"name":"` + r.TLS.PeerCertificates[0].Subject.CommonName + `"}`))
+	}))
+	srv.TLS = &tls.Config{Certificates: []tls.Certificate{pair}, ClientCAs: pool, ClientAuth: tls.RequireAndVerifyClientCert}
+	srv.StartTLS()
+	defer srv.Close()
+
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	conf := &TLSConfig{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "client.pem"),
+		KeyFile:  filepath.Join(dir, "client-key.pem"),
+	}
+	writeCert := func(name string) {
+		cert, key := ca.Issue(t, name, leaf(x509.ExtKeyUsageClientAuth))
+		testcert.WriteFiles(t, map[string][]byte{conf.CAFile: ca.PEM, conf.CertFile: cert, conf.KeyFile: key})
+	}
+	writeCert("client-1")
+
+	cli := NewClient(WithTimeout(time.Second*5), WithTLS(conf))
+	var res struct{ Name string }
+	if _, err = cli.Get(srv.URL, &res); err != nil || res.Name != "client-1" {
+		t.Fatalf("https request failed!name:=%s err:=%v", res.Name, err)
+	}
+
+	// 证书文件更新后，新建立的连接使用新证书
+	writeCert("client-2")
+	future := time.Now().Add(time.Second)
+	for _, file := range []string{conf.CAFile, conf.CertFile, conf.KeyFile} {
+		os.Chtimes(file, future, future)
+	}
+	cli.reloader.interval = 0
+	if _, err = cli.Get(srv.URL, &res); err != nil || res.Name != "client-2" {
+		t.Fatalf("expect reloaded certificate, name:=%s err:=%v", res.Name, err)
+	}
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := testcert.NewCA(t)
+	dir, err := ioutil.TempDir("", "mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	write := func(conf *TLSConfig, name string, usage x509.ExtKeyUsage) {
+		cert, key := ca.Issue(t, name, leaf(usage))
+		testcert.WriteFiles(t, map[string][]byte{conf.CAFile: ca.PEM, conf.CertFile: cert, conf.KeyFile: key})
+		// 保证修改时间变化
+		future := time.Now().Add(time.Second)
+		for _, file := range []string{conf.CAFile, conf.CertFile, conf.KeyFile} {
+			os.Chtimes(file, future, future)
+		}
+	}
+	srvConf := &TLSConfig{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "server.pem"),
+		KeyFile:  filepath.Join(dir, "server-key.pem"),
+	}
+	cliConf := &TLSConfig{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "client.pem"),
+		KeyFile:  filepath.Join(dir, "client-key.pem"),
+	}
+	write(srvConf, "server", x509.ExtKeyUsageServerAuth)
+	write(cliConf, "client-1", x509.ExtKeyUsageClientAuth)
+
+	srvTLS, err := srvConf.ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := httptest.NewUnstartedServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(r.TLS.VerifiedChains[0][0].Subject.CommonName))
+	}))
+	srv.TLS = srvTLS
+	srv.StartTLS()
+	defer srv.Close()
+
+	get := func(cfg *tls.Config) (string, error) {
+		cli := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg, DisableKeepAlives: true}}
+		resp, err := cli.Get(srv.URL)
+		if err != nil {
+			return "", err
+		}
+		defer resp.Body.Close()
+		b, err := ioutil.ReadAll(resp.Body)
+		return string(b), err
+	}
+	cliTLS, err := cliConf.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name, err := get(cliTLS); err != nil || name != "client-1" {
+		t.Fatalf("mtls request failed!name:=%s err:=%v", name, err)
+	}
+
+	// 未携带客户端证书的请求被拒绝
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Cert)
+	if _, err = get(&tls.Config{RootCAs: pool}); err == nil {
+		t.Fatalf("expect request without client certificate rejected")
+	}
+	// 服务端证书不是由CA签发时客户端拒绝
+	other := testcert.NewCA(t)
+	otherConf := *cliConf
+	otherConf.CAFile = filepath.Join(dir, "other-ca.pem")
+	testcert.WriteFiles(t, map[string][]byte{otherConf.CAFile: other.PEM})
+	otherTLS, err := otherConf.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = get(otherTLS); err == nil {
+		t.Fatalf("expect server certificate rejected")
+	}
+	// 校验ServerName
+	named := *cliConf
+	named.ServerName = "not-the-server"
+	namedTLS, err := named.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = get(namedTLS); err == nil {
+		t.Fatalf("expect server name mismatch")
+	}
+
+	// 校验ServerSANs
+	pinned := *cliConf
+	pinned.ServerSANs = []string{"10.0.0.1"}
+	pinnedTLS, err := pinned.ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = get(pinnedTLS); err == nil {
+		t.Fatalf("expect server san mismatch")
+	}
+	pinned.ServerSANs = []string{"10.0.0.1", "127.0.0.1"}
+	if pinnedTLS, err = pinned.ClientConfig(); err != nil {
+		t.Fatal(err)
+	}
+	if name, err := get(pinnedTLS); err != nil || name != "client-1" {
+		t.Fatalf("expect server san matched, name:=%s err:=%v", name, err)
+	}
+
+	// 证书轮换后新的连接使用新证书
+	r, err := newTLSReloader(cliConf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cliTLS = r.clientConfig()
+	r.interval = 0
+	write(cliConf, "client-2", x509.ExtKeyUsageClientAuth)
+	if name, err := get(cliTLS); err != nil || name != "client-2" {
+		t.Fatalf("expect rotated certificate, name:=%s err:=%v", name, err)
+	}
+}
@@ -0,0 +1,221 @@
+// Package identity signs the identity of the caller propagated in the
+// metadata(service name, application, group...), so that the server can
+// trust it before using it for auth and routing.
+package identity
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/hisonsoft/tsf-go/log"
+	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+)
+
+const version = "v1"
+
+var (
+	mu            sync.Mutex
+	defaultSigner *Signer
+	defaultInit   bool
+)
+
+// Identity is the identity of a caller
+type Identity struct {
+	Namespace          string
+	ApplicationID      string
+	GroupID            string
+	ServiceName        string
+	ApplicationVersion string
+}
+
+// Target is the callee of a signed identity, so that a signature received by
+// a service can't be replayed to other services or operations
+type Target struct {
+	Namespace string
+	Service   string
+	// Operation is the grpc method or the http method and path
+	Operation string
+}
+
+// KeyFunc returns the HMAC key of a namespace
+type KeyFunc func(namespace string) ([]byte, error)
+
+type Config struct {
+	Key KeyFunc
+	// MaxSkew is the max clock skew between the caller and the server, default 30s
+	MaxSkew time.Duration
+	// Enforce rejects the requests with an unsigned or invalid identity,
+	// otherwise the identity is only marked untrusted
+	Enforce bool
+}
+
+// Signer signs and verifies identities
+type Signer struct {
+	conf Config
+}
+
+func New(conf Config) *Signer {
+	if conf.MaxSkew <= 0 {
+		conf.MaxSkew = 30 * time.Second
+	}
+	return &Signer{conf: conf}
+}
+
+// DefaultSigner returns the signer configured by tsf_identity_key_file and
+// tsf_identity_enforce, nil if no key is configured
+func DefaultSigner() *Signer {
+	mu.Lock()
+	defer mu.Unlock()
+	if !defaultInit {
+		defaultInit = true
+		if path := env.IdentityKeyFile(); path != "" {
+			defaultSigner = New(Config{Key: FileKey(path), Enforce: env.IdentityEnforce()})
+		}
+	}
+	return defaultSigner
+}
+
+// SetDefaultSigner replaces the default signer, nil disables signing
+func SetDefaultSigner(s *Signer) {
+	mu.Lock()
+	defer mu.Unlock()
+	defaultSigner = s
+	defaultInit = true
+}
+
+// Enforce reports whether invalid identities are rejected
+func (s *Signer) Enforce() bool {
+	return s.conf.Enforce
+}
+
+// Sign returns the signature of id calling target:
+// v1.<unix seconds>.<base64 hmac>
+func (s *Signer) Sign(id Identity, target Target, now time.Time) (string, error) {
+	key, err := s.conf.Key(id.Namespace)
+	if err != nil {
+		return "", err
+	}
+	ts := strconv.FormatInt(now.Unix(), 10)
+	return version + "." + ts + "." + mac(key, ts, id, target), nil
+}
+
+// Verify checks the signature of id calling target
+func (s *Signer) Verify(id Identity, target Target, sig string, now time.Time) error {
+	if sig == "" {
+		return errors.Unauthorized("IdentityUnsigned", "identity not signed")
+	}
+	parts := strings.SplitN(sig, ".", 3)
+	if len(parts) != 3 || parts[0] != version {
+		return errors.Unauthorized("IdentityInvalid", "identity signature malformed")
+	}
+	ts, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return errors.Unauthorized("IdentityInvalid", "identity signature malformed")
+	}
+	if skew := now.Sub(time.Unix(ts, 0)); skew > s.conf.MaxSkew || skew < -s.conf.MaxSkew {
+		return errors.Unauthorized("IdentityExpired", "identity signature expired")
+	}
+	key, err := s.conf.Key(id.Namespace)
+	if err != nil {
+		return errors.Unauthorized("IdentityInvalid", fmt.Sprintf("no identity key of namespace %s", id.Namespace))
+	}
+	if !hmac.Equal([]byte(parts[2]), []byte(mac(key, parts[1], id, target))) {
+		return errors.Unauthorized("IdentityInvalid", "identity signature mismatch")
+	}
+	return nil
+}
+
+func mac(key []byte, ts string, id Identity, target Target) string {
+	h := hmac.New(sha256.New, key)
+	h.Write([]byte(strings.Join([]string{version, ts, id.Namespace, id.ApplicationID, id.GroupID, id.ServiceName, id.ApplicationVersion,
+		target.Namespace, target.Service, target.Operation}, "\n")))
+	return base64.RawURLEncoding.EncodeToString(h.Sum(nil))
+}
+
+// FileKey reads the keys from a file which is reloaded when it changes, the
+// file contains either a single key for all namespaces or a
+// <namespace>:<key> per line, # starts a comment.
+func FileKey(path string) KeyFunc {
+	k := &fileKey{path: path, interval: 10 * time.Second}
+	return k.key
+}
+
+type fileKey struct {
+	path     string
+	interval time.Duration
+
+	mu      sync.Mutex
+	checked time.Time
+	modTime time.Time
+	all     []byte
+	keys    map[string][]byte
+}
+
+func (k *fileKey) key(namespace string) ([]byte, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if k.checked.IsZero() || time.Since(k.checked) >= k.interval {
+		k.checked = time.Now()
+		if err := k.reload(); err != nil {
+			if k.keys == nil && k.all == nil {
+				return nil, err
+			}
+			// 密钥文件替换过程中可能不完整，继续使用旧的密钥
+			log.DefaultLog.Errorw("msg", "[identity] reload key file failed!", "path", k.path, "err", err)
+		}
+	}
+	if key, ok := k.keys[namespace]; ok {
+		return key, nil
+	}
+	if k.all != nil {
+		return k.all, nil
+	}
+	return nil, fmt.Errorf("no identity key of namespace %s", namespace)
+}
+
+func (k *fileKey) reload() error {
+	info, err := os.Stat(k.path)
+	if err != nil {
+		return err
+	}
+	if info.ModTime().Equal(k.modTime) && (k.keys != nil || k.all != nil) {
+		return nil
+	}
+	b, err := ioutil.ReadFile(k.path)
+	if err != nil {
+		return err
+	}
+	var (
+		all  []byte
+		keys = map[string][]byte{}
+	)
+	scanner := bufio.NewScanner(bytes.NewReader(b))
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		// base64的密钥可能以=结尾，因此以冒号分隔命名空间
+		if i := strings.IndexByte(line, ':'); i > 0 {
+			keys[strings.TrimSpace(line[:i])] = []byte(strings.TrimSpace(line[i+1:]))
+		} else {
+			all = []byte(line)
+		}
+	}
+	if len(keys) == 0 && all == nil {
+		return fmt.Errorf("identity key file %s is empty", k.path)
+	}
+	k.modTime, k.all, k.keys = info.ModTime(), all, keys
+	return nil
+}
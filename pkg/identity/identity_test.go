@@ -0,0 +1,63 @@
+package identity
+
+import (
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+)
+
+func TestSignVerify(t *testing.T) {
+	f, err := ioutil.TempFile("", "identity-key")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("# keys\nns-1: a2V5LTE=\nns-2: a2V5LTI=\n")
+	f.Close()
+
+	s := New(Config{Key: FileKey(f.Name())})
+	id := Identity{Namespace: "ns-1", ApplicationID: "app-1", GroupID: "group-1", ServiceName: "provider", ApplicationVersion: "1.0"}
+	target := Target{Namespace: "ns-1", Service: "consumer", Operation: "/helloworld.Greeter/SayHello"}
+	now := time.Now()
+	sig, err := s.Sign(id, target, now)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = s.Verify(id, target, sig, now.Add(20*time.Second)); err != nil {
+		t.Fatalf("expect verified, got %v", err)
+	}
+
+	spoofed := id
+	spoofed.ServiceName = "admin"
+	otherNs := id
+	otherNs.Namespace = "ns-2"
+	otherSvc := target
+	otherSvc.Service = "admin"
+	otherOp := target
+	otherOp.Operation = "/helloworld.Greeter/Delete"
+	otherDest := target
+	otherDest.Namespace = "ns-2"
+	for name, c := range map[string]struct {
+		id     Identity
+		target Target
+		sig    string
+		now    time.Time
+	}{
+		"unsigned":       {id, target, "", now},
+		"spoofed":        {spoofed, target, sig, now},
+		"namespace":      {otherNs, target, sig, now},
+		"replay service": {id, otherSvc, sig, now},
+		"replay op":      {id, otherOp, sig, now},
+		"replay ns":      {id, otherDest, sig, now},
+		"expired":        {id, target, sig, now.Add(time.Minute)},
+		"malformed":      {id, target, "v1.x.y", now},
+		"no key":         {Identity{Namespace: "ns-3"}, target, sig, now},
+	} {
+		if err = s.Verify(c.id, c.target, c.sig, c.now); !errors.IsUnauthorized(err) {
+			t.Fatalf("%s: expect unauthorized, got %v", name, err)
+		}
+	}
+}
@@ -21,6 +21,18 @@ const (
 
 	Tracer = "tsf.tracer"
 	LaneID = "lane.id"
+
+	// IdentitySignature carries the signature of the caller identity
+	IdentitySignature = "tsf-identity"
+	// IdentityTrusted is "true" if the source identity is verified, "false"
+	// if it is unsigned or invalid, empty if identity signing is disabled
+	IdentityTrusted = "identity.trusted"
+
+	// TLSSAN is the comma separated SANs(dns, uri, ip, email) of the verified
+	// peer certificate, use it with meta.SourceKey
+	TLSSAN = "tls.san"
+	// TLSCommonName is the subject common name of the verified peer certificate
+	TLSCommonName = "tls.cn"
 )
 
 var carriedKey = map[string]struct{}{
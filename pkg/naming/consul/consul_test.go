@@ -30,7 +30,7 @@ var subscribe int
 
 func TestMain(m *testing.M) {
 	// 这两个参数必传
-	flag.StringVar(&consulAddr, "consulAddr", "127.0.0.1:8500", "-consulAddr 127.0.0.1:8500")
+	flag.StringVar(&consulAddr, "consulAddr", "", "-consulAddr 127.0.0.1:8500")
 	flag.StringVar(&token, "token", "", "-token")
 
 	flag.IntVar(&serviceNum, "serviceNum", 2, "-serviceNum 4")
@@ -47,6 +47,9 @@ func TestMain(m *testing.M) {
 }
 
 func TestConsul(t *testing.T) {
+	if consulAddr == "" {
+		t.Skip("stress test against a real consul agent, run with -consulAddr")
+	}
 	ctx, cancel := context.WithTimeout(context.Background(), time.Hour*4)
 	defer cancel()
 	fmt.Println("param: ", serviceNum, nidStart, nidNum, insNum, consulAddr, token)
@@ -9,7 +9,7 @@ import (
 	"github.com/go-kratos/kratos/v2/errors"
 	"github.com/hisonsoft/tsf-go/log"
 	"github.com/hisonsoft/tsf-go/pkg/config"
-	"github.com/hisonsoft/tsf-go/pkg/config/consul"
+	"github.com/hisonsoft/tsf-go/pkg/config/source"
 	"github.com/hisonsoft/tsf-go/pkg/meta"
 	"github.com/hisonsoft/tsf-go/pkg/naming"
 	"github.com/hisonsoft/tsf-go/pkg/route"
@@ -45,7 +45,7 @@ func DefaultLane() *Lane {
 	defer mu.Unlock()
 	if defaultLane == nil {
 		defaultLane = New(
-			consul.DefaultConsul(),
+			source.Default(),
 		)
 	}
 	return defaultLane
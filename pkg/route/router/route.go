@@ -10,7 +10,7 @@ import (
 	"github.com/go-kratos/kratos/v2/errors"
 	"github.com/hisonsoft/tsf-go/log"
 	"github.com/hisonsoft/tsf-go/pkg/config"
-	"github.com/hisonsoft/tsf-go/pkg/config/consul"
+	"github.com/hisonsoft/tsf-go/pkg/config/source"
 	"github.com/hisonsoft/tsf-go/pkg/naming"
 	"github.com/hisonsoft/tsf-go/pkg/route"
 	"github.com/hisonsoft/tsf-go/pkg/sys/env"
@@ -44,7 +44,7 @@ func DefaultRouter() *Router {
 			&Config{
 				NamespaceID: env.NamespaceID(),
 			},
-			consul.DefaultConsul(),
+			source.Default(),
 		)
 	}
 	return defaultRoute
@@ -3,10 +3,14 @@ package env
 import (
 	"flag"
 	"fmt"
+	"io/ioutil"
 	"net"
 	"os"
+	"path/filepath"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 
 	"go.uber.org/zap"
 )
@@ -21,6 +25,23 @@ var (
 	consulPort        int
 	instanceId        string
 	token             string
+	envToken          string
+	tokenFile         string
+	consulTLS         bool
+	consulCAFile      string
+	consulCertFile    string
+	consulKeyFile     string
+	consulSkipVerify  bool
+	configDir         string
+	configFile        string
+	configKeyFile     string
+	configTimeout     time.Duration
+	configSnapshotDir string
+	identityKeyFile   string
+	identityEnforce   bool
+	authAuditPath     string
+	authAuditAllow    bool
+	adminToken        string
 	localIP           string
 	namespaceID       string
 	applicationID     string
@@ -32,6 +53,7 @@ var (
 	port              int
 	disableGrpcHttp   bool
 	gopsPort          int
+	prometheusPort    int
 	pprofPort         int
 	disableGops       bool
 	disablePprof      bool
@@ -120,6 +142,149 @@ func Token() string {
 	return token
 }
 
+var fileToken struct {
+	sync.Mutex
+	modTime time.Time
+	token   string
+}
+
+// ConsulToken returns the consul acl token, it is re-read on every call so
+// that a rotated token takes effect without restarting:
+// tsf_token_file(reloaded when modified) > tsf_token env > -tsf_token flag
+func ConsulToken() string {
+	if tokenFile != "" {
+		fileToken.Lock()
+		defer fileToken.Unlock()
+		fi, err := os.Stat(tokenFile)
+		if err == nil && !fi.ModTime().Equal(fileToken.modTime) {
+			if b, err := ioutil.ReadFile(tokenFile); err == nil {
+				fileToken.token = strings.TrimSpace(string(b))
+				fileToken.modTime = fi.ModTime()
+			}
+		}
+		if fileToken.token != "" {
+			return fileToken.token
+		}
+	}
+	// 命令行参数显式指定时以参数为准，否则读取当前的环境变量
+	if token == envToken {
+		if t := os.Getenv("tsf_token"); t != "" {
+			return t
+		}
+	}
+	return token
+}
+
+// ConsulTLS reports whether the consul agent is accessed by https
+func ConsulTLS() bool {
+	return consulTLS || consulCAFile != "" || consulCertFile != ""
+}
+
+func ConsulCAFile() string {
+	return consulCAFile
+}
+
+func ConsulCertFile() string {
+	return consulCertFile
+}
+
+func ConsulKeyFile() string {
+	return consulKeyFile
+}
+
+func ConsulTLSSkipVerify() bool {
+	return consulSkipVerify
+}
+
+// ConfigDir is the local directory of the config, route, lane and
+// authority rules, if set it replaces consul as the config source
+func ConfigDir() string {
+	return configDir
+}
+
+// ConfigFile is a local config file overriding the application config
+func ConfigFile() string {
+	return configFile
+}
+
+// ConfigKeyFile is the file of the AES key decrypting the ENC(...) config values
+func ConfigKeyFile() string {
+	return configKeyFile
+}
+
+// ConfigKey is the AES key decrypting the ENC(...) config values, it is only
+// read from env so that it never shows up in the process arguments
+func ConfigKey() string {
+	return os.Getenv("tsf_config_key")
+}
+
+// ConfigTimeout is how long the first config load waits for consul before
+// falling back to the local snapshot, default 10s
+func ConfigTimeout() time.Duration {
+	if configTimeout <= 0 {
+		return 10 * time.Second
+	}
+	return configTimeout
+}
+
+// ConfigSnapshotDir is where the last loaded configs are saved for booting
+// without consul, it is a private dir of the application rather than the
+// shared temp dir since the configs may hold secrets. Empty if snapshots are
+// disabled.
+func ConfigSnapshotDir() string {
+	if configSnapshotDir == "" {
+		if Token() != "" {
+			return "/data/tsf_config_snapshot"
+		}
+		// not run on tsf platform, use the cache dir of the user instead of the working dir
+		dir, err := os.UserCacheDir()
+		if err != nil {
+			return ""
+		}
+		app := ApplicationID()
+		if app == "" {
+			app = "default"
+		}
+		return filepath.Join(dir, "tsf-go", app)
+	}
+	return configSnapshotDir
+}
+
+// IdentityKeyFile is the HMAC key file signing the caller identity
+func IdentityKeyFile() string {
+	return identityKeyFile
+}
+
+// IdentityEnforce reports whether requests with an unsigned or invalid
+// caller identity are rejected
+func IdentityEnforce() bool {
+	return identityEnforce
+}
+
+// AuthAuditPath is the rotating log recording the auth decisions
+func AuthAuditPath() string {
+	if authAuditPath == "" {
+		if Token() == "" {
+			// not run on tsf platform
+			return "./audit/auth_audit.log"
+		}
+		return "/data/logs/auth_audit.log"
+	}
+	return authAuditPath
+}
+
+// AuthAuditAllow reports whether the allowed requests are also audited,
+// otherwise only the denials are
+func AuthAuditAllow() bool {
+	return authAuditAllow
+}
+
+// AdminToken is the token required by the admin API marking the instance
+// down or up, the admin API is disabled if it is empty
+func AdminToken() string {
+	return adminToken
+}
+
 func LocalIP() string {
 	if localIP == "" {
 		return getIntranetIP()
@@ -214,6 +379,12 @@ func PprofPort() int {
 	return pprofPort
 }
 
+// PrometheusPort serves /metrics if set, otherwise it is only served on the
+// pprof port
+func PrometheusPort() int {
+	return prometheusPort
+}
+
 func GopsPort() int {
 	if gopsPort == 0 {
 		return 46066
@@ -230,7 +401,24 @@ func init() {
 	flag.StringVar(&consulAddressList, "tsf_consul_list", os.Getenv("tsf_consul_list"), "-tsf_consul_list 127.0.0.1:8080")
 	flag.IntVar(&consulPort, "tsf_consul_port", parseInt(os.Getenv("tsf_consul_port")), "-tsf_consul_port 85000")
 	flag.StringVar(&instanceId, "tsf_instance_id", os.Getenv("tsf_instance_id"), "-tsf_instance_id xxx")
-	flag.StringVar(&token, "tsf_token", os.Getenv("tsf_token"), "-tsf_token xxx")
+	envToken = os.Getenv("tsf_token")
+	flag.StringVar(&token, "tsf_token", envToken, "-tsf_token xxx")
+	flag.StringVar(&tokenFile, "tsf_token_file", os.Getenv("tsf_token_file"), "-tsf_token_file /etc/tsf/token")
+	flag.BoolVar(&consulTLS, "tsf_consul_tls", parseBool(os.Getenv("tsf_consul_tls")), "-tsf_consul_tls false")
+	flag.StringVar(&consulCAFile, "tsf_consul_ca_file", os.Getenv("tsf_consul_ca_file"), "-tsf_consul_ca_file /etc/tsf/ca.pem")
+	flag.StringVar(&consulCertFile, "tsf_consul_cert_file", os.Getenv("tsf_consul_cert_file"), "-tsf_consul_cert_file /etc/tsf/client.pem")
+	flag.StringVar(&consulKeyFile, "tsf_consul_key_file", os.Getenv("tsf_consul_key_file"), "-tsf_consul_key_file /etc/tsf/client-key.pem")
+	flag.BoolVar(&consulSkipVerify, "tsf_consul_tls_skip_verify", parseBool(os.Getenv("tsf_consul_tls_skip_verify")), "-tsf_consul_tls_skip_verify false")
+	flag.StringVar(&configDir, "tsf_config_dir", os.Getenv("tsf_config_dir"), "-tsf_config_dir ./tsf")
+	flag.StringVar(&configFile, "tsf_config_file", os.Getenv("tsf_config_file"), "-tsf_config_file ./application.yaml")
+	flag.StringVar(&configKeyFile, "tsf_config_key_file", os.Getenv("tsf_config_key_file"), "-tsf_config_key_file /etc/tsf/config.key")
+	flag.DurationVar(&configTimeout, "tsf_config_timeout", parseDuration(os.Getenv("tsf_config_timeout")), "-tsf_config_timeout 10s")
+	flag.StringVar(&configSnapshotDir, "tsf_config_snapshot_dir", os.Getenv("tsf_config_snapshot_dir"), "-tsf_config_snapshot_dir /data/tsf_config_snapshot")
+	flag.StringVar(&identityKeyFile, "tsf_identity_key_file", os.Getenv("tsf_identity_key_file"), "-tsf_identity_key_file /etc/tsf/identity.key")
+	flag.BoolVar(&identityEnforce, "tsf_identity_enforce", parseBool(os.Getenv("tsf_identity_enforce")), "-tsf_identity_enforce false")
+	flag.StringVar(&authAuditPath, "tsf_auth_audit_path", os.Getenv("tsf_auth_audit_path"), "-tsf_auth_audit_path ./audit/auth_audit.log")
+	flag.BoolVar(&authAuditAllow, "tsf_auth_audit_allow", parseBool(os.Getenv("tsf_auth_audit_allow")), "-tsf_auth_audit_allow false")
+	flag.StringVar(&adminToken, "tsf_admin_token", os.Getenv("tsf_admin_token"), "-tsf_admin_token xxx")
 	flag.StringVar(&localIP, "tsf_local_ip", os.Getenv("tsf_local_ip"), "-tsf_local_ip 127.0.0.1")
 	flag.StringVar(&namespaceID, "tsf_namespace_id", os.Getenv("tsf_namespace_id"), "-tsf_namespace_id xxx")
 	flag.StringVar(&applicationID, "tsf_application_id", os.Getenv("tsf_application_id"), "-tsf_application_id xxx")
@@ -245,6 +433,7 @@ func init() {
 	flag.BoolVar(&disablePprof, "tsf_disable_pprof", parseBool(os.Getenv("tsf_disable_pprof")), "-tsf_disable_pprof false")
 	flag.IntVar(&pprofPort, "tsf_pprof_port", parseInt(os.Getenv("tsf_pprof_port")), "-tsf_pprof_port 47077")
 	flag.IntVar(&gopsPort, "tsf_gops_port", parseInt(os.Getenv("tsf_gops_port")), "-tsf_gops_port 46066")
+	flag.IntVar(&prometheusPort, "tsf_prometheus_port", parseInt(os.Getenv("tsf_prometheus_port")), "-tsf_prometheus_port 9090")
 
 	flag.StringVar(&sshUser, "ssh_user", os.Getenv("ssh_user"), "-ssh_user root")
 	flag.StringVar(&sshHost, "ssh_host", os.Getenv("ssh_host"), "-ssh_host 127.0.0.1")
@@ -270,6 +459,11 @@ func parseBool(b string) bool {
 	return ok
 }
 
+func parseDuration(d string) time.Duration {
+	res, _ := time.ParseDuration(d)
+	return res
+}
+
 func getIntranetIP() string {
 	addrs, err := net.InterfaceAddrs()
 	if err != nil {
@@ -16,6 +16,7 @@ import (
 func StartAgent() {
 	go startGops()
 	go startPprof()
+	StartPrometheus()
 }
 
 func startPprof() {
@@ -26,6 +27,7 @@ func startPprof() {
 		mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
 		mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
 		mux.HandleFunc("/debug/pprof/trace", pprof.Symbol)
+		mux.Handle("/metrics", PrometheusHandler())
 
 		addr := fmt.Sprintf(":%d", env.PprofPort())
 
@@ -38,7 +40,7 @@ func startPprof() {
 			Handler: mux,
 			Addr:    addr,
 		}
-		log.DefaultLog.Debugf("pprof http server start serve. To disable it,set tsf_disable_pprof=true", zap.String("addr", addr))
+		log.DefaultLog.Debugw("msg", "pprof http server start serve. To disable it,set tsf_disable_pprof=true", "addr", addr)
 		if err = server.Serve(lis); err != nil {
 			log.DefaultLog.Errorf("pprof server serve  err: %v", err)
 			return
@@ -0,0 +1,295 @@
+package metrics
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/log"
+	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+	"github.com/hisonsoft/tsf-go/pkg/util"
+	"github.com/hisonsoft/tsf-go/pkg/watch"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+const namespace = "tsf"
+
+var (
+	requestLabels = []string{"kind", "local_service", "local_interface", "remote_service", "remote_interface", "status", "lane"}
+	flightLabels  = []string{"kind", "local_service", "local_interface", "remote_service", "remote_interface", "lane"}
+
+	registry = prometheus.NewRegistry()
+
+	requests = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "requests_total",
+		Help:      "Total number of the requests.",
+	}, requestLabels)
+	latency = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "request_duration_seconds",
+		Help:      "Latency of the requests.",
+		// 与监控日志的统计区间一致
+		Buckets: []float64{.05, .1, .2, .3, .4, .5, .8, 1.2, 1.6, 2},
+	}, requestLabels)
+	inflight = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: namespace,
+		Name:      "requests_in_flight",
+		Help:      "Number of the requests being processed.",
+	}, flightLabels)
+	rateLimited = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "ratelimit_rejected_total",
+		Help:      "Total number of the requests rejected by rate limiters(status 429).",
+	}, []string{"kind", "local_service", "local_interface", "remote_service", "remote_interface"})
+	breakerRejected = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "breaker_rejected_total",
+		Help:      "Total number of the requests rejected by open circuit breakers.",
+	}, []string{"operation"})
+
+	stats = &statsCollector{breakers: make(map[string]breakerGroup), discovery: make(map[string]DiscoveryStats)}
+)
+
+func init() {
+	registry.MustRegister(
+		prometheus.NewGoCollector(),
+		prometheus.NewProcessCollector(prometheus.ProcessCollectorOpts{}),
+		requests, latency, inflight, rateLimited, breakerRejected, stats,
+	)
+}
+
+// Labels identifies the requests of an interface
+type Labels struct {
+	// Kind is SERVER or CLIENT
+	Kind            string
+	LocalService    string
+	LocalInterface  string
+	RemoteService   string
+	RemoteInterface string
+	Lane            string
+}
+
+// StartRequest counts a request in flight, done records its status code
+// and latency
+func StartRequest(l Labels) (done func(status int)) {
+	begin := time.Now()
+	g := inflight.WithLabelValues(l.Kind, l.LocalService, l.LocalInterface, l.RemoteService, l.RemoteInterface, l.Lane)
+	g.Inc()
+	return func(status int) {
+		g.Dec()
+		code := strconv.Itoa(status)
+		requests.WithLabelValues(l.Kind, l.LocalService, l.LocalInterface, l.RemoteService, l.RemoteInterface, code, l.Lane).Inc()
+		latency.WithLabelValues(l.Kind, l.LocalService, l.LocalInterface, l.RemoteService, l.RemoteInterface, code, l.Lane).Observe(time.Since(begin).Seconds())
+		if status == http.StatusTooManyRequests {
+			rateLimited.WithLabelValues(l.Kind, l.LocalService, l.LocalInterface, l.RemoteService, l.RemoteInterface).Inc()
+		}
+	}
+}
+
+// BreakerRejected counts a request rejected by the breaker of operation
+func BreakerRejected(operation string) {
+	breakerRejected.WithLabelValues(operation).Inc()
+}
+
+// BreakerStates returns the state of the breakers by key, see breaker.Group
+type BreakerStates interface {
+	States() map[string]int32
+}
+
+// RegisterBreakers exports the states of the breakers as tsf_breaker_open,
+// open is the value of breaker.StateOpen. A group registered with the same
+// name is replaced.
+func RegisterBreakers(name string, b BreakerStates, open int32) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.breakers[name] = breakerGroup{states: b, open: open}
+}
+
+// DiscoveryStats is implemented by the consul clients
+type DiscoveryStats interface {
+	EndpointStats() []util.EndpointStat
+	Failovers() int64
+}
+
+// RegisterDiscovery exports the agent states of a consul client as
+// tsf_discovery_*, a client registered with the same name is replaced.
+// The instance count of each service is also exported if d implements
+// ServiceInstances() map[string]int.
+func RegisterDiscovery(name string, d DiscoveryStats) {
+	stats.mu.Lock()
+	defer stats.mu.Unlock()
+	stats.discovery[name] = d
+}
+
+// Register registers a custom collector, e.g. of a rate limiter
+func Register(c prometheus.Collector) error {
+	return registry.Register(c)
+}
+
+// PrometheusHandler serves the metrics in the prometheus text format
+func PrometheusHandler() http.Handler {
+	return promhttp.HandlerFor(registry, promhttp.HandlerOpts{})
+}
+
+var prometheusOnce sync.Once
+
+// StartPrometheus serves /metrics on tsf_prometheus_port if it is set,
+// otherwise /metrics is only served on the pprof port
+func StartPrometheus() {
+	prometheusOnce.Do(func() {
+		port := env.PrometheusPort()
+		if port <= 0 {
+			return
+		}
+		go func() {
+			mux := http.NewServeMux()
+			mux.Handle("/metrics", PrometheusHandler())
+			addr := fmt.Sprintf(":%d", port)
+			lis, err := net.Listen("tcp", addr)
+			if err != nil {
+				log.DefaultLog.Errorf("prometheus server listen %s err: %v", addr, err)
+				return
+			}
+			log.DefaultLog.Debugw("msg", "prometheus http server start serve.", "addr", addr)
+			if err = http.Serve(lis, mux); err != nil {
+				log.DefaultLog.Errorf("prometheus server serve err: %v", err)
+			}
+		}()
+	})
+}
+
+type breakerGroup struct {
+	states BreakerStates
+	open   int32
+}
+
+// statsCollector 采集时读取熔断器及服务发现的当前状态
+type statsCollector struct {
+	mu        sync.Mutex
+	breakers  map[string]breakerGroup
+	discovery map[string]DiscoveryStats
+}
+
+var (
+	breakerOpenDesc = prometheus.NewDesc(namespace+"_breaker_open",
+		"1 if the circuit breaker of the operation is open.", []string{"operation"}, nil)
+	instancesDesc = prometheus.NewDesc(namespace+"_discovery_instances",
+		"Number of the healthy instances discovered.", []string{"client", "service"}, nil)
+	endpointHealthyDesc = prometheus.NewDesc(namespace+"_discovery_endpoint_healthy",
+		"1 if the consul agent is healthy.", []string{"client", "addr"}, nil)
+	endpointCurrentDesc = prometheus.NewDesc(namespace+"_discovery_endpoint_current",
+		"1 if the requests are sent to the consul agent.", []string{"client", "addr"}, nil)
+	endpointRequestsDesc = prometheus.NewDesc(namespace+"_discovery_endpoint_requests_total",
+		"Total number of the requests to the consul agent.", []string{"client", "addr"}, nil)
+	endpointErrorsDesc = prometheus.NewDesc(namespace+"_discovery_endpoint_errors_total",
+		"Total number of the failed requests to the consul agent.", []string{"client", "addr"}, nil)
+	failoversDesc = prometheus.NewDesc(namespace+"_discovery_failovers_total",
+		"Total number of the switches to another consul agent.", []string{"client"}, nil)
+	watchesDesc = prometheus.NewDesc(namespace+"_watch_queries",
+		"Number of the blocking queries of the watch engine.", []string{"type"}, nil)
+	watchSubscribersDesc = prometheus.NewDesc(namespace+"_watch_subscribers",
+		"Number of the subscribers of the blocking queries.", []string{"type"}, nil)
+	watchChangesDesc = prometheus.NewDesc(namespace+"_watch_changes_total",
+		"Total number of the changes returned by the blocking queries.", []string{"type"}, nil)
+	watchErrorsDesc = prometheus.NewDesc(namespace+"_watch_errors_total",
+		"Total number of the failed blocking queries.", []string{"type"}, nil)
+	watchInFlightDesc = prometheus.NewDesc(namespace+"_watch_queries_in_flight",
+		"Number of the blocking queries being executed.", nil, nil)
+)
+
+func (c *statsCollector) Describe(ch chan<- *prometheus.Desc) {
+	for _, d := range []*prometheus.Desc{breakerOpenDesc, instancesDesc, endpointHealthyDesc, endpointCurrentDesc,
+		endpointRequestsDesc, endpointErrorsDesc, failoversDesc, watchesDesc, watchSubscribersDesc, watchChangesDesc, watchErrorsDesc, watchInFlightDesc} {
+		ch <- d
+	}
+}
+
+func (c *statsCollector) Collect(ch chan<- prometheus.Metric) {
+	c.mu.Lock()
+	breakers := make([]breakerGroup, 0, len(c.breakers))
+	for _, g := range c.breakers {
+		breakers = append(breakers, g)
+	}
+	discovery := make(map[string]DiscoveryStats, len(c.discovery))
+	for name, d := range c.discovery {
+		discovery[name] = d
+	}
+	c.mu.Unlock()
+
+	// 多个熔断组可能包含同一个接口，任意一个打开即视为打开
+	open := make(map[string]float64)
+	for _, g := range breakers {
+		for op, state := range g.states.States() {
+			if state == g.open {
+				open[op] = 1
+			} else if _, ok := open[op]; !ok {
+				open[op] = 0
+			}
+		}
+	}
+	for op, v := range open {
+		ch <- prometheus.MustNewConstMetric(breakerOpenDesc, prometheus.GaugeValue, v, op)
+	}
+
+	for name, d := range discovery {
+		if ins, ok := d.(interface{ ServiceInstances() map[string]int }); ok {
+			for svc, n := range ins.ServiceInstances() {
+				ch <- prometheus.MustNewConstMetric(instancesDesc, prometheus.GaugeValue, float64(n), name, svc)
+			}
+		}
+		for _, e := range d.EndpointStats() {
+			ch <- prometheus.MustNewConstMetric(endpointHealthyDesc, prometheus.GaugeValue, boolValue(e.Healthy), name, e.Addr)
+			ch <- prometheus.MustNewConstMetric(endpointCurrentDesc, prometheus.GaugeValue, boolValue(e.Current), name, e.Addr)
+			ch <- prometheus.MustNewConstMetric(endpointRequestsDesc, prometheus.CounterValue, float64(e.Requests), name, e.Addr)
+			ch <- prometheus.MustNewConstMetric(endpointErrorsDesc, prometheus.CounterValue, float64(e.Errors), name, e.Addr)
+		}
+		ch <- prometheus.MustNewConstMetric(failoversDesc, prometheus.CounterValue, float64(d.Failovers()), name)
+	}
+	c.collectWatch(ch)
+}
+
+type watchSum struct {
+	queries, subscribers, changes, errors int64
+}
+
+// collectWatch watch的key数量不固定，按类型(key的前缀，如naming、config)导出汇总值
+func (c *statsCollector) collectWatch(ch chan<- prometheus.Metric) {
+	engine := watch.DefaultEngine()
+	sums := make(map[string]*watchSum)
+	for _, w := range engine.Stats() {
+		typ := w.Key
+		if i := strings.IndexByte(typ, '|'); i >= 0 {
+			typ = typ[:i]
+		}
+		sum, ok := sums[typ]
+		if !ok {
+			sum = &watchSum{}
+			sums[typ] = sum
+		}
+		sum.queries++
+		sum.subscribers += int64(w.Subscribers)
+		sum.changes += w.Changes
+		sum.errors += w.Errors
+	}
+	for typ, sum := range sums {
+		ch <- prometheus.MustNewConstMetric(watchesDesc, prometheus.GaugeValue, float64(sum.queries), typ)
+		ch <- prometheus.MustNewConstMetric(watchSubscribersDesc, prometheus.GaugeValue, float64(sum.subscribers), typ)
+		ch <- prometheus.MustNewConstMetric(watchChangesDesc, prometheus.CounterValue, float64(sum.changes), typ)
+		ch <- prometheus.MustNewConstMetric(watchErrorsDesc, prometheus.CounterValue, float64(sum.errors), typ)
+	}
+	ch <- prometheus.MustNewConstMetric(watchInFlightDesc, prometheus.GaugeValue, float64(engine.InFlight()))
+}
+
+func boolValue(b bool) float64 {
+	if b {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package metrics
+
+import (
+	"io/ioutil"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/hisonsoft/tsf-go/pkg/util"
+)
+
+type fakeBreakers map[string]int32
+
+func (b fakeBreakers) States() map[string]int32 {
+	return b
+}
+
+type fakeDiscovery struct{}
+
+func (fakeDiscovery) EndpointStats() []util.EndpointStat {
+	return []util.EndpointStat{{Addr: "127.0.0.1:8500", Current: true, Healthy: true, Requests: 10, Errors: 2}}
+}
+
+func (fakeDiscovery) Failovers() int64 {
+	return 3
+}
+
+func (fakeDiscovery) ServiceInstances() map[string]int {
+	return map[string]int{"provider": 2}
+}
+
+func TestPrometheus(t *testing.T) {
+	l := Labels{Kind: "CLIENT", LocalService: "consumer", LocalInterface: "/hello", RemoteService: "provider", RemoteInterface: "/greet", Lane: "lane-1"}
+	StartRequest(l)(200)
+	StartRequest(l)(429)
+	pending := StartRequest(l)
+	defer pending(200)
+	BreakerRejected("/greet")
+	RegisterBreakers("provider", fakeBreakers{"/greet": 0, "/other": 1}, 0)
+	RegisterBreakers("other", fakeBreakers{"/greet": 1}, 0)
+	// 同名的熔断组被替换
+	RegisterBreakers("stale", fakeBreakers{"/stale": 1}, 0)
+	RegisterBreakers("stale", fakeBreakers{"/fresh": 1}, 0)
+	RegisterDiscovery("naming", fakeDiscovery{})
+
+	rec := httptest.NewRecorder()
+	PrometheusHandler().ServeHTTP(rec, httptest.NewRequest("GET", "/metrics", nil))
+	b, _ := ioutil.ReadAll(rec.Body)
+	out := string(b)
+	labels := `kind="CLIENT",lane="lane-1",local_interface="/hello",local_service="consumer",remote_interface="/greet",remote_service="provider"`
+	for _, line := range []string{
+		`tsf_requests_total{` + labels + `,status="200"} 1`,
+		`tsf_requests_total{` + labels + `,status="429"} 1`,
+		`tsf_request_duration_seconds_count{` + labels + `,status="200"} 1`,
+		`tsf_requests_in_flight{` + labels + `} 1`,
+		`tsf_ratelimit_rejected_total{kind="CLIENT",local_interface="/hello",local_service="consumer",remote_interface="/greet",remote_service="provider"} 1`,
+		`tsf_breaker_rejected_total{operation="/greet"} 1`,
+		`tsf_breaker_open{operation="/greet"} 1`,
+		`tsf_breaker_open{operation="/other"} 0`,
+		`tsf_discovery_instances{client="naming",service="provider"} 2`,
+		`tsf_discovery_endpoint_healthy{addr="127.0.0.1:8500",client="naming"} 1`,
+		`tsf_discovery_endpoint_errors_total{addr="127.0.0.1:8500",client="naming"} 2`,
+		`tsf_discovery_failovers_total{client="naming"} 3`,
+		`tsf_watch_queries_in_flight 0`,
+	} {
+		if !strings.Contains(out, line+"\n") {
+			t.Fatalf("expect %s in\n%s", line, out)
+		}
+	}
+	if strings.Contains(out, `operation="/stale"`) || !strings.Contains(out, `tsf_breaker_open{operation="/fresh"} 0`) {
+		t.Fatalf("expect the replaced breaker group not exported in\n%s", out)
+	}
+}
@@ -0,0 +1,77 @@
+package monitor
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/log"
+)
+
+const CategoryAuth = "AUTH"
+
+type authKey struct {
+	service  string
+	ruleID   string
+	ruleType string
+}
+
+type authCount struct {
+	allow int64
+	deny  int64
+}
+
+// AuthItem is the allow/deny count of an auth rule in a period, RuleID is
+// empty if no rule matched
+type AuthItem struct {
+	Cateory   string `json:"category"`
+	Timestamp int64  `json:"timestamp"`
+	Period    int64  `json:"period"`
+	Service   string `json:"service"`
+	RuleID    string `json:"rule_id"`
+	RuleType  string `json:"rule_type"`
+	Allow     int64  `json:"allow_amount"`
+	Deny      int64  `json:"deny_amount"`
+}
+
+// RecordAuth counts an auth decision of the rule
+func RecordAuth(service string, ruleID string, ruleType string, allowed bool) {
+	monitor.saveAuth(authKey{service: service, ruleID: ruleID, ruleType: ruleType}, allowed)
+}
+
+func (m *Monitor) saveAuth(key authKey, allowed bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	c, ok := m.auth[key]
+	if !ok {
+		c = &authCount{}
+		m.auth[key] = c
+	}
+	if allowed {
+		c.allow++
+	} else {
+		c.deny++
+	}
+}
+
+func (m *Monitor) dumpAuth(old map[authKey]*authCount) {
+	ts := time.Now().Unix()
+	ts = ts - ts%60
+	for key, c := range old {
+		item := AuthItem{
+			Cateory:   CategoryAuth,
+			Timestamp: ts,
+			Period:    60,
+			Service:   key.service,
+			RuleID:    key.ruleID,
+			RuleType:  key.ruleType,
+			Allow:     c.allow,
+			Deny:      c.deny,
+		}
+		content, err := json.Marshal(item)
+		if err != nil {
+			log.DefaultLog.Errorf("Monitor Marshal failed!auth:%v", item)
+			continue
+		}
+		logger.Info(string(content))
+	}
+}
@@ -18,6 +18,7 @@ func init() {
 func New() *Monitor {
 	m := &Monitor{
 		current: make(map[string][]*Stat),
+		auth:    make(map[authKey]*authCount),
 	}
 	go m.run()
 	return m
@@ -25,6 +26,7 @@ func New() *Monitor {
 
 type Monitor struct {
 	current map[string][]*Stat
+	auth    map[authKey]*authCount
 	lock    sync.Mutex
 }
 
@@ -44,8 +46,11 @@ func (m *Monitor) run() {
 		m.lock.Lock()
 		old = m.current
 		m.current = make(map[string][]*Stat)
+		oldAuth := m.auth
+		m.auth = make(map[authKey]*authCount)
 		m.lock.Unlock()
 		go m.dump(old)
+		go m.dumpAuth(oldAuth)
 	}
 }
 
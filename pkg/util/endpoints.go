@@ -0,0 +1,152 @@
+package util
+
+import (
+	"math/rand"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/go-kratos/kratos/v2/errors"
+)
+
+// EndpointStat is the connection state of a single endpoint
+type EndpointStat struct {
+	Addr string
+	// Current is true for the endpoint requests are sticking to
+	Current bool
+	Healthy bool
+	// Failures is the number of consecutive failures
+	Failures  int
+	Requests  int64
+	Errors    int64
+	LastError string
+	// DownUntil is when the endpoint will be retried again
+	DownUntil time.Time
+}
+
+// Endpoints picks a healthy address from a list of equivalent endpoints.
+// Requests stick to one endpoint until it fails, then fail over to the next
+// one while the failed endpoint backs off.
+type Endpoints struct {
+	mu        sync.Mutex
+	endpoints []*EndpointStat
+	current   int
+	failovers int64
+	bc        Backoff
+}
+
+// NewEndpoints creates endpoints starting from a random one, so that clients
+// are spread over the list
+func NewEndpoints(addrs []string, bc Backoff) *Endpoints {
+	e := &Endpoints{bc: bc}
+	for _, addr := range addrs {
+		e.endpoints = append(e.endpoints, &EndpointStat{Addr: addr, Healthy: true})
+	}
+	if len(addrs) > 1 {
+		e.current = rand.Intn(len(addrs))
+	}
+	return e
+}
+
+// Pick returns the endpoint the next request should be sent to
+func (e *Endpoints) Pick() string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	if len(e.endpoints) == 0 {
+		return ""
+	}
+	now := time.Now()
+	available := func(ep *EndpointStat) bool {
+		return ep.Healthy || now.After(ep.DownUntil)
+	}
+	if available(e.endpoints[e.current]) {
+		return e.endpoints[e.current].Addr
+	}
+	// 当前节点不可用，依次寻找下一个可用节点；如果全部不可用，则选择最早结束退避的节点
+	best := e.current
+	for i := 1; i < len(e.endpoints); i++ {
+		idx := (e.current + i) % len(e.endpoints)
+		if available(e.endpoints[idx]) {
+			best = idx
+			break
+		}
+		if e.endpoints[idx].DownUntil.Before(e.endpoints[best].DownUntil) {
+			best = idx
+		}
+	}
+	if best != e.current {
+		e.current = best
+		e.failovers++
+	}
+	return e.endpoints[e.current].Addr
+}
+
+// Done reports the result of a request sent to addr
+func (e *Endpoints) Done(addr string, err error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	for _, ep := range e.endpoints {
+		if ep.Addr != addr {
+			continue
+		}
+		ep.Requests++
+		if !IsEndpointFailure(err) {
+			ep.Healthy = true
+			ep.Failures = 0
+			return
+		}
+		ep.Errors++
+		ep.LastError = err.Error()
+		ep.DownUntil = time.Now().Add(e.bc.Backoff(ep.Failures))
+		ep.Failures++
+		ep.Healthy = false
+		return
+	}
+}
+
+// Addrs returns the addresses of all endpoints
+func (e *Endpoints) Addrs() []string {
+	addrs := make([]string, 0, len(e.endpoints))
+	for _, ep := range e.endpoints {
+		addrs = append(addrs, ep.Addr)
+	}
+	return addrs
+}
+
+// Failovers returns how many times requests switched to another endpoint
+func (e *Endpoints) Failovers() int64 {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	return e.failovers
+}
+
+// Stats returns a snapshot of the state of all endpoints
+func (e *Endpoints) Stats() []EndpointStat {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	stats := make([]EndpointStat, 0, len(e.endpoints))
+	for i, ep := range e.endpoints {
+		stat := *ep
+		stat.Current = i == e.current
+		stats = append(stats, stat)
+	}
+	return stats
+}
+
+// IsEndpointFailure reports whether err means the endpoint itself is
+// unavailable(network error or gateway errors), rather than a normal
+// error response like 404.
+func IsEndpointFailure(err error) bool {
+	if err == nil {
+		return false
+	}
+	se := new(errors.Error)
+	if !errors.As(err, &se) {
+		return true
+	}
+	switch se.Code {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
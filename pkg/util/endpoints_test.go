@@ -0,0 +1,54 @@
+package util
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	kerrors "github.com/go-kratos/kratos/v2/errors"
+)
+
+func TestEndpointsFailover(t *testing.T) {
+	bc := &BackoffConfig{BaseDelay: time.Millisecond * 100, MaxDelay: time.Second, Factor: 1.5}
+	e := NewEndpoints([]string{"a", "b", "c"}, bc)
+	first := e.Pick()
+	for i := 0; i < 5; i++ {
+		if addr := e.Pick(); addr != first {
+			t.Fatalf("expect sticky endpoint %s, got %s", first, addr)
+		}
+		e.Done(first, nil)
+	}
+	// 404等正常的错误响应不影响节点健康
+	e.Done(first, kerrors.NotFound(kerrors.UnknownReason, ""))
+	if addr := e.Pick(); addr != first {
+		t.Fatalf("expect sticky endpoint %s after 404, got %s", first, addr)
+	}
+
+	e.Done(first, errors.New("connection refused"))
+	second := e.Pick()
+	if second == first {
+		t.Fatalf("expect failover from %s", first)
+	}
+	if e.Failovers() != 1 {
+		t.Fatalf("expect 1 failover, got %d", e.Failovers())
+	}
+	// 恢复的节点不会抢回流量
+	time.Sleep(time.Millisecond * 150)
+	if addr := e.Pick(); addr != second {
+		t.Fatalf("expect sticky endpoint %s, got %s", second, addr)
+	}
+
+	// 全部节点不可用时，选择最早结束退避的节点
+	e = NewEndpoints([]string{"a", "b"}, bc)
+	e.Done("a", kerrors.ServiceUnavailable(kerrors.UnknownReason, ""))
+	time.Sleep(time.Millisecond * 10)
+	e.Done("b", kerrors.ServiceUnavailable(kerrors.UnknownReason, ""))
+	if addr := e.Pick(); addr != "a" {
+		t.Fatalf("expect earliest recovered endpoint a, got %s", addr)
+	}
+	for _, stat := range e.Stats() {
+		if stat.Healthy || stat.Errors != 1 {
+			t.Fatalf("expect unhealthy endpoint, got %+v", stat)
+		}
+	}
+}
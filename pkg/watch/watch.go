@@ -0,0 +1,292 @@
+// Package watch multiplexes consul blocking queries: identical watches are
+// coalesced into one long poll, the number of in-flight queries is bounded
+// and requests are spread with jitter.
+package watch
+
+import (
+	"context"
+	"math/rand"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/pkg/util"
+)
+
+const minWait = time.Second
+
+var (
+	mu            sync.Mutex
+	defaultEngine *Engine
+)
+
+// Query is a blocking query: it returns once the data changed after index
+// or wait elapsed, together with the new index
+type Query func(ctx context.Context, index int64, wait time.Duration) (result interface{}, newIndex int64, err error)
+
+// Handler receives the result of a watch whenever the index changed, calls of
+// a handler are serialized
+type Handler func(result interface{}, index int64)
+
+type Config struct {
+	// MaxConcurrent bounds the number of in-flight blocking queries, default 64
+	MaxConcurrent int
+	// Wait is the max blocking time of a query, default 55s
+	Wait time.Duration
+	// Jitter is the max random delay before re-querying after a change, so
+	// that watches woken up together do not hit the agent at once, default 50ms
+	Jitter  time.Duration
+	Backoff util.Backoff
+}
+
+// Stat is the state of a single watch
+type Stat struct {
+	Key         string
+	Subscribers int
+	Index       int64
+	Requests    int64
+	Changes     int64
+	Errors      int64
+	// QueueDelay is how long the last query waited for a free slot
+	QueueDelay time.Duration
+	// Latency is the time from the last change being returned to all
+	// subscribers being notified, including the queue delay of the query
+	Latency    time.Duration
+	LastChange time.Time
+}
+
+// Engine runs the watches
+type Engine struct {
+	conf  Config
+	slots chan struct{}
+
+	mu      sync.Mutex
+	watches map[string]*watch
+}
+
+type watch struct {
+	key    string
+	query  Query
+	engine *Engine
+	cancel context.CancelFunc
+
+	// guarded by engine.mu
+	subs    map[*subscriber]struct{}
+	result  interface{}
+	index   int64
+	version int64
+	stat    Stat
+}
+
+type subscriber struct {
+	handler Handler
+
+	mu      sync.Mutex
+	version int64
+}
+
+// DefaultEngine returns the engine shared by the consul clients
+func DefaultEngine() *Engine {
+	mu.Lock()
+	defer mu.Unlock()
+	if defaultEngine == nil {
+		defaultEngine = New(Config{})
+	}
+	return defaultEngine
+}
+
+func New(conf Config) *Engine {
+	if conf.MaxConcurrent <= 0 {
+		conf.MaxConcurrent = 64
+	}
+	if conf.Wait <= 0 {
+		conf.Wait = 55 * time.Second
+	}
+	if conf.Jitter <= 0 {
+		conf.Jitter = 50 * time.Millisecond
+	}
+	if conf.Backoff == nil {
+		conf.Backoff = &util.BackoffConfig{
+			MaxDelay:  25 * time.Second,
+			BaseDelay: 500 * time.Millisecond,
+			Factor:    1.5,
+			Jitter:    0.2,
+		}
+	}
+	return &Engine{
+		conf:    conf,
+		slots:   make(chan struct{}, conf.MaxConcurrent),
+		watches: make(map[string]*watch),
+	}
+}
+
+// Watch subscribes key, the query is only started by the first subscriber
+// and shared with the later ones which receive the current result at once.
+// The returned cancel stops the subscription, the query stops with the last one.
+func (e *Engine) Watch(key string, q Query, h Handler) (cancel func()) {
+	sub := &subscriber{handler: h}
+	e.mu.Lock()
+	w, ok := e.watches[key]
+	if !ok {
+		w = &watch{key: key, query: q, engine: e, subs: make(map[*subscriber]struct{})}
+		w.stat.Key = key
+		var ctx context.Context
+		ctx, w.cancel = context.WithCancel(context.Background())
+		e.watches[key] = w
+		go w.run(ctx)
+	}
+	w.subs[sub] = struct{}{}
+	result, index, version := w.result, w.index, w.version
+	e.mu.Unlock()
+	if version > 0 {
+		go sub.notify(result, index, version)
+	}
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			e.mu.Lock()
+			defer e.mu.Unlock()
+			delete(w.subs, sub)
+			if len(w.subs) == 0 && e.watches[key] == w {
+				delete(e.watches, key)
+				w.cancel()
+			}
+		})
+	}
+}
+
+// Stats returns the state of all watches ordered by key
+func (e *Engine) Stats() []Stat {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	stats := make([]Stat, 0, len(e.watches))
+	for _, w := range e.watches {
+		stat := w.stat
+		stat.Subscribers = len(w.subs)
+		stat.Index = w.index
+		stats = append(stats, stat)
+	}
+	sort.Slice(stats, func(i, j int) bool { return stats[i].Key < stats[j].Key })
+	return stats
+}
+
+// InFlight returns the number of blocking queries being executed
+func (e *Engine) InFlight() int {
+	return len(e.slots)
+}
+
+// wait returns the blocking time of the next query
+func (e *Engine) wait() time.Duration {
+	e.mu.Lock()
+	n := len(e.watches)
+	e.mu.Unlock()
+	wait := e.conf.Wait
+	if n > e.conf.MaxConcurrent {
+		// 同时阻塞的请求数有上限，按比例缩短阻塞时间，使所有watch轮流执行
+		wait = wait * time.Duration(e.conf.MaxConcurrent) / time.Duration(n)
+		if wait < minWait {
+			wait = minWait
+		}
+	}
+	return wait
+}
+
+func (w *watch) run(ctx context.Context) {
+	e := w.engine
+	var (
+		index   int64
+		ready   bool
+		retries int
+	)
+	for {
+		start := time.Now()
+		select {
+		case e.slots <- struct{}{}:
+		case <-ctx.Done():
+			return
+		}
+		queueDelay := time.Since(start)
+		result, newIndex, err := w.query(ctx, index, e.wait())
+		<-e.slots
+		if ctx.Err() != nil {
+			return
+		}
+		returned := time.Now()
+
+		e.mu.Lock()
+		w.stat.Requests++
+		w.stat.QueueDelay = queueDelay
+		if err != nil {
+			w.stat.Errors++
+		}
+		e.mu.Unlock()
+		if err != nil {
+			if !sleep(ctx, e.conf.Backoff.Backoff(retries)) {
+				return
+			}
+			retries++
+			continue
+		}
+		retries = 0
+		if ready && newIndex == index {
+			// 阻塞超时，数据没有变化
+			continue
+		}
+		ready = true
+		// consul的index回退时(如agent重启)需要从0开始重新查询
+		if newIndex < index {
+			newIndex = 0
+		}
+		index = newIndex
+		w.deliver(result, index)
+		e.mu.Lock()
+		w.stat.Changes++
+		w.stat.Latency = queueDelay + time.Since(returned)
+		w.stat.LastChange = returned
+		e.mu.Unlock()
+		if !sleep(ctx, time.Duration(rand.Int63n(int64(e.conf.Jitter)))) {
+			return
+		}
+	}
+}
+
+func (w *watch) deliver(result interface{}, index int64) {
+	e := w.engine
+	e.mu.Lock()
+	w.version++
+	w.result, w.index = result, index
+	version := w.version
+	subs := make([]*subscriber, 0, len(w.subs))
+	for sub := range w.subs {
+		subs = append(subs, sub)
+	}
+	e.mu.Unlock()
+	for _, sub := range subs {
+		sub.notify(result, index, version)
+	}
+}
+
+func (s *subscriber) notify(result interface{}, index int64, version int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if version <= s.version {
+		return
+	}
+	s.version = version
+	s.handler(result, index)
+}
+
+func sleep(ctx context.Context, d time.Duration) bool {
+	if d <= 0 {
+		return ctx.Err() == nil
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,125 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeKV simulates a consul blocking query on a single value
+type fakeKV struct {
+	mu      sync.Mutex
+	index   int64
+	value   string
+	changed chan struct{}
+
+	requests int64
+	inFlight int64
+	maxIn    int64
+}
+
+func newFakeKV() *fakeKV {
+	return &fakeKV{index: 1, changed: make(chan struct{})}
+}
+
+func (kv *fakeKV) set(v string) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.index++
+	kv.value = v
+	close(kv.changed)
+	kv.changed = make(chan struct{})
+}
+
+func (kv *fakeKV) query(ctx context.Context, index int64, wait time.Duration) (interface{}, int64, error) {
+	atomic.AddInt64(&kv.requests, 1)
+	n := atomic.AddInt64(&kv.inFlight, 1)
+	defer atomic.AddInt64(&kv.inFlight, -1)
+	for {
+		max := atomic.LoadInt64(&kv.maxIn)
+		if n <= max || atomic.CompareAndSwapInt64(&kv.maxIn, max, n) {
+			break
+		}
+	}
+	kv.mu.Lock()
+	changed := kv.changed
+	if kv.index != index {
+		defer kv.mu.Unlock()
+		return kv.value, kv.index, nil
+	}
+	kv.mu.Unlock()
+	select {
+	case <-changed:
+	case <-time.After(wait):
+	case <-ctx.Done():
+	}
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	return kv.value, kv.index, nil
+}
+
+func TestCoalesce(t *testing.T) {
+	e := New(Config{})
+	kv := newFakeKV()
+	kv.set("v1")
+
+	res1, res2 := make(chan string, 10), make(chan string, 10)
+	cancel1 := e.Watch("key", kv.query, func(result interface{}, index int64) { res1 <- result.(string) })
+	expect(t, res1, "v1")
+	// 相同key的订阅共享同一个查询，并立即收到当前结果
+	cancel2 := e.Watch("key", kv.query, func(result interface{}, index int64) { res2 <- result.(string) })
+	expect(t, res2, "v1")
+
+	kv.set("v2")
+	expect(t, res1, "v2")
+	expect(t, res2, "v2")
+	if n := atomic.LoadInt64(&kv.requests); n > 3 {
+		t.Fatalf("expect subscriptions coalesced, got %d requests", n)
+	}
+	stats := e.Stats()
+	if len(stats) != 1 || stats[0].Subscribers != 2 || stats[0].Changes != 2 {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	cancel1()
+	cancel2()
+	if stats = e.Stats(); len(stats) != 0 {
+		t.Fatalf("expect watch stopped with the last subscriber, got %+v", stats)
+	}
+}
+
+func TestMaxConcurrent(t *testing.T) {
+	e := New(Config{MaxConcurrent: 2, Wait: time.Second})
+	kv := newFakeKV()
+	res := make(chan string, 100)
+	for i := 0; i < 5; i++ {
+		cancel := e.Watch(fmt.Sprintf("key-%d", i), kv.query, func(result interface{}, index int64) { res <- result.(string) })
+		defer cancel()
+	}
+	for i := 0; i < 5; i++ {
+		expect(t, res, "")
+	}
+	// 所有watch轮流执行，变更最终都能送达
+	kv.set("v1")
+	for i := 0; i < 5; i++ {
+		expect(t, res, "v1")
+	}
+	if max := atomic.LoadInt64(&kv.maxIn); max > 2 {
+		t.Fatalf("expect at most 2 in-flight queries, got %d", max)
+	}
+}
+
+func expect(t *testing.T, ch chan string, v string) {
+	t.Helper()
+	select {
+	case got := <-ch:
+		if got != v {
+			t.Fatalf("expect %q, got %q", v, got)
+		}
+	case <-time.After(time.Second * 5):
+		t.Fatalf("wait %q timeout", v)
+	}
+}
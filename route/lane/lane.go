@@ -10,7 +10,7 @@ import (
 	"github.com/hisonsoft/tsf-go/log"
 	"github.com/hisonsoft/tsf-go/naming"
 	"github.com/hisonsoft/tsf-go/pkg/config"
-	"github.com/hisonsoft/tsf-go/pkg/config/consul"
+	"github.com/hisonsoft/tsf-go/pkg/config/source"
 	"github.com/hisonsoft/tsf-go/pkg/meta"
 	"github.com/hisonsoft/tsf-go/pkg/sys/env"
 	"github.com/hisonsoft/tsf-go/route"
@@ -46,7 +46,7 @@ func DefaultLane() *Lane {
 	defer mu.Unlock()
 	if defaultLane == nil {
 		defaultLane = New(
-			consul.DefaultConsul(),
+			source.Default(),
 		)
 	}
 	return defaultLane
@@ -88,6 +88,14 @@ func (l *Lane) GetLaneID(ctx context.Context) string {
 	return ""
 }
 
+// Exists reports whether laneID is a configured lane
+func (l *Lane) Exists(laneID string) bool {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+	_, ok := l.allLanes[laneID]
+	return ok
+}
+
 func (l *Lane) Select(ctx context.Context, svc naming.Service, nodes []naming.Instance) []naming.Instance {
 	if len(nodes) == 0 {
 		return nodes
@@ -154,12 +162,12 @@ func (l *Lane) selectColor(ctx context.Context, nodes []naming.Instance, lane La
 
 func (l *Lane) selectNormal(ctx context.Context, svc naming.Service, nodes []naming.Instance) []naming.Instance {
 	l.mu.RLock()
-	if lanes, ok := l.namespaces[svc.Namespace]; !ok || len(lanes) == 0 {
-		l.mu.RUnlock()
-		return nodes
-	}
+	namespaces := l.namespaces
 	groups := l.groups
 	l.mu.RUnlock()
+	if !coverNamespace(namespaces, svc, nodes) {
+		return nodes
+	}
 
 	var color []naming.Instance
 	var normal []naming.Instance
@@ -179,6 +187,20 @@ func (l *Lane) selectNormal(ctx context.Context, svc naming.Service, nodes []nam
 	return normal
 }
 
+// coverNamespace 判断服务所在的命名空间是否有泳道
+func coverNamespace(namespaces map[string]map[string]struct{}, svc naming.Service, nodes []naming.Instance) bool {
+	if svc.Namespace != naming.NsGlobal {
+		return len(namespaces[svc.Namespace]) > 0
+	}
+	// 全局命名空间的服务实例分属于各自的命名空间
+	for _, node := range nodes {
+		if len(namespaces[node.Metadata[naming.NamespaceID]]) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
 func (l *Lane) refreshAllRule() {
 	for {
 		specs, err := l.ruleWatcher.Watch(l.ctx)
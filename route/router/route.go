@@ -11,7 +11,7 @@ import (
 	"github.com/hisonsoft/tsf-go/log"
 	"github.com/hisonsoft/tsf-go/naming"
 	"github.com/hisonsoft/tsf-go/pkg/config"
-	"github.com/hisonsoft/tsf-go/pkg/config/consul"
+	"github.com/hisonsoft/tsf-go/pkg/config/source"
 	"github.com/hisonsoft/tsf-go/pkg/sys/env"
 	"github.com/hisonsoft/tsf-go/route"
 )
@@ -44,7 +44,7 @@ func DefaultRouter() *Router {
 			&Config{
 				NamespaceID: env.NamespaceID(),
 			},
-			consul.DefaultConsul(),
+			source.Default(),
 		)
 	}
 	return defaultRoute
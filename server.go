@@ -2,13 +2,19 @@ package tsf
 
 import (
 	"context"
+	"crypto/tls"
 	"encoding/json"
 	"net/url"
 	"strings"
 	"sync"
+	"time"
 
+	"github.com/hisonsoft/tsf-go/health"
 	"github.com/hisonsoft/tsf-go/log"
+	"github.com/hisonsoft/tsf-go/naming"
+	"github.com/hisonsoft/tsf-go/pkg/auth/jwt"
 	tsfHttp "github.com/hisonsoft/tsf-go/pkg/http"
+	"github.com/hisonsoft/tsf-go/pkg/identity"
 	"github.com/hisonsoft/tsf-go/pkg/meta"
 	"github.com/hisonsoft/tsf-go/pkg/sys/env"
 	"github.com/hisonsoft/tsf-go/pkg/util"
@@ -18,19 +24,32 @@ import (
 	"github.com/go-kratos/kratos/v2/middleware"
 	mmeta "github.com/go-kratos/kratos/v2/middleware/metadata"
 	"github.com/go-kratos/kratos/v2/transport"
+	khttp "github.com/go-kratos/kratos/v2/transport/http"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/peer"
 )
 
 type ServerOption func(*serverOpionts)
 
 type serverOpionts struct {
+	health *health.Checker
+	jwt    *jwt.Validator
 }
 
-func startServerContext(ctx context.Context, serviceName string, method string, operation string, addr string) context.Context {
+// WithHealthChecker sets the checker answering grpc.health.v1.Health/Check,
+// default health.DefaultChecker()
+func WithHealthChecker(c *health.Checker) ServerOption {
+	return func(o *serverOpionts) {
+		o.health = c
+	}
+}
+
+func startServerContext(ctx context.Context, serviceName string, method string, operation string, addr string) (context.Context, error) {
 	// add system metadata into ctx
 	var (
 		sysPairs  []meta.SysPair
 		userPairs []meta.UserPair
+		signature string
 	)
 	md, _ := metadata.FromServerContext(ctx)
 	for key, val := range md {
@@ -44,6 +63,8 @@ func startServerContext(ctx context.Context, serviceName string, method string,
 			userPairs = append(userPairs, meta.UserPair{Key: meta.GetUserKey(key), Value: val})
 		} else if meta.IsLinkKey(key) {
 			sysPairs = append(sysPairs, meta.SysPair{Key: key, Value: val})
+		} else if key == meta.IdentitySignature {
+			signature = val
 		} else if key == "tsf-metadata" {
 			var tsfMeta tsfHttp.Metadata
 			e := json.Unmarshal([]byte(val), &tsfMeta)
@@ -98,9 +119,14 @@ func startServerContext(ctx context.Context, serviceName string, method string,
 			}
 		}
 	}
+	sysPairs, err := verifyIdentity(ctx, sysPairs, signature, serviceName, operation)
+	if err != nil {
+		return ctx, err
+	}
 	if pr, ok := peer.FromContext(ctx); ok {
 		sysPairs = append(sysPairs, meta.SysPair{Key: meta.SourceKey(meta.ConnnectionIP), Value: util.IPFromAddr(pr.Addr)})
 	}
+	sysPairs = append(sysPairs, peerCertPairs(ctx)...)
 	sysPairs = append(sysPairs, meta.SysPair{Key: meta.ServiceName, Value: serviceName})
 	sysPairs = append(sysPairs, meta.SysPair{Key: meta.Namespace, Value: env.NamespaceID()})
 	sysPairs = append(sysPairs, meta.SysPair{Key: meta.Interface, Value: operation})
@@ -112,7 +138,99 @@ func startServerContext(ctx context.Context, serviceName string, method string,
 	ctx = meta.WithSys(ctx, sysPairs...)
 	ctx = meta.WithUser(ctx, userPairs...)
 
-	return ctx
+	return ctx, nil
+}
+
+// peerCertPairs 返回经过校验的对端证书的SAN及CN，未开启mTLS时为空
+func peerCertPairs(ctx context.Context) []meta.SysPair {
+	var state *tls.ConnectionState
+	if pr, ok := peer.FromContext(ctx); ok {
+		if info, ok := pr.AuthInfo.(credentials.TLSInfo); ok {
+			state = &info.State
+		}
+	} else if tr, ok := transport.FromServerContext(ctx); ok {
+		if ht, ok := tr.(*khttp.Transport); ok && ht.Request() != nil {
+			state = ht.Request().TLS
+		}
+	}
+	// 只有校验过的证书链才可信
+	if state == nil || len(state.VerifiedChains) == 0 || len(state.VerifiedChains[0]) == 0 {
+		return nil
+	}
+	cert := state.VerifiedChains[0][0]
+	sans := append([]string{}, cert.DNSNames...)
+	for _, u := range cert.URIs {
+		sans = append(sans, u.String())
+	}
+	for _, ip := range cert.IPAddresses {
+		sans = append(sans, ip.String())
+	}
+	sans = append(sans, cert.EmailAddresses...)
+	return []meta.SysPair{
+		{Key: meta.SourceKey(meta.TLSSAN), Value: strings.Join(sans, ",")},
+		{Key: meta.SourceKey(meta.TLSCommonName), Value: cert.Subject.CommonName},
+	}
+}
+
+// identityKeys 调用方身份相关的来源信息
+var identityKeys = map[string]struct{}{
+	meta.SourceKey(meta.ServiceNamespace):   {},
+	meta.SourceKey(meta.Namespace):          {},
+	meta.SourceKey(meta.ApplicationID):      {},
+	meta.SourceKey(meta.GroupID):            {},
+	meta.SourceKey(meta.ServiceName):        {},
+	meta.SourceKey(meta.ApplicationVersion): {},
+}
+
+// verifyIdentity 校验调用方身份的签名，签名必须是调用本服务的当前接口时生成的。
+// 未签名或签名错误的身份不可信，开启强制校验时拒绝请求，否则移除调用方的身份信息，
+// 使其不参与鉴权和路由
+func verifyIdentity(ctx context.Context, pairs []meta.SysPair, signature string, serviceName string, operation string) ([]meta.SysPair, error) {
+	signer := identity.DefaultSigner()
+	if signer == nil {
+		return pairs, nil
+	}
+	// 同一个key以最后出现的值为准
+	source := make(map[string]string, len(pairs))
+	for _, pair := range pairs {
+		if v, ok := pair.Value.(string); ok {
+			source[pair.Key] = v
+		}
+	}
+	id := identity.Identity{
+		Namespace:          source[meta.SourceKey(meta.ServiceNamespace)],
+		ApplicationID:      source[meta.SourceKey(meta.ApplicationID)],
+		GroupID:            source[meta.SourceKey(meta.GroupID)],
+		ServiceName:        source[meta.SourceKey(meta.ServiceName)],
+		ApplicationVersion: source[meta.SourceKey(meta.ApplicationVersion)],
+	}
+	now := time.Now()
+	target := identity.Target{Namespace: env.NamespaceID(), Service: serviceName, Operation: signedOperation(ctx, true)}
+	err := signer.Verify(id, target, signature, now)
+	if err != nil {
+		// 调用方通过global命名空间发现的本服务
+		target.Namespace = naming.NsGlobal
+		if signer.Verify(id, target, signature, now) == nil {
+			err = nil
+		}
+	}
+	if err == nil {
+		return append(pairs,
+			meta.SysPair{Key: meta.SourceKey(meta.Namespace), Value: id.Namespace},
+			meta.SysPair{Key: meta.IdentityTrusted, Value: "true"},
+		), nil
+	}
+	// 健康检查由consul等发起，不携带签名
+	if signer.Enforce() && operation != healthCheckOperation {
+		return nil, err
+	}
+	trusted := make([]meta.SysPair, 0, len(pairs)+1)
+	for _, pair := range pairs {
+		if _, ok := identityKeys[pair.Key]; !ok {
+			trusted = append(trusted, pair)
+		}
+	}
+	return append(trusted, meta.SysPair{Key: meta.IdentityTrusted, Value: "false"}), nil
 }
 
 // ServerMiddleware is a grpc server middleware.
@@ -136,7 +254,10 @@ func serverMiddleware() middleware.Middleware {
 			})
 
 			method, operation := ServerOperation(ctx)
-			ctx = startServerContext(ctx, serviceName, method, operation, localAddr)
+			ctx, err = startServerContext(ctx, serviceName, method, operation, localAddr)
+			if err != nil {
+				return
+			}
 
 			resp, err = handler(ctx, req)
 			return
@@ -146,5 +267,13 @@ func serverMiddleware() middleware.Middleware {
 
 // ServerMiddleware is a grpc server middleware.
 func ServerMiddleware(opts ...ServerOption) middleware.Middleware {
-	return middleware.Chain(mmeta.Server(mmeta.WithPropagatedPrefix("")), serverMiddleware(), tracingServer(), serverMetricsMiddleware(), authMiddleware())
+	o := serverOpionts{health: health.DefaultChecker()}
+	for _, opt := range opts {
+		opt(&o)
+	}
+	ms := []middleware.Middleware{mmeta.Server(mmeta.WithPropagatedPrefix("")), healthMiddleware(o.health), serverMiddleware(), tracingServer(), serverMetricsMiddleware()}
+	if o.jwt != nil {
+		ms = append(ms, jwtMiddleware(o.jwt))
+	}
+	return middleware.Chain(append(ms, authMiddleware())...)
 }
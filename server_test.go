@@ -0,0 +1,117 @@
+package tsf
+
+import (
+	"context"
+	"testing"
+
+	"github.com/hisonsoft/tsf-go/naming"
+	"github.com/hisonsoft/tsf-go/pkg/identity"
+	"github.com/hisonsoft/tsf-go/pkg/meta"
+	"github.com/hisonsoft/tsf-go/pkg/sys/env"
+	"github.com/hisonsoft/tsf-go/route/lane"
+
+	"github.com/go-kratos/kratos/v2/errors"
+	"github.com/go-kratos/kratos/v2/metadata"
+	"github.com/go-kratos/kratos/v2/transport"
+)
+
+type testHeader map[string]string
+
+func (h testHeader) Get(key string) string { return h[key] }
+func (h testHeader) Set(key, value string) { h[key] = value }
+func (h testHeader) Keys() []string {
+	keys := make([]string, 0, len(h))
+	for k := range h {
+		keys = append(keys, k)
+	}
+	return keys
+}
+
+type testTransport struct {
+	kind      transport.Kind
+	endpoint  string
+	operation string
+}
+
+func (t testTransport) Kind() transport.Kind            { return t.kind }
+func (t testTransport) Endpoint() string                { return t.endpoint }
+func (t testTransport) Operation() string               { return t.operation }
+func (t testTransport) RequestHeader() transport.Header { return testHeader{} }
+func (t testTransport) ReplyHeader() transport.Header   { return testHeader{} }
+
+// callServer 模拟consumer调用provider的operation接口，返回服务端的上下文
+func callServer(t *testing.T, operation string, sign bool) (context.Context, error) {
+	return replayServer(t, operation, operation, sign)
+}
+
+// replayServer 将调用operation时携带的请求头发送到服务端的served接口
+func replayServer(t *testing.T, operation string, served string, sign bool) (context.Context, error) {
+	t.Helper()
+	signer := identity.DefaultSigner()
+	if !sign {
+		identity.SetDefaultSigner(nil)
+	}
+	tr := testTransport{kind: transport.KindGRPC, operation: operation}
+	ctx := meta.WithSys(context.Background(), meta.SysPair{Key: meta.ServiceName, Value: "consumer"})
+	ctx = transport.NewClientContext(ctx, tr)
+	ctx = startClientContext(ctx, *naming.NewService("", "provider"), &lane.Lane{}, operation)
+	identity.SetDefaultSigner(signer)
+	md, _ := metadata.FromClientContext(ctx)
+
+	ctx = transport.NewServerContext(context.Background(), testTransport{kind: transport.KindGRPC, operation: served})
+	ctx = metadata.NewServerContext(ctx, md)
+	return startServerContext(ctx, "provider", "POST", served, "127.0.0.1:9000")
+}
+
+func TestVerifyIdentity(t *testing.T) {
+	defer identity.SetDefaultSigner(nil)
+	key := func(string) ([]byte, error) { return []byte("secret"), nil }
+	operation := "/helloworld.Greeter/SayHello"
+
+	identity.SetDefaultSigner(identity.New(identity.Config{Key: key, Enforce: true}))
+	ctx, err := callServer(t, operation, true)
+	if err != nil {
+		t.Fatalf("expect signed call accepted, got %v", err)
+	}
+	if trusted := meta.Sys(ctx, meta.IdentityTrusted); trusted != "true" {
+		t.Fatalf("expect trusted identity, got %v", trusted)
+	}
+	if svc := meta.Sys(ctx, meta.SourceKey(meta.ServiceName)); svc != "consumer" {
+		t.Fatalf("expect source service consumer, got %v", svc)
+	}
+
+	// 签名不能用于其他接口
+	if _, err = replayServer(t, operation, "/helloworld.Greeter/Delete", true); !errors.IsUnauthorized(err) {
+		t.Fatalf("expect replayed call rejected, got %v", err)
+	}
+	// 强制校验时拒绝未签名的请求
+	if _, err = callServer(t, operation, false); !errors.IsUnauthorized(err) {
+		t.Fatalf("expect unsigned call rejected, got %v", err)
+	}
+	// 健康检查不校验签名，但身份不可信
+	ctx, err = callServer(t, healthCheckOperation, false)
+	if err != nil {
+		t.Fatalf("expect health check accepted, got %v", err)
+	}
+	if trusted := meta.Sys(ctx, meta.IdentityTrusted); trusted != "false" {
+		t.Fatalf("expect untrusted health check, got %v", trusted)
+	}
+
+	// 非强制校验时移除调用方的身份信息
+	identity.SetDefaultSigner(identity.New(identity.Config{Key: key}))
+	ctx, err = callServer(t, operation, false)
+	if err != nil {
+		t.Fatalf("expect unsigned call accepted, got %v", err)
+	}
+	if trusted := meta.Sys(ctx, meta.IdentityTrusted); trusted != "false" {
+		t.Fatalf("expect untrusted identity, got %v", trusted)
+	}
+	for key := range identityKeys {
+		if v := meta.Sys(ctx, key); v != nil {
+			t.Fatalf("expect %s stripped, got %v", key, v)
+		}
+	}
+	if ns := meta.Sys(ctx, meta.Namespace); ns != env.NamespaceID() {
+		t.Fatalf("expect local namespace %s kept, got %v", env.NamespaceID(), ns)
+	}
+}
@@ -2,6 +2,7 @@ package tsf
 
 import (
 	"context"
+	nhttp "net/http"
 	"net/url"
 
 	"github.com/go-kratos/kratos/v2"
@@ -42,6 +43,34 @@ func ClientOperation(ctx context.Context) (method string, operation string) {
 	return
 }
 
+// signedOperation 身份签名中的被调接口，grpc为方法全名，http为请求方法及实际路径，
+// 调用双方的路由模板可能不一致(如gin与kratos)，因此不使用模板
+func signedOperation(ctx context.Context, server bool) string {
+	var (
+		tr  transport.Transporter
+		ok  bool
+		req *nhttp.Request
+	)
+	if server {
+		if c, isGin := gin.FromGinContext(ctx); isGin {
+			req = c.Ctx.Request
+		}
+		tr, ok = transport.FromServerContext(ctx)
+	} else {
+		tr, ok = transport.FromClientContext(ctx)
+	}
+	if ht, isHTTP := tr.(*http.Transport); ok && isHTTP && req == nil {
+		req = ht.Request()
+	}
+	if req != nil {
+		return req.Method + " " + req.URL.Path
+	}
+	if ok {
+		return tr.Operation()
+	}
+	return ""
+}
+
 func LocalEndpoint(ctx context.Context) (local struct {
 	Service string
 	IP      string
@@ -0,0 +1,102 @@
+package tsf
+
+import (
+	"context"
+	"crypto/x509"
+	"io/ioutil"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hisonsoft/tsf-go/internal/testcert"
+	tsfHttp "github.com/hisonsoft/tsf-go/pkg/http"
+	"github.com/hisonsoft/tsf-go/pkg/meta"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	pb "google.golang.org/grpc/health/grpc_health_v1"
+)
+
+func TestPeerCertPairs(t *testing.T) {
+	dir, err := ioutil.TempDir("", "mtls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	ca := testcert.NewCA(t)
+	srvCert, srvKey := ca.Issue(t, "server", &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses: []net.IP{net.ParseIP("127.0.0.1")},
+	})
+	spiffe, _ := url.Parse("spiffe://cluster.local/ns/default/sa/consumer")
+	cliCert, cliKey := ca.Issue(t, "consumer", &x509.Certificate{
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		DNSNames:    []string{"consumer.default.svc"},
+		URIs:        []*url.URL{spiffe},
+	})
+	testcert.WriteFiles(t, map[string][]byte{
+		filepath.Join(dir, "ca.pem"):           ca.PEM,
+		filepath.Join(dir, "server.pem"):       srvCert,
+		filepath.Join(dir, "server-key.pem"):   srvKey,
+		filepath.Join(dir, "consumer.pem"):     cliCert,
+		filepath.Join(dir, "consumer-key.pem"): cliKey,
+	})
+
+	srvTLS, err := (&tsfHttp.TLSConfig{
+		CAFile:   filepath.Join(dir, "ca.pem"),
+		CertFile: filepath.Join(dir, "server.pem"),
+		KeyFile:  filepath.Join(dir, "server-key.pem"),
+	}).ServerConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	served := make(chan context.Context, 1)
+	srv := grpc.NewServer(grpc.Creds(credentials.NewTLS(srvTLS)), grpc.UnaryInterceptor(
+		func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+			ctx, err := startServerContext(ctx, "provider", "POST", info.FullMethod, "127.0.0.1:9000")
+			if err != nil {
+				return nil, err
+			}
+			served <- ctx
+			return handler(ctx, req)
+		}))
+	pb.RegisterHealthServer(srv, health.NewServer())
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go srv.Serve(lis)
+	defer srv.Stop()
+
+	cliTLS, err := (&tsfHttp.TLSConfig{
+		CAFile:     filepath.Join(dir, "ca.pem"),
+		CertFile:   filepath.Join(dir, "consumer.pem"),
+		KeyFile:    filepath.Join(dir, "consumer-key.pem"),
+		ServerSANs: []string{"127.0.0.1"},
+	}).ClientConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	conn, err := grpc.DialContext(ctx, lis.Addr().String(), grpc.WithTransportCredentials(credentials.NewTLS(cliTLS)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	if _, err = pb.NewHealthClient(conn).Check(ctx, &pb.HealthCheckRequest{}); err != nil {
+		t.Fatalf("mtls call failed: %v", err)
+	}
+
+	ctx = <-served
+	if san := meta.Sys(ctx, meta.SourceKey(meta.TLSSAN)); san != "consumer.default.svc,spiffe://cluster.local/ns/default/sa/consumer" {
+		t.Fatalf("unexpected source.tls.san %v", san)
+	}
+	if cn := meta.Sys(ctx, meta.SourceKey(meta.TLSCommonName)); cn != "consumer" {
+		t.Fatalf("unexpected source.tls.cn %v", cn)
+	}
+}
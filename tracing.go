@@ -111,8 +111,7 @@ func tracingClient() middleware.Middleware {
 				span.SetAttributes(attribute.String("local.ip", localEndpoint.IP))
 				span.SetAttributes(attribute.Int64("local.port", int64(localEndpoint.Port)))
 
-				remoteService, _ := util.ParseTarget(tr.Endpoint())
-				span.SetAttributes(attribute.String("peer.service", remoteService))
+				span.SetAttributes(attribute.String("peer.service", remoteService(tr.Endpoint()).Name))
 				span.SetAttributes(attribute.String("http.method", method))
 				span.SetAttributes(attribute.String("http.path", path))
 				defer func() {